@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"github.com/straup/go-image-tools/util"
+	"log"
+	"os"
+)
+
+func main() {
+
+	// new images are named like the images they came from so an existing
+	// file is as likely to be another source image (foo.jpg alongside
+	// foo.png) as an earlier conversion; don't replace it unless asked to
+
+	opts := util.NewDefaultCommandOptions()
+	opts.Output.Template = "{stem}.{ext}"
+	opts.Output.Overwrite = "error"
+
+	opts.RegisterFlags(flag.CommandLine)
+	opts.RegisterFileFlag(flag.CommandLine)
+
+	flag.Lookup("format").Usage = "The format to convert images to: jpeg, png, gif, tiff or bmp. Required unless -output has one of their extensions."
+
+	flag.Parse()
+
+	err := opts.Apply(flag.Args())
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// unlike the other tools there has to be a format, although it can come
+	// from the extension of an -output file
+
+	format, err := util.NormalizeFormat(opts.Format)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	writer := util.NewOutputWriter(opts.Output, opts.File, "convert", nil)
+
+	// convertImage converts in, found at f (or nil for stdin), writing the
+	// result wherever -output-dir, -output-template or -output say
+
+	convertImage := func(in *util.Input, f *util.SourceFile) error {
+
+		// converting an image to the format it's already in is just a
+		// roundabout way of making it worse; what matters is what's in the
+		// file, not what it's called
+
+		in_format, err := in.Format()
+
+		if err != nil {
+			return err
+		}
+
+		if in_format == format {
+
+			name := "stdin"
+
+			if f != nil {
+				name = f.Source.Path(f.Name)
+			}
+
+			log.Printf("%s is already a %s image, skipping\n", name, format)
+			return nil
+		}
+
+		im, _, err := in.DecodeImageWithOptions(opts.Decode)
+
+		if err != nil {
+			return err
+		}
+
+		file_opts, err := opts.FileEncodeOptions(in)
+
+		if err != nil {
+			return err
+		}
+
+		dims := im.Bounds()
+
+		new_path, err := writer.Path(f, "convert", format, dims.Dx(), dims.Dy())
+
+		if err != nil {
+			return err
+		}
+
+		return writer.WriteImage(new_path, im, format, "", file_opts)
+	}
+
+	if opts.Stdin {
+
+		in, err := util.NewInputFromReader(os.Stdin)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = convertImage(in, nil)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	cb := func(f *util.SourceFile) error {
+
+		return convertImage(util.NewInputFromPath(f.Path), f)
+	}
+
+	err = opts.RunBatch("convert-", flag.Args(), cb)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
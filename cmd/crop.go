@@ -1,61 +1,613 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/iand/salience"
+	"github.com/straup/go-image-tools/crop"
 	"github.com/straup/go-image-tools/util"
+	"image"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 )
 
 func main() {
 
-	width := flag.Int("width", 200, "...")
-	height := flag.Int("height", 200, "...")
+	width := flag.Int("width", 200, "The width of the crop, in pixels.")
+	height := flag.Int("height", 200, "The height of the crop, in pixels.")
+	strategy := flag.String("strategy", "salience", "How to choose what to keep: salience, face, seam (seam carving) or trim (remove borders).")
+	protect_mask := flag.String("protect-mask", "", "The path to a greyscale mask image whose white areas should be kept, for the seam strategy.")
+	remove_mask := flag.String("remove-mask", "", "The path to a greyscale mask image whose white areas should be removed first, for the seam strategy.")
+	sidecar := flag.Bool("sidecar", false, "Read hints from a JSON sidecar file next to each image (foo.jpg.json or foo.json).")
+	xmp := flag.Bool("xmp", false, "Read hints from the MWG regions in each image's XMP metadata, or a foo.xmp sidecar file.")
+	manifest := flag.String("manifest", "", "The path to a CSV file of hints for images, listed by path.")
+	boxes := flag.String("boxes", "", "The path to a CSV, JSON, COCO or Pascal VOC file (or a directory of them) listing regions to crop. One image is written for each region.")
+	gravity := flag.String("gravity", "", "Where to crop from, instead of using -strategy: center, top, bottom-left and so on, or compass directions like north-east.")
+	offset := flag.String("offset", "", "Crop from this point, as x,y in pixels, instead of using -strategy.")
+	resize := flag.Bool("resize", false, "Crop the largest window with the same aspect ratio as -width and -height and then resize it to those dimensions.")
+	tolerance := flag.Float64("tolerance", 24.0, "How close to the border colour a row or column needs to be to be trimmed, for the trim strategy.")
+	debug := flag.Bool("debug", false, "Log how each crop was chosen, including any faces that were found.")
+	debug_image := flag.Bool("debug-image", false, "Also write a foo-crop-debug image showing the salience heatmap, candidate windows, hints, faces and the final crop.")
+
+	opts := util.NewDefaultCommandOptions()
+	opts.RegisterFlags(flag.CommandLine)
+	opts.RegisterFileFlag(flag.CommandLine)
+	opts.RegisterCacheFlags(flag.CommandLine)
+	opts.RegisterSkipOutputsFlag(flag.CommandLine)
 
 	flag.Parse()
 
-	for _, path := range flag.Args() {
+	err := opts.Apply(flag.Args())
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// with -skip-outputs leave out the crops, debugging images and -boxes
+	// crops (which have a label and a number) from earlier runs
+
+	opts.SkipOutputsFor(`crop(-[a-z0-9_\-]+)?`)
+
+	if opts.File == "-" && *debug_image {
+		log.Fatal("Debugging images can not be written to stdout")
+	}
+
+	if *boxes != "" {
 
-		abs_path, err := filepath.Abs(path)
+		if opts.File != "" {
+			log.Fatal("-boxes writes more than one image so it can not be used with -output or stdin")
+		}
+
+		writer := util.NewOutputWriter(opts.Output, "", "crop", nil)
+
+		err = CropBoxes(*boxes, flag.Args(), opts, writer)
 
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		im, format, err := util.DecodeImage(abs_path)
+		return
+	}
+
+	var crop_offset *image.Point
+
+	if *offset != "" {
+
+		pt, err := crop.ParseOffset(*offset)
 
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		cropped := salience.Crop(im, *width, *height)
+		crop_offset = &pt
+	}
+
+	var hints_manifest crop.HintsManifest
+
+	if *manifest != "" {
+
+		m, err := crop.HintsManifestFromCSV(*manifest)
+
+		if err != nil {
+			log.Fatal(err)
+		}
 
-		root := filepath.Dir(abs_path)
-		fname := filepath.Base(abs_path)
-		ext := filepath.Ext(abs_path)
+		hints_manifest = m
+	}
 
-		new_ext := fmt.Sprintf("-%s%s", "crop", ext)
-		fname = strings.Replace(fname, ext, new_ext, -1)
+	var protect image.Image
+	var remove image.Image
 
-		new_path := filepath.Join(root, fname)
+	if *protect_mask != "" {
 
-		fh, err := os.Create(new_path)
+		im, _, err := util.DecodeImage(*protect_mask)
 
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		defer fh.Close()
+		protect = im
+	}
+
+	if *remove_mask != "" {
 
-		err = util.EncodeImage(cropped, format, fh)
+		im, _, err := util.DecodeImage(*remove_mask)
 
 		if err != nil {
 			log.Fatal(err)
 		}
+
+		remove = im
+	}
+
+	cache, err := opts.NewCache()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the masks are part of the cache key, by content rather than by path
+
+	mask_keys := make([]string, 0)
+
+	if cache != nil {
+
+		for _, mask_path := range []string{*protect_mask, *remove_mask} {
+
+			if mask_path == "" {
+				mask_keys = append(mask_keys, "")
+				continue
+			}
+
+			key, err := cache.Key(mask_path, "mask")
+
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			mask_keys = append(mask_keys, key)
+		}
 	}
 
+	writer := util.NewOutputWriter(opts.Output, opts.File, "crop", cache)
+
+	// cropImage crops in, found at f (or nil for stdin), writing the result
+	// wherever -output-dir, -output-template or -output say
+
+	cropImage := func(in *util.Input, f *util.SourceFile, crop_opts crop.CropOptions) error {
+
+		// the cache is keyed by everything that goes in to a crop, including
+		// the hints, but not debugging images which aren't cached
+
+		var cache_key string
+
+		if cache != nil && !*debug_image {
+
+			key, err := cache.KeyFromInput(in, "crop", *width, *height, *strategy, *gravity, *offset, *resize, *tolerance, crop_opts.Hints, mask_keys, opts.Decode.AutoOrient, opts.Decode.ColourManage, opts.Metadata, opts.Format, opts.Encode)
+
+			if err != nil {
+				return err
+			}
+
+			ok, err := writer.WriteCached(f, "crop", key)
+
+			if err != nil || ok {
+				return err
+			}
+
+			cache_key = key
+		}
+
+		// animated GIFs are cropped frame by frame, using the same window
+		// for every frame, but only if we're writing a GIF
+
+		animate := opts.Format == "" || opts.Format == "gif"
+
+		im, anim, in_format, err := in.DecodeWithOptions(opts.Decode, animate)
+
+		if err != nil {
+			return err
+		}
+
+		file_opts, err := opts.FileEncodeOptions(in)
+
+		if err != nil {
+			return err
+		}
+
+		format := opts.OutputFormat(in_format)
+
+		// debugging images are the same size as the image being cropped
+
+		dims := im.Bounds()
+
+		var debug_path string
+
+		if *debug_image {
+
+			debug_path, err = writer.Path(f, "crop-debug", format, dims.Dx(), dims.Dy())
+
+			if err != nil {
+				return err
+			}
+
+			ok, err := opts.Output.Check(debug_path)
+
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				log.Printf("%s already exists, skipping\n", debug_path)
+				debug_path = ""
+			}
+		}
+
+		if anim != nil {
+
+			cropped, err := CropAnimation(anim, crop_opts, debug_path, opts.Encode)
+
+			if err != nil {
+				return err
+			}
+
+			cropped_dims := cropped.Frames[0].Bounds()
+
+			new_path, err := writer.Path(f, "crop", format, cropped_dims.Dx(), cropped_dims.Dy())
+
+			if err != nil {
+				return err
+			}
+
+			return writer.WriteAnimation(new_path, cropped, cache_key, opts.Encode)
+		}
+
+		var cropped image.Image
+
+		if debug_path != "" {
+
+			trace, err := crop.TraceCropRectangle(im, crop_opts)
+
+			if err != nil {
+				return err
+			}
+
+			cropped = crop.CropImageWithOptions(im, trace.Crop, crop_opts)
+
+			err = writeImage(crop.DebugImage(im, trace), format, debug_path, opts.Encode)
+
+			if err != nil {
+				return err
+			}
+
+		} else {
+
+			cropped, err = crop.Crop(im, crop_opts)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		cropped_dims := cropped.Bounds()
+
+		new_path, err := writer.Path(f, "crop", format, cropped_dims.Dx(), cropped_dims.Dy())
+
+		if err != nil {
+			return err
+		}
+
+		return writer.WriteImage(new_path, cropped, format, cache_key, file_opts)
+	}
+
+	cropOptions := func() crop.CropOptions {
+
+		crop_opts := crop.NewDefaultCropOptions()
+		crop_opts.Width = *width
+		crop_opts.Height = *height
+		crop_opts.Strategy = *strategy
+		crop_opts.ProtectMask = protect
+		crop_opts.RemoveMask = remove
+		crop_opts.Gravity = *gravity
+		crop_opts.Offset = crop_offset
+		crop_opts.Resize = *resize
+		crop_opts.Tolerance = *tolerance
+		crop_opts.Debug = *debug
+
+		return crop_opts
+	}
+
+	// there are no sidecar files, or anything to look up in a manifest, for
+	// an image read from stdin
+
+	if opts.Stdin {
+
+		in, err := util.NewInputFromReader(os.Stdin)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = cropImage(in, nil, cropOptions())
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	cb := func(f *util.SourceFile) error {
+
+		crop_opts := cropOptions()
+
+		if hints_manifest != nil {
+			crop_opts.Hints = append(crop_opts.Hints, hints_manifest.Lookup(manifestPath(f))...)
+		}
+
+		if *sidecar {
+
+			hints, err := crop.HintsFromSidecarFS(f.Source.FS, f.Name)
+
+			if err != nil {
+				return err
+			}
+
+			crop_opts.Hints = append(crop_opts.Hints, hints...)
+		}
+
+		if *xmp {
+
+			hints, err := crop.HintsFromXMPFS(f.Source.FS, f.Name)
+
+			if err != nil {
+				return err
+			}
+
+			crop_opts.Hints = append(crop_opts.Hints, hints...)
+		}
+
+		return cropImage(util.NewInputFromPath(f.Path), f, crop_opts)
+	}
+
+	err = opts.RunBatch("crop-", flag.Args(), cb)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// CropBoxes writes one crop for each of the explicit regions listed in the
+// manifest (or annotations) file at boxes_path. If paths is empty then every
+// image listed in the manifest is processed, relative to the manifest. Images
+// are decoded and encoded according to opts, and processed (and failures
+// reported) by opts.RunBatch, and crops are written by writer.
+
+func CropBoxes(boxes_path string, paths []string, opts *util.CommandOptions, writer *util.OutputWriter) error {
+
+	m, err := crop.HintsManifestFromPath(boxes_path)
+
+	if err != nil {
+		return err
+	}
+
+	// keys maps the absolute path of each image listed in the manifest to
+	// the path it is listed under
+
+	keys := make(map[string]string)
+
+	if len(paths) == 0 {
+
+		root := boxes_path
+
+		info, err := os.Stat(boxes_path)
+
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			root = filepath.Dir(boxes_path)
+		}
+
+		sorted := make([]string, 0)
+
+		for k := range m {
+			sorted = append(sorted, k)
+		}
+
+		sort.Strings(sorted)
+
+		for _, k := range sorted {
+
+			path := k
+
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(root, path)
+			}
+
+			abs_path, err := filepath.Abs(path)
+
+			if err != nil {
+				return err
+			}
+
+			keys[abs_path] = k
+			paths = append(paths, path)
+		}
+	}
+
+	cb := func(f *util.SourceFile) error {
+
+		key := manifestPath(f)
+
+		if f.Source.IsLocal() {
+
+			abs_path, err := filepath.Abs(f.Source.Path(f.Name))
+
+			if err != nil {
+				return err
+			}
+
+			k, ok := keys[abs_path]
+
+			if ok {
+				key = k
+			}
+		}
+
+		regions := m.Lookup(key)
+
+		if len(regions) == 0 {
+			return nil
+		}
+
+		in := util.NewInputFromPath(f.Path)
+
+		animate := opts.Format == "" || opts.Format == "gif"
+
+		im, anim, in_format, err := in.DecodeWithOptions(opts.Decode, animate)
+
+		if err != nil {
+			return err
+		}
+
+		file_opts, err := opts.FileEncodeOptions(in)
+
+		if err != nil {
+			return err
+		}
+
+		format := opts.OutputFormat(in_format)
+
+		// images are mirrored according to their path in the manifest (or
+		// on the command line) as long as it's below the current directory
+
+		rel_path := filepath.ToSlash(filepath.Clean(key))
+
+		if filepath.IsAbs(key) || strings.HasPrefix(rel_path, "../") {
+			rel_path = ""
+		}
+
+		dims := im.Bounds()
+
+		for i, r := range regions {
+
+			bounds := r.Rectangle(dims)
+
+			if bounds.Empty() {
+				continue
+			}
+
+			suffix := fmt.Sprintf("crop-%d", i+1)
+
+			if r.Label != "" {
+				suffix = fmt.Sprintf("crop-%s-%d", safeLabel(r.Label), i+1)
+			}
+
+			new_path, err := opts.Output.Path(f.OutputPath, rel_path, suffix, format, bounds.Dx(), bounds.Dy())
+
+			if err != nil {
+				return err
+			}
+
+			if anim != nil {
+
+				cropped, err := anim.Apply(func(frame image.Image) (image.Image, error) {
+					return crop.CropImage(frame, bounds), nil
+				})
+
+				if err != nil {
+					return err
+				}
+
+				err = writer.WriteAnimation(new_path, cropped, "", opts.Encode)
+
+			} else {
+
+				err = writer.WriteImage(new_path, crop.CropImage(im, bounds), format, "", file_opts)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return opts.RunBatch("crop-", paths, cb)
+}
+
+// CropAnimation crops every frame of anim using the same window, chosen using
+// the average of all its frames. If debug_path isn't empty a debugging image,
+// for the average frame, is written there.
+
+func CropAnimation(anim *util.Animation, opts crop.CropOptions, debug_path string, encode_opts util.EncodeOptions) (*util.Animation, error) {
+
+	if opts.Strategy == "seam" {
+		return nil, errors.New("Seam carving animated GIFs is not supported")
+	}
+
+	avg := crop.AverageImage(anim.Frames)
+
+	var r image.Rectangle
+
+	if debug_path != "" {
+
+		trace, err := crop.TraceCropRectangle(avg, opts)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = writeImage(crop.DebugImage(avg, trace), "gif", debug_path, encode_opts)
+
+		if err != nil {
+			return nil, err
+		}
+
+		r = trace.Crop
+
+	} else {
+
+		rect, err := crop.CropRectangle(avg, opts)
+
+		if err != nil {
+			return nil, err
+		}
+
+		r = rect
+	}
+
+	return anim.Apply(func(frame image.Image) (image.Image, error) {
+		return crop.CropImageWithOptions(frame, r, opts), nil
+	})
+}
+
+// manifestPath returns the path to look f up by in a hints manifest, which is
+// the path relative to the current directory for files on disk (the same as
+// it would have been on the command line) or the path in the archive
+
+func manifestPath(f *util.SourceFile) string {
+
+	if !f.Source.IsLocal() {
+		return f.Name
+	}
+
+	abs_path := f.Source.Path(f.Name)
+
+	cwd, err := os.Getwd()
+
+	if err != nil {
+		return abs_path
+	}
+
+	rel_path, err := filepath.Rel(cwd, abs_path)
+
+	if err != nil {
+		return abs_path
+	}
+
+	return rel_path
+}
+
+var re_unsafe = regexp.MustCompile(`[^a-zA-Z0-9_\-]+`)
+
+func safeLabel(label string) string {
+
+	label = strings.ToLower(strings.TrimSpace(label))
+	return re_unsafe.ReplaceAllString(label, "_")
+}
+
+func writeImage(im image.Image, format string, path string, encode_opts util.EncodeOptions) error {
+
+	return util.WriteOutput(path, util.FormatExtension(format), nil, "", func(wr io.Writer) error {
+		return util.EncodeImageWithOptions(im, format, wr, encode_opts)
+	})
 }
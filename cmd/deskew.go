@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/straup/go-image-tools/deskew"
+	"github.com/straup/go-image-tools/util"
+	"log"
+)
+
+func main() {
+
+	max_angle := flag.Float64("max-angle", 10.0, "The largest skew, in degrees either way, to look for.")
+	fill := flag.String("fill", "white", "The colour to paint the corners exposed by rotating an image: white, black, transparent or #rrggbb.")
+	dryrun := flag.Bool("dryrun", false, "Only print the skew of each image, without writing anything.")
+
+	opts := util.NewDefaultCommandOptions()
+	opts.RegisterFlags(flag.CommandLine)
+	opts.RegisterSkipOutputsFlag(flag.CommandLine)
+
+	flag.Parse()
+
+	err := opts.Apply(flag.Args())
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.SkipOutputsFor("deskew")
+
+	fill_colour, err := deskew.ParseFill(*fill)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	deskew_opts := deskew.NewDefaultDeskewOptions()
+	deskew_opts.MaxAngle = *max_angle
+	deskew_opts.Fill = fill_colour
+
+	writer := util.NewOutputWriter(opts.Output, "", "deskew", nil)
+
+	cb := func(f *util.SourceFile) error {
+
+		in := util.NewInputFromPath(f.Path)
+
+		im, format, err := in.DecodeImageWithOptions(opts.Decode)
+
+		if err != nil {
+			return err
+		}
+
+		file_opts, err := opts.FileEncodeOptions(in)
+
+		if err != nil {
+			return err
+		}
+
+		format = opts.OutputFormat(format)
+
+		if *dryrun {
+
+			angle, err := deskew.DetectSkew(im, deskew_opts)
+
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("%s %0.2f\n", f.Source.Path(f.Name), angle)
+			return nil
+		}
+
+		deskewed, angle, err := deskew.Deskew(im, deskew_opts)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s %0.2f\n", f.Source.Path(f.Name), angle)
+
+		dims := deskewed.Bounds()
+
+		new_path, err := writer.Path(f, "deskew", format, dims.Dx(), dims.Dy())
+
+		if err != nil {
+			return err
+		}
+
+		return writer.WriteImage(new_path, deskewed, format, "", file_opts)
+	}
+
+	err = opts.RunBatch("deskew-", flag.Args(), cb)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
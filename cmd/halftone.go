@@ -2,66 +2,153 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"github.com/straup/go-image-tools/halftone"
 	"github.com/straup/go-image-tools/util"
+	"image"
 	"log"
 	"os"
-	"path/filepath"
-	"strings"
+	"regexp"
 )
 
 func main() {
 
-	mode := flag.String("mode", "atkinson", "...")
-	scale_factor := flag.Float64("scale-factor", 2.0, "...")
+	mode := flag.String("mode", "atkinson", "The halftoning method: atkinson (dithering) or threshold.")
+	scale_factor := flag.Float64("scale-factor", 2.0, "Shrink images by this much before halftoning them, and then scale them back up.")
+
+	opts := util.NewDefaultCommandOptions()
+	opts.RegisterFlags(flag.CommandLine)
+	opts.RegisterFileFlag(flag.CommandLine)
+	opts.RegisterCacheFlags(flag.CommandLine)
+	opts.RegisterSkipOutputsFlag(flag.CommandLine)
 
 	flag.Parse()
 
-	for _, path := range flag.Args() {
+	err := opts.Apply(flag.Args())
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts.SkipOutputsFor(regexp.QuoteMeta(*mode))
+
+	cache, err := opts.NewCache()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	writer := util.NewOutputWriter(opts.Output, opts.File, *mode, cache)
+
+	// halftoneImage halftones in, found at f (or nil for stdin), writing the
+	// result wherever -output-dir, -output-template or -output say
+
+	halftoneImage := func(in *util.Input, f *util.SourceFile) error {
+
+		var cache_key string
 
-		abs_path, err := filepath.Abs(path)
+		if cache != nil {
+
+			key, err := cache.KeyFromInput(in, "halftone", *mode, *scale_factor, opts.Decode.AutoOrient, opts.Decode.ColourManage, opts.Metadata, opts.Format, opts.Encode)
+
+			if err != nil {
+				return err
+			}
+
+			ok, err := writer.WriteCached(f, *mode, key)
+
+			if err != nil || ok {
+				return err
+			}
+
+			cache_key = key
+		}
+
+		// animated GIFs are halftoned frame by frame, but only if we're
+		// writing a GIF; otherwise there's nowhere to put the other frames
+
+		animate := opts.Format == "" || opts.Format == "gif"
+
+		im, anim, in_format, err := in.DecodeWithOptions(opts.Decode, animate)
 
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
-		im, format, err := util.DecodeImage(abs_path)
+		file_opts, err := opts.FileEncodeOptions(in)
 
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
-		opts := halftone.NewDefaultHalftoneOptions()
-		opts.Mode = *mode
-		opts.ScaleFactor = *scale_factor
+		format := opts.OutputFormat(in_format)
 
-		dithered, err := halftone.Halftone(im, opts)
+		halftone_opts := halftone.NewDefaultHalftoneOptions()
+		halftone_opts.Mode = *mode
+		halftone_opts.ScaleFactor = *scale_factor
+
+		if anim != nil {
+
+			dithered, err := anim.Apply(func(frame image.Image) (image.Image, error) {
+				return halftone.Halftone(frame, halftone_opts)
+			})
+
+			if err != nil {
+				return err
+			}
+
+			dims := dithered.Frames[0].Bounds()
+
+			new_path, err := writer.Path(f, *mode, format, dims.Dx(), dims.Dy())
+
+			if err != nil {
+				return err
+			}
+
+			return writer.WriteAnimation(new_path, dithered, cache_key, opts.Encode)
+		}
+
+		dithered, err := halftone.Halftone(im, halftone_opts)
 
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
-		root := filepath.Dir(abs_path)
-		fname := filepath.Base(abs_path)
-		ext := filepath.Ext(abs_path)
+		dims := dithered.Bounds()
 
-		new_ext := fmt.Sprintf("-%s%s", *mode, ext)
-		fname = strings.Replace(fname, ext, new_ext, -1)
+		new_path, err := writer.Path(f, *mode, format, dims.Dx(), dims.Dy())
 
-		new_path := filepath.Join(root, fname)
+		if err != nil {
+			return err
+		}
+
+		return writer.WriteImage(new_path, dithered, format, cache_key, file_opts)
+	}
 
-		fh, err := os.Create(new_path)
+	if opts.Stdin {
+
+		in, err := util.NewInputFromReader(os.Stdin)
 
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		err = util.EncodeImage(dithered, format, fh)
+		err = halftoneImage(in, nil)
 
 		if err != nil {
 			log.Fatal(err)
 		}
 
+		return
+	}
+
+	cb := func(f *util.SourceFile) error {
+
+		return halftoneImage(util.NewInputFromPath(f.Path), f)
+	}
+
+	err = opts.RunBatch("halftone-", flag.Args(), cb)
+
+	if err != nil {
+		log.Fatal(err)
 	}
 }
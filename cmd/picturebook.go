@@ -1,10 +1,11 @@
 package main
 
 import (
-	"errors"
 	"flag"
+	"fmt"
 	"github.com/straup/go-image-tools/picturebook"
 	"github.com/straup/go-image-tools/picturebook/functions"
+	"github.com/straup/go-image-tools/util"
 	"log"
 	"os"
 )
@@ -22,24 +23,44 @@ func main() {
 
 func Picturebook() error {
 
-	var orientation = flag.String("orientation", "P", "...")
-	var size = flag.String("size", "letter", "...")
-	var width = flag.Float64("width", 8.5, "...")
-	var height = flag.Float64("height", 11, "...")
-	var dpi = flag.Float64("dpi", 150, "...")
-	var border = flag.Float64("border", 0.01, "...")
-	var caption = flag.String("caption", "default", "...")
-	var filename = flag.String("filename", "picturebook.pdf", "...")
-	var target = flag.String("target", "", "...")
-	var debug = flag.Bool("debug", false, "...")
-
-	var include picturebook.RegexpFlag
-	var exclude picturebook.RegexpFlag
+	var orientation = flag.String("orientation", "P", "The orientation of the pages: P (portrait) or L (landscape).")
+	var size = flag.String("size", "letter", "The size of the pages: a3, a4, a5, letter, legal, tabloid or custom (using -width and -height).")
+	var width = flag.Float64("width", 8.5, "The width of the pages, in inches, if -size is custom.")
+	var height = flag.Float64("height", 11, "The height of the pages, in inches, if -size is custom.")
+	var dpi = flag.Float64("dpi", 150, "The resolution, in dots per inch, to lay out images at.")
+	var border = flag.Float64("border", 0.01, "The width, in inches, of the black border drawn around each image (0 for none).")
+	var caption = flag.String("caption", "default", "How to caption images: default (the filename), filename, parent (the directory and filename), cooperhewitt, flickr or none.")
+	var filename = flag.String("filename", "picturebook.pdf", "The path of the PDF file to write.")
+	var overwrite = flag.String("overwrite", "replace", "What to do when -filename already exists: replace, skip or error.")
+	var target = flag.String("target", "", "A shortcut for the captions of a particular collection: cooperhewitt or flickr.")
+	var colour_manage = flag.Bool("colour-manage", true, "Convert images with an embedded ICC colour profile to sRGB.")
+	var max_dimension = flag.Int("max-dimension", 50000, "The largest width or height, in pixels, of an image to decode (0 for no limit).")
+	var max_pixels = flag.Int64("max-pixels", 200000000, "The largest number of pixels in an image to decode (0 for no limit).")
+	var max_memory = flag.Int64("max-memory", 2048, "The most memory, in MB, that decoding an image may use (0 for no limit).")
+	var cache_dir = flag.String("cache-dir", "", "Cache pre-processed images in this directory, so that building the same picture book twice only does the work once.")
+	var cache_size = flag.Int64("cache-size", 1024, "The maximum size of the cache, in MB.")
+	var debug = flag.Bool("debug", false, "Log how each image is placed on the page.")
+	var jpeg_quality = flag.Int("jpeg-quality", 90, "The quality of JPEG images, from 1 to 100.")
+	var png_compression = flag.String("png-compression", "default", "The compression of PNG images: default, none, fast or best.")
+	var gif_colours = flag.Int("gif-colours", 256, "The number of colours in GIF images, from 2 to 256.")
+	var gif_quantizer = flag.String("gif-quantizer", "plan9", "How the colours in GIF images are chosen: plan9, websafe or median-cut.")
+	var gif_ditherer = flag.String("gif-ditherer", "floyd-steinberg", "How GIF images are dithered: floyd-steinberg or none.")
+
+	var recursive = flag.Bool("recursive", true, "Search directories all the way down rather than only the images directly inside them.")
+	var symlinks = flag.String("symlinks", "files", "What to do with symbolic links in directories: files (follow links to files), follow (follow all links) or skip.")
+	var extensions = flag.String("extensions", "", "A comma separated list of the file extensions to look for. The default is every image extension.")
+
+	var include util.RegexpFlag
+	var exclude util.RegexpFlag
+	var include_glob util.MultiFlag
+	var exclude_glob util.MultiFlag
 	var preprocess picturebook.PreProcessFlag
 
-	flag.Var(&include, "include", "...")
-	flag.Var(&exclude, "exclude", "...")
-	flag.Var(&preprocess, "pre-process", "...")
+	flag.Var(&include, "include", "Only include images whose path matches this regular expression. May be passed more than once.")
+	flag.Var(&exclude, "exclude", "Don't include images whose path matches this regular expression. May be passed more than once.")
+	flag.Var(&include_glob, "include-glob", "Only include images whose filename matches this glob pattern. May be passed more than once.")
+	flag.Var(&exclude_glob, "exclude-glob", "Don't include images whose filename matches this glob pattern. May be passed more than once.")
+	flag.Var(&preprocess, "pre-process", "Process images before adding them: rotate (according to their EXIF orientation), halftone, trim or deskew. May be passed more than once, in order.")
 
 	flag.Parse()
 
@@ -54,6 +75,29 @@ func Picturebook() error {
 		log.Fatal("Unknown or invalid target")
 	}
 
+	// there's only one file to write so the overwrite policy is checked
+	// before doing any work
+
+	output_opts := util.NewDefaultOutputOptions()
+	output_opts.Overwrite = *overwrite
+
+	err := output_opts.Validate()
+
+	if err != nil {
+		return err
+	}
+
+	ok, err := output_opts.Check(*filename)
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		log.Printf("%s already exists, skipping\n", *filename)
+		return nil
+	}
+
 	opts := picturebook.NewPictureBookDefaultOptions()
 	opts.Orientation = *orientation
 	opts.Size = *size
@@ -61,87 +105,105 @@ func Picturebook() error {
 	opts.Height = *height
 	opts.DPI = *dpi
 	opts.Border = *border
+	opts.ColourManage = *colour_manage
+	opts.MaxDimension = *max_dimension
+	opts.MaxPixels = *max_pixels
+	opts.MaxMemory = *max_memory * 1024 * 1024
 	opts.Debug = *debug
 
-	processed := make([]string, 0)
+	opts.Walk.Recursive = *recursive
+	opts.Walk.Symlinks = *symlinks
+	opts.Walk.Extensions = util.ParseExtensions(*extensions)
+	opts.Walk.Include = include
+	opts.Walk.Exclude = exclude
+	opts.Walk.IncludeGlob = include_glob
+	opts.Walk.ExcludeGlob = exclude_glob
 
-	defer func() {
-		for _, p := range processed {
+	err = opts.Walk.Validate()
 
-			go func(p string) {
-
-				_, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
 
-				if os.IsNotExist(err) {
-					return
-				}
+	encode_opts := util.NewDefaultEncodeOptions()
+	encode_opts.JPEGQuality = *jpeg_quality
+	encode_opts.PNGCompression = *png_compression
+	encode_opts.GIFColours = *gif_colours
+	encode_opts.GIFQuantizer = *gif_quantizer
+	encode_opts.GIFDitherer = *gif_ditherer
 
-				log.Println("WOULD REMOVE", p)
-				// os.Remove(p)
-			}(p)
-		}
-	}()
+	// all the pre-processed images end up in here, and are removed as soon
+	// as they've been added to the PDF file, and whatever is left is removed
+	// when we're done or interrupted
 
-	filter := func(path string) (bool, error) {
+	temp, err := util.NewTempStore("picturebook-")
 
-		for _, pat := range include {
+	if err != nil {
+		return err
+	}
 
-			if !pat.MatchString(path) {
-				return false, nil
-			}
-		}
+	defer temp.Close()
 
-		for _, pat := range exclude {
+	temp.CloseOnSignal()
 
-			if pat.MatchString(path) {
-				return false, nil
-			}
-		}
+	opts.TempStore = temp
 
-		return true, nil
-	}
+	pre_opts := functions.NewDefaultPreProcessOptions()
+	pre_opts.DecodeOptions.ColourManage = opts.ColourManage
+	pre_opts.DecodeOptions.MaxDimension = opts.MaxDimension
+	pre_opts.DecodeOptions.MaxPixels = opts.MaxPixels
+	pre_opts.DecodeOptions.MaxMemory = opts.MaxMemory
+	pre_opts.EncodeOptions = encode_opts
+	pre_opts.TempStore = temp
 
-	prep := func(path string) (string, error) {
+	if *cache_dir != "" {
 
-		final := path
+		cache, err := util.NewCache(*cache_dir, *cache_size*1024*1024)
 
-		for _, proc := range preprocess {
+		if err != nil {
+			return err
+		}
 
-			switch proc {
+		pre_opts.Cache = cache
+	}
 
-			case "rotate":
+	processes := map[string]func(string, functions.PreProcessOptions) (string, error){
+		"rotate":   functions.RotatePreProcessFuncWithOptions,
+		"halftone": functions.HalftonePreProcessFuncWithOptions,
+		"trim":     functions.TrimPreProcessFuncWithOptions,
+		"deskew":   functions.DeskewPreProcessFuncWithOptions,
+	}
 
-				processed_path, err := functions.RotatePreProcessFunc(final)
+	// check the steps now rather than failing (quietly) for every image
 
-				if err != nil {
-					return "", err
-				}
+	for _, proc := range preprocess {
 
-				if processed_path == "" {
-					continue
-				}
+		_, ok := processes[proc]
 
-				processed = append(processed, processed_path)
-				final = processed_path
+		if !ok {
+			return fmt.Errorf("Invalid or unsupported process '%s'", proc)
+		}
+	}
 
-			case "halftone":
+	prep := func(path string) (string, error) {
 
-				processed_path, err := functions.HalftonePreProcessFunc(final)
+		final := path
 
-				if err != nil {
-					return "", err
-				}
+		for _, proc := range preprocess {
 
-				if processed_path == "" {
-					continue
-				}
+			processed_path, err := processes[proc](final, pre_opts)
 
-				processed = append(processed, processed_path)
-				final = processed_path
+			if err != nil {
+				temp.Release(final)
+				return "", err
+			}
 
-			default:
-				return "", errors.New("Invalid or unsupported process")
+			if processed_path == "" {
+				continue
 			}
+
+			temp.Release(final)
+			final = processed_path
 		}
 
 		return final, nil
@@ -150,10 +212,9 @@ func Picturebook() error {
 	capt, err := functions.PictureBookCaptionFuncFromString(*caption)
 
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	opts.Filter = filter
 	opts.PreProcess = prep
 	opts.Caption = capt
 
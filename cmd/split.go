@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/straup/go-image-tools/split"
+	"github.com/straup/go-image-tools/util"
+	"io"
+	"log"
+	"strconv"
+)
+
+func main() {
+
+	tolerance := flag.Float64("tolerance", 32.0, "How different (0 - 255, in any one channel) a pixel can be from the scanner bed and still be part of it.")
+	min_area := flag.Float64("min-area", 0.01, "The smallest photo to look for, as a fraction of the area of the scan.")
+	padding := flag.Int("padding", 0, "The number of pixels of scanner bed to keep around each photo.")
+	deskew := flag.Bool("deskew", false, "Straighten each photo, and trim what's left of the scanner bed, after it has been found.")
+
+	opts := util.NewDefaultCommandOptions()
+	opts.RegisterFlags(flag.CommandLine)
+
+	flag.Parse()
+
+	err := opts.Apply(flag.Args())
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// unlike the other tools the images from earlier runs aren't left out
+	// since their names ("foo-1.jpg") look just like camera filenames
+
+	split_opts := split.NewDefaultSplitOptions()
+	split_opts.Tolerance = *tolerance
+	split_opts.MinArea = *min_area
+	split_opts.Padding = *padding
+	split_opts.Deskew = *deskew
+
+	cb := func(f *util.SourceFile) error {
+
+		in := util.NewInputFromPath(f.Path)
+
+		im, format, err := in.DecodeImageWithOptions(opts.Decode)
+
+		if err != nil {
+			return err
+		}
+
+		file_opts, err := opts.FileEncodeOptions(in)
+
+		if err != nil {
+			return err
+		}
+
+		format = opts.OutputFormat(format)
+
+		regions, err := split.Split(im, split_opts)
+
+		if err != nil {
+			return err
+		}
+
+		for i, r := range regions {
+
+			dims := r.Image.Bounds()
+			op := strconv.Itoa(i + 1)
+
+			new_path, err := opts.Output.Path(f.OutputPath, f.RelPath, op, format, dims.Dx(), dims.Dy())
+
+			if err != nil {
+				return err
+			}
+
+			ok, err := opts.Output.Check(new_path)
+
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				log.Printf("%s already exists, skipping\n", new_path)
+				continue
+			}
+
+			fmt.Printf("%s %v %0.2f\n", new_path, r.Bounds, r.Angle)
+
+			err = util.WriteOutput(new_path, util.FormatExtension(format), nil, "", func(wr io.Writer) error {
+				return util.EncodeImageWithOptions(r.Image, format, wr, file_opts)
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	err = opts.RunBatch("split-", flag.Args(), cb)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
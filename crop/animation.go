@@ -0,0 +1,43 @@
+package crop
+
+import (
+	"image"
+	"image/draw"
+)
+
+// AverageImage returns the mean of frames, which are expected to be the same
+// size. Crop rectangles for animations are chosen using the average frame so
+// that the same window can be applied to every frame and it covers what
+// happens over the whole animation, not just in the first frame (which is
+// often blank, or a title card).
+
+func AverageImage(frames []image.Image) image.Image {
+
+	if len(frames) == 0 {
+		return image.NewNRGBA(image.Rectangle{})
+	}
+
+	dims := frames[0].Bounds()
+	r := image.Rect(0, 0, dims.Dx(), dims.Dy())
+
+	sums := make([]int, r.Dx()*r.Dy()*4)
+
+	frame := image.NewNRGBA(r)
+
+	for _, im := range frames {
+
+		draw.Draw(frame, r, im, im.Bounds().Min, draw.Src)
+
+		for i, v := range frame.Pix {
+			sums[i] += int(v)
+		}
+	}
+
+	avg := image.NewNRGBA(r)
+
+	for i, v := range sums {
+		avg.Pix[i] = uint8(v / len(frames))
+	}
+
+	return avg
+}
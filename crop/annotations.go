@@ -0,0 +1,232 @@
+package crop
+
+// https://cocodataset.org/#format-data
+// http://host.robots.ox.ac.uk/pascal/VOC/voc2012/htmldoc/devkit_doc.html
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"github.com/tidwall/gjson"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HintsManifestFromPath reads a manifest of regions from path, choosing the
+// format based on its extension and (for JSON) its contents. CSV and JSON
+// manifests, COCO annotations and Pascal VOC annotations are supported. If
+// path is a directory every VOC annotation file in it is read.
+
+func HintsManifestFromPath(path string) (HintsManifest, error) {
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return HintsManifestFromVOCDirectory(path)
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+
+	switch ext {
+	case ".csv":
+		return HintsManifestFromCSV(path)
+	case ".xml":
+		return HintsManifestFromVOC(path)
+	case ".json":
+
+		body, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if gjson.GetBytes(body, "annotations").Exists() && gjson.GetBytes(body, "images").Exists() {
+			return HintsManifestFromCOCOBytes(body)
+		}
+
+		return HintsManifestFromJSONBytes(body)
+
+	default:
+		return nil, errors.New("Invalid or unsupported manifest")
+	}
+}
+
+// HintsManifestFromJSONBytes reads a list of regions, with the same properties
+// as the columns in a CSV manifest:
+//
+//	[ { "path": "foo.jpg", "x": 10, "y": 10, "w": 100, "h": 100, "label": "cat" } ]
+
+func HintsManifestFromJSONBytes(body []byte) (HintsManifest, error) {
+
+	var item interface{}
+	err := json.Unmarshal(body, &item)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := gjson.ParseBytes(body)
+
+	if !rsp.IsArray() {
+		return nil, errors.New("Manifest is not a list")
+	}
+
+	manifest := make(HintsManifest)
+
+	for _, r := range rsp.Array() {
+
+		path := r.Get("path").String()
+
+		if path == "" {
+			return nil, errors.New("Manifest item is missing a path")
+		}
+
+		h := Hint{
+			Label:      r.Get("label").String(),
+			X:          r.Get("x").Float(),
+			Y:          r.Get("y").Float(),
+			Width:      r.Get("w").Float(),
+			Height:     r.Get("h").Float(),
+			Normalized: r.Get("unit").String() == "normalized",
+		}
+
+		manifest[path] = append(manifest[path], h)
+	}
+
+	return manifest, nil
+}
+
+func HintsManifestFromCOCOBytes(body []byte) (HintsManifest, error) {
+
+	var item interface{}
+	err := json.Unmarshal(body, &item)
+
+	if err != nil {
+		return nil, err
+	}
+
+	images := make(map[int64]string)
+	categories := make(map[int64]string)
+
+	for _, r := range gjson.GetBytes(body, "images").Array() {
+		images[r.Get("id").Int()] = r.Get("file_name").String()
+	}
+
+	for _, r := range gjson.GetBytes(body, "categories").Array() {
+		categories[r.Get("id").Int()] = r.Get("name").String()
+	}
+
+	manifest := make(HintsManifest)
+
+	for _, r := range gjson.GetBytes(body, "annotations").Array() {
+
+		path, ok := images[r.Get("image_id").Int()]
+
+		if !ok {
+			continue
+		}
+
+		bbox := r.Get("bbox").Array()
+
+		if len(bbox) != 4 {
+			continue
+		}
+
+		h := Hint{
+			Label:  categories[r.Get("category_id").Int()],
+			X:      bbox[0].Float(),
+			Y:      bbox[1].Float(),
+			Width:  bbox[2].Float(),
+			Height: bbox[3].Float(),
+		}
+
+		manifest[path] = append(manifest[path], h)
+	}
+
+	return manifest, nil
+}
+
+type vocAnnotation struct {
+	Filename string      `xml:"filename"`
+	Objects  []vocObject `xml:"object"`
+}
+
+type vocObject struct {
+	Name   string    `xml:"name"`
+	BndBox vocBndBox `xml:"bndbox"`
+}
+
+type vocBndBox struct {
+	XMin float64 `xml:"xmin"`
+	YMin float64 `xml:"ymin"`
+	XMax float64 `xml:"xmax"`
+	YMax float64 `xml:"ymax"`
+}
+
+// HintsManifestFromVOC reads a single Pascal VOC annotation file. VOC pixel
+// coordinates start at 1 so they are shifted to start at 0 here.
+
+func HintsManifestFromVOC(path string) (HintsManifest, error) {
+
+	body, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var a vocAnnotation
+	err = xml.Unmarshal(body, &a)
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := make(HintsManifest)
+
+	for _, o := range a.Objects {
+
+		h := Hint{
+			Label:  o.Name,
+			X:      o.BndBox.XMin - 1,
+			Y:      o.BndBox.YMin - 1,
+			Width:  o.BndBox.XMax - (o.BndBox.XMin - 1),
+			Height: o.BndBox.YMax - (o.BndBox.YMin - 1),
+		}
+
+		manifest[a.Filename] = append(manifest[a.Filename], h)
+	}
+
+	return manifest, nil
+}
+
+func HintsManifestFromVOCDirectory(root string) (HintsManifest, error) {
+
+	paths, err := filepath.Glob(filepath.Join(root, "*.xml"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := make(HintsManifest)
+
+	for _, path := range paths {
+
+		m, err := HintsManifestFromVOC(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for k, hints := range m {
+			manifest[k] = append(manifest[k], hints...)
+		}
+	}
+
+	return manifest, nil
+}
@@ -0,0 +1,160 @@
+package crop
+
+import (
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const test_coco = `{
+  "images": [{"id": 1, "file_name": "a.jpg"}, {"id": 2, "file_name": "b.jpg"}],
+  "categories": [{"id": 7, "name": "cat"}, {"id": 8, "name": "dog"}],
+  "annotations": [
+    {"image_id": 1, "category_id": 7, "bbox": [10.5, 20, 30, 40]},
+    {"image_id": 1, "category_id": 8, "bbox": [100, 100, 50, 25]},
+    {"image_id": 2, "category_id": 9, "bbox": [0, 0, 10, 10]},
+    {"image_id": 3, "category_id": 7, "bbox": [0, 0, 10, 10]},
+    {"image_id": 2, "category_id": 7, "bbox": [0, 0, 10]}
+  ]
+}`
+
+const test_voc = `<annotation>
+  <filename>a.jpg</filename>
+  <size><width>400</width><height>300</height><depth>3</depth></size>
+  <object>
+    <name>cat</name>
+    <bndbox><xmin>1</xmin><ymin>1</ymin><xmax>100</xmax><ymax>50</ymax></bndbox>
+  </object>
+  <object>
+    <name>dog</name>
+    <bndbox><xmin>201</xmin><ymin>101</ymin><xmax>201</xmax><ymax>110</ymax></bndbox>
+  </object>
+</annotation>`
+
+const test_voc_b = `<annotation>
+  <filename>b.jpg</filename>
+  <object>
+    <name>bird</name>
+    <bndbox><xmin>11</xmin><ymin>21</ymin><xmax>20</xmax><ymax>40</ymax></bndbox>
+  </object>
+</annotation>`
+
+func TestHintsManifestFromPath(t *testing.T) {
+
+	root := t.TempDir()
+
+	files := map[string]string{
+		"manifest.csv":           "path,x,y,w,h,label\na.jpg,10,20,30,40,cat\n",
+		"manifest.json":          `[{"path": "a.jpg", "x": 0.1, "y": 0.2, "w": 0.3, "h": 0.4, "label": "cat", "unit": "normalized"}, {"path": "b.jpg", "x": 5, "y": 6}]`,
+		"coco.json":              test_coco,
+		"a.xml":                  test_voc,
+		"voc/a.xml":              test_voc,
+		"voc/b.xml":              test_voc_b,
+		"voc/notes.txt":          "not an annotation",
+		"not-a-list.json":        `{"path": "a.jpg"}`,
+		"missing-path.json":      `[{"x": 1, "y": 2}]`,
+		"broken.xml":             "<annotation><filename>",
+		"manifest.txt":           "a.jpg,1,2",
+		"empty-voc/.placeholder": "",
+	}
+
+	for name, body := range files {
+
+		path := filepath.Join(root, filepath.FromSlash(name))
+
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(path, []byte(body), 0644)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// VOC coordinates are 1-based and inclusive so a box from 1 to 100
+	// covers the first 100 pixels
+
+	voc_cat := Hint{Label: "cat", X: 0, Y: 0, Width: 100, Height: 50}
+	voc_dog := Hint{Label: "dog", X: 200, Y: 100, Width: 1, Height: 10}
+	voc_bird := Hint{Label: "bird", X: 10, Y: 20, Width: 10, Height: 20}
+
+	tests := []struct {
+		name     string
+		path     string
+		expected HintsManifest
+		ok       bool
+	}{
+		{"csv", "manifest.csv", HintsManifest{"a.jpg": {{Label: "cat", X: 10, Y: 20, Width: 30, Height: 40}}}, true},
+		{
+			"json",
+			"manifest.json",
+			HintsManifest{
+				"a.jpg": {{Label: "cat", X: 0.1, Y: 0.2, Width: 0.3, Height: 0.4, Normalized: true}},
+				"b.jpg": {{X: 5, Y: 6}},
+			},
+			true,
+		},
+		{
+			"coco",
+			"coco.json",
+			HintsManifest{
+				"a.jpg": {{Label: "cat", X: 10.5, Y: 20, Width: 30, Height: 40}, {Label: "dog", X: 100, Y: 100, Width: 50, Height: 25}},
+				"b.jpg": {{X: 0, Y: 0, Width: 10, Height: 10}},
+			},
+			true,
+		},
+		{"voc", "a.xml", HintsManifest{"a.jpg": {voc_cat, voc_dog}}, true},
+		{"voc directory", "voc", HintsManifest{"a.jpg": {voc_cat, voc_dog}, "b.jpg": {voc_bird}}, true},
+		{"empty directory", "empty-voc", HintsManifest{}, true},
+		{"not a list", "not-a-list.json", nil, false},
+		{"missing path", "missing-path.json", nil, false},
+		{"broken voc", "broken.xml", nil, false},
+		{"unsupported", "manifest.txt", nil, false},
+		{"missing", "nothing-here.csv", nil, false},
+	}
+
+	for _, test := range tests {
+
+		manifest, err := HintsManifestFromPath(filepath.Join(root, test.path))
+
+		if !test.ok {
+
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if len(manifest) != len(test.expected) {
+			t.Errorf("%s: expected %d images, got %d (%v)", test.name, len(test.expected), len(manifest), manifest)
+			continue
+		}
+
+		for path, expected := range test.expected {
+
+			if !sameHints(manifest[path], expected) {
+				t.Errorf("%s: expected %v for %s, got %v", test.name, expected, path, manifest[path])
+			}
+		}
+	}
+
+	// and that box ends up as the pixels it says
+
+	r := voc_cat.Rectangle(image.Rect(0, 0, 400, 300))
+
+	if r != image.Rect(0, 0, 100, 50) {
+		t.Errorf("Expected a VOC box from 1,1 to 100,50 to be (0,0)-(100,50), got %v", r)
+	}
+}
@@ -0,0 +1,216 @@
+package crop
+
+import (
+	"errors"
+	"github.com/nfnt/resize"
+	"image"
+	"image/draw"
+	"log"
+)
+
+type CropOptions struct {
+	Width       int
+	Height      int
+	Strategy    string
+	Hints       []Hint
+	ProtectMask image.Image
+	RemoveMask  image.Image
+	Gravity     string
+	Offset      *image.Point
+	Resize      bool
+	Tolerance   float64
+	Debug       bool
+}
+
+func NewDefaultCropOptions() CropOptions {
+
+	opts := CropOptions{
+		Width:     200,
+		Height:    200,
+		Strategy:  "salience",
+		Hints:     make([]Hint, 0),
+		Tolerance: 24.0,
+		Debug:     false,
+	}
+
+	return opts
+}
+
+func Crop(im image.Image, opts CropOptions) (image.Image, error) {
+
+	if opts.Width <= 0 || opts.Height <= 0 {
+		return nil, errors.New("Invalid crop dimensions")
+	}
+
+	switch opts.Strategy {
+	case "salience", "face":
+		// pass
+	case "seam":
+		return SeamCarve(im, opts.Width, opts.Height, opts.ProtectMask, opts.RemoveMask)
+	case "trim":
+		r := TrimRectangle(im, opts.Tolerance)
+		return CropImage(im, debugRectangle(r, opts)), nil
+	default:
+		return nil, errors.New("Invalid or unsupported strategy")
+	}
+
+	r, err := CropRectangle(im, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return CropImageWithOptions(im, r, opts), nil
+}
+
+// CropRectangle returns the window that Crop would cut from im. Hints, if
+// present, are always included in the window (or centered in it when they
+// are larger than the window) and salience is only used to decide where
+// the window goes within those constraints. An explicit offset or gravity
+// take precedence over everything else. If opts.Resize is true the window is
+// the largest one with the same aspect ratio as opts.Width x opts.Height. Seam
+// carving doesn't produce a window so it is not supported here.
+
+func CropRectangle(im image.Image, opts CropOptions) (image.Rectangle, error) {
+	return cropRectangle(im, opts, nil)
+}
+
+// TraceCropRectangle is the same as CropRectangle but it also records the
+// hints, faces and every candidate window that was considered along the way.
+// The result can be passed to DebugImage.
+
+func TraceCropRectangle(im image.Image, opts CropOptions) (*CropTrace, error) {
+
+	trace := CropTrace{
+		Candidates: make([]Candidate, 0),
+		Faces:      make([]Face, 0),
+	}
+
+	r, err := cropRectangle(im, opts, &trace)
+
+	if err != nil {
+		return nil, err
+	}
+
+	trace.Crop = r
+	return &trace, nil
+}
+
+func cropRectangle(im image.Image, opts CropOptions, trace *CropTrace) (image.Rectangle, error) {
+
+	dims := im.Bounds()
+
+	if opts.Width <= 0 || opts.Height <= 0 {
+		return image.Rectangle{}, errors.New("Invalid crop dimensions")
+	}
+
+	if opts.Strategy == "trim" {
+		r := TrimRectangle(im, opts.Tolerance)
+		return debugRectangle(r, opts), nil
+	}
+
+	if opts.Strategy != "salience" && opts.Strategy != "face" {
+		return image.Rectangle{}, errors.New("Invalid or unsupported strategy")
+	}
+
+	w := opts.Width
+	h := opts.Height
+
+	if opts.Resize {
+		w, h = AspectSize(dims, w, h)
+	}
+
+	if w > dims.Dx() {
+		w = dims.Dx()
+	}
+
+	if h > dims.Dy() {
+		h = dims.Dy()
+	}
+
+	if opts.Offset != nil {
+		r := OffsetRectangle(dims, w, h, *opts.Offset)
+		return debugRectangle(r, opts), nil
+	}
+
+	if opts.Gravity != "" {
+
+		r, err := GravityRectangle(dims, w, h, opts.Gravity)
+
+		if err != nil {
+			return image.Rectangle{}, err
+		}
+
+		return debugRectangle(r, opts), nil
+	}
+
+	grey := GreyImage(im)
+
+	target := HintsRectangle(opts.Hints, dims)
+
+	if opts.Debug && !target.Empty() {
+		log.Printf("hints %v\n", target)
+	}
+
+	if trace != nil {
+		trace.Hints = target
+	}
+
+	var faces []Face
+
+	if opts.Strategy == "face" {
+
+		f, err := DetectFaces(im)
+
+		if err != nil {
+			return image.Rectangle{}, err
+		}
+
+		if opts.Debug {
+
+			for _, face := range f {
+				log.Printf("face %v (%0.2f)\n", face.Bounds, face.Score)
+			}
+		}
+
+		if trace != nil {
+			trace.Faces = f
+		}
+
+		faces = f
+	}
+
+	r := searchRectangle(grey, w, h, target, faces, trace)
+	return debugRectangle(r, opts), nil
+}
+
+func debugRectangle(r image.Rectangle, opts CropOptions) image.Rectangle {
+
+	if opts.Debug {
+		log.Printf("crop %v\n", r)
+	}
+
+	return r
+}
+
+// CropImageWithOptions crops r from im and then, if opts.Resize is true,
+// scales the result to opts.Width x opts.Height.
+
+func CropImageWithOptions(im image.Image, r image.Rectangle, opts CropOptions) image.Image {
+
+	cropped := CropImage(im, r)
+
+	if opts.Resize && (r.Dx() != opts.Width || r.Dy() != opts.Height) {
+		cropped = resize.Resize(uint(opts.Width), uint(opts.Height), cropped, resize.Lanczos3)
+	}
+
+	return cropped
+}
+
+func CropImage(im image.Image, r image.Rectangle) image.Image {
+
+	cropped := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), im, r.Min, draw.Src)
+
+	return cropped
+}
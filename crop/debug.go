@@ -0,0 +1,133 @@
+package crop
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+type Candidate struct {
+	Bounds image.Rectangle
+	Score  float64
+}
+
+type CropTrace struct {
+	Crop       image.Rectangle
+	Hints      image.Rectangle
+	Faces      []Face
+	Candidates []Candidate
+}
+
+var heatmap_colour = color.NRGBA{255, 96, 0, 255}
+var candidate_colour = color.NRGBA{255, 255, 255, 96}
+var hints_colour = color.NRGBA{0, 128, 255, 255}
+var face_colour = color.NRGBA{0, 255, 0, 255}
+var crop_colour = color.NRGBA{255, 0, 0, 255}
+
+// DebugImage returns a copy of im with a salience heatmap, every candidate
+// window, any hints and faces and the final crop drawn on top of it.
+
+func DebugImage(im image.Image, trace *CropTrace) image.Image {
+
+	dims := im.Bounds()
+
+	canvas := image.NewRGBA(dims)
+	draw.Draw(canvas, dims, im, dims.Min, draw.Src)
+
+	drawHeatmap(canvas, GreyImage(im))
+
+	thickness := dims.Dx()
+
+	if dims.Dy() < thickness {
+		thickness = dims.Dy()
+	}
+
+	thickness = thickness / 400
+
+	if thickness < 1 {
+		thickness = 1
+	}
+
+	for _, c := range trace.Candidates {
+		drawOutline(canvas, c.Bounds, candidate_colour, 1)
+	}
+
+	if !trace.Hints.Empty() {
+		drawOutline(canvas, trace.Hints, hints_colour, thickness)
+	}
+
+	for _, f := range trace.Faces {
+		drawOutline(canvas, f.Bounds, face_colour, thickness)
+	}
+
+	drawOutline(canvas, trace.Crop, crop_colour, thickness*2)
+
+	return canvas
+}
+
+// drawHeatmap divides canvas in to a grid and tints each cell in proportion
+// to its entropy relative to the most salient cell.
+
+func drawHeatmap(canvas *image.RGBA, grey *image.Gray) {
+
+	dims := grey.Bounds()
+
+	cell := dims.Dx() / 16
+
+	if dims.Dy()/16 < cell {
+		cell = dims.Dy() / 16
+	}
+
+	if cell < 1 {
+		cell = 1
+	}
+
+	cells := make([]image.Rectangle, 0)
+	scores := make([]float64, 0)
+
+	max := 0.0
+
+	for y := dims.Min.Y; y < dims.Max.Y; y += cell {
+
+		for x := dims.Min.X; x < dims.Max.X; x += cell {
+
+			r := image.Rect(x, y, x+cell, y+cell).Intersect(dims)
+			e := Entropy(grey, r)
+
+			if e > max {
+				max = e
+			}
+
+			cells = append(cells, r)
+			scores = append(scores, e)
+		}
+	}
+
+	if max == 0.0 {
+		return
+	}
+
+	for i, r := range cells {
+
+		alpha := uint8(160.0 * (scores[i] / max))
+
+		mask := image.NewUniform(color.Alpha{alpha})
+		draw.DrawMask(canvas, r, image.NewUniform(heatmap_colour), image.Point{}, mask, image.Point{}, draw.Over)
+	}
+}
+
+func drawOutline(canvas *image.RGBA, r image.Rectangle, c color.Color, thickness int) {
+
+	src := image.NewUniform(c)
+
+	edges := []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+thickness),
+		image.Rect(r.Min.X, r.Max.Y-thickness, r.Max.X, r.Max.Y),
+		image.Rect(r.Min.X, r.Min.Y+thickness, r.Min.X+thickness, r.Max.Y-thickness),
+		image.Rect(r.Max.X-thickness, r.Min.Y+thickness, r.Max.X, r.Max.Y-thickness),
+	}
+
+	for _, e := range edges {
+		draw.Draw(canvas, e.Intersect(canvas.Bounds()), src, image.Point{}, draw.Over)
+	}
+}
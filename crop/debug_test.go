@@ -0,0 +1,185 @@
+package crop
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestDebugImage(t *testing.T) {
+
+	grey := color.RGBA{128, 128, 128, 255}
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 128, 255, 255}
+	green := color.RGBA{0, 255, 0, 255}
+
+	// a flat image, which has no salience to draw a heatmap for, so that
+	// it's just the outlines
+
+	flat := func(dims image.Rectangle) image.Image {
+
+		im := image.NewRGBA(dims)
+		draw.Draw(im, dims, image.NewUniform(grey), image.Point{}, draw.Src)
+		return im
+	}
+
+	trace := func(offset image.Point) *CropTrace {
+
+		tr := CropTrace{
+			Crop:       image.Rect(50, 20, 150, 80).Add(offset),
+			Hints:      image.Rect(60, 30, 100, 60).Add(offset),
+			Faces:      []Face{{Bounds: image.Rect(110, 30, 140, 60).Add(offset)}},
+			Candidates: []Candidate{{Bounds: image.Rect(0, 0, 100, 100).Add(offset)}},
+		}
+
+		return &tr
+	}
+
+	// the crop is drawn last, two pixels wide, and everything else one
+	// pixel wide; candidates are translucent
+
+	points := []struct {
+		name     string
+		pt       image.Point
+		expected color.Color
+	}{
+		{"crop corner", image.Pt(50, 20), red},
+		{"crop edge", image.Pt(51, 50), red},
+		{"inside the crop", image.Pt(52, 50), grey},
+		{"hints", image.Pt(60, 45), blue},
+		{"inside the hints", image.Pt(61, 45), grey},
+		{"face", image.Pt(139, 45), green},
+		{"inside the face", image.Pt(120, 45), grey},
+		{"candidate", image.Pt(0, 50), nil},
+		{"outside everything", image.Pt(10, 10), grey},
+	}
+
+	offset := image.Pt(30, 40)
+
+	tests := []struct {
+		name   string
+		im     image.Image
+		trace  *CropTrace
+		offset image.Point
+	}{
+		{"flat", flat(image.Rect(0, 0, 200, 100)), trace(image.Point{}), image.Point{}},
+		{"offset bounds", flat(image.Rect(0, 0, 200, 100).Add(offset)), trace(offset), offset},
+	}
+
+	for _, test := range tests {
+
+		debug := DebugImage(test.im, test.trace)
+
+		if debug.Bounds() != test.im.Bounds() {
+			t.Errorf("%s: expected bounds %v, got %v", test.name, test.im.Bounds(), debug.Bounds())
+			continue
+		}
+
+		for _, p := range points {
+
+			pt := p.pt.Add(test.offset)
+			got := color.RGBAModel.Convert(debug.At(pt.X, pt.Y)).(color.RGBA)
+
+			if p.expected == nil {
+
+				if got == grey || got == (color.RGBA{255, 255, 255, 255}) {
+					t.Errorf("%s: %s: expected a translucent outline, got %v", test.name, p.name, got)
+				}
+
+				continue
+			}
+
+			if got != p.expected {
+				t.Errorf("%s: %s: expected %v, got %v", test.name, p.name, p.expected, got)
+			}
+		}
+	}
+
+	// the heatmap tints the busy parts of an image, and only those
+
+	busy := image.NewRGBA(image.Rect(0, 0, 160, 160))
+	draw.Draw(busy, busy.Bounds(), image.NewUniform(grey), image.Point{}, draw.Src)
+
+	for y := 0; y < 40; y++ {
+
+		for x := 0; x < 40; x++ {
+			v := uint8((x*37 + y*91 + x*y*13) % 256)
+			busy.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+
+	tr := CropTrace{Crop: image.Rect(0, 0, 40, 40)}
+	debug := DebugImage(busy, &tr)
+
+	before := color.RGBAModel.Convert(busy.At(20, 20)).(color.RGBA)
+	after := color.RGBAModel.Convert(debug.At(20, 20)).(color.RGBA)
+
+	if before == after {
+		t.Errorf("Expected the busy part of the image to be tinted")
+	}
+
+	after = color.RGBAModel.Convert(debug.At(100, 100)).(color.RGBA)
+
+	if after != grey {
+		t.Errorf("Expected the flat part of the image not to be tinted, got %v", after)
+	}
+}
+
+func TestTraceCropRectangle(t *testing.T) {
+
+	im := trimPhoto(300, 200, trim_white, image.Rect(200, 50, 280, 150))
+
+	opts := NewDefaultCropOptions()
+	opts.Width = 100
+	opts.Height = 100
+
+	hinted := opts
+	hinted.Hints = []Hint{{X: 10, Y: 10, Width: 20, Height: 20}}
+
+	tests := []struct {
+		name  string
+		opts  CropOptions
+		hints image.Rectangle
+	}{
+		{"salience", opts, image.Rectangle{}},
+		{"hints", hinted, image.Rect(10, 10, 30, 30)},
+	}
+
+	for _, test := range tests {
+
+		r, err := CropRectangle(im, test.opts)
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		trace, err := TraceCropRectangle(im, test.opts)
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if trace.Crop != r {
+			t.Errorf("%s: expected the traced crop to be %v, got %v", test.name, r, trace.Crop)
+		}
+
+		if trace.Hints != test.hints {
+			t.Errorf("%s: expected the traced hints to be %v, got %v", test.name, test.hints, trace.Hints)
+		}
+
+		if len(trace.Candidates) == 0 {
+			t.Errorf("%s: expected some candidates", test.name)
+		}
+
+		for _, c := range trace.Candidates {
+
+			if c.Bounds.Dx() != 100 || c.Bounds.Dy() != 100 {
+				t.Errorf("%s: expected 100x100 candidates, got %v", test.name, c.Bounds)
+				break
+			}
+		}
+	}
+}
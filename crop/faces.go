@@ -0,0 +1,147 @@
+package crop
+
+// https://github.com/esimov/pigo
+// https://arxiv.org/pdf/1305.4537.pdf
+
+import (
+	_ "embed"
+	pigo "github.com/esimov/pigo/core"
+	"github.com/nfnt/resize"
+	"image"
+	"math"
+	"sync"
+)
+
+// this is the "facefinder" cascade that ships with pigo (and pico before it)
+
+//go:embed cascade/facefinder
+var facefinder []byte
+
+// detections with a quality score below this are ignored
+
+const face_threshold = 5.0
+
+// how much a window is rewarded for each face it contains; entropy is measured
+// in bits so this is enough for a single face to beat any amount of texture
+
+const face_weight = 100.0
+
+// images are scaled down to this size (on their longest side) before looking
+// for faces to keep things fast
+
+const face_max_dimension = 1024
+
+var classifier *pigo.Pigo
+var classifier_err error
+var classifier_once sync.Once
+
+type Face struct {
+	Bounds image.Rectangle
+	Score  float64
+}
+
+func faceClassifier() (*pigo.Pigo, error) {
+
+	classifier_once.Do(func() {
+		classifier, classifier_err = pigo.NewPigo().Unpack(facefinder)
+	})
+
+	return classifier, classifier_err
+}
+
+func DetectFaces(im image.Image) ([]Face, error) {
+
+	cl, err := faceClassifier()
+
+	if err != nil {
+		return nil, err
+	}
+
+	dims := im.Bounds()
+
+	scale := 1.0
+	longest := math.Max(float64(dims.Dx()), float64(dims.Dy()))
+
+	if longest > face_max_dimension {
+		scale = longest / face_max_dimension
+		im = resize.Thumbnail(face_max_dimension, face_max_dimension, im, resize.Bilinear)
+	}
+
+	grey := GreyImage(im)
+	grey_dims := grey.Bounds()
+
+	rows := grey_dims.Dy()
+	cols := grey_dims.Dx()
+
+	max_size := rows
+
+	if cols < max_size {
+		max_size = cols
+	}
+
+	params := pigo.CascadeParams{
+		MinSize:     20,
+		MaxSize:     max_size,
+		ShiftFactor: 0.1,
+		ScaleFactor: 1.1,
+		ImageParams: pigo.ImageParams{
+			Pixels: grey.Pix[grey.PixOffset(grey_dims.Min.X, grey_dims.Min.Y):],
+			Rows:   rows,
+			Cols:   cols,
+			Dim:    grey.Stride,
+		},
+	}
+
+	detections := cl.RunCascade(params, 0.0)
+	detections = cl.ClusterDetections(detections, 0.2)
+
+	faces := make([]Face, 0)
+
+	for _, d := range detections {
+
+		if d.Q < face_threshold {
+			continue
+		}
+
+		half := float64(d.Scale) / 2.0
+
+		x0 := dims.Min.X + int((float64(d.Col)-half)*scale)
+		y0 := dims.Min.Y + int((float64(d.Row)-half)*scale)
+		x1 := dims.Min.X + int((float64(d.Col)+half)*scale)
+		y1 := dims.Min.Y + int((float64(d.Row)+half)*scale)
+
+		f := Face{
+			Bounds: image.Rect(x0, y0, x1, y1).Intersect(dims),
+			Score:  float64(d.Q),
+		}
+
+		faces = append(faces, f)
+	}
+
+	return faces, nil
+}
+
+// facesBonus returns a scoring function that rewards windows for the share
+// of each face they contain.
+
+func facesBonus(faces []Face) func(image.Rectangle) float64 {
+
+	return func(r image.Rectangle) float64 {
+
+		bonus := 0.0
+
+		for _, f := range faces {
+
+			area := f.Bounds.Dx() * f.Bounds.Dy()
+
+			if area == 0 {
+				continue
+			}
+
+			i := f.Bounds.Intersect(r)
+			bonus += face_weight * float64(i.Dx()*i.Dy()) / float64(area)
+		}
+
+		return bonus
+	}
+}
@@ -0,0 +1,111 @@
+package crop
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFacesBonus(t *testing.T) {
+
+	faces := []Face{
+		{Bounds: image.Rect(10, 10, 30, 30)},
+		{Bounds: image.Rect(100, 100, 120, 140)},
+		{Bounds: image.Rect(50, 50, 50, 60)},
+	}
+
+	tests := []struct {
+		name     string
+		window   image.Rectangle
+		expected float64
+	}{
+		{"no faces", image.Rect(200, 200, 300, 300), 0.0},
+		{"one face", image.Rect(0, 0, 40, 40), face_weight},
+		{"half a face", image.Rect(20, 0, 60, 40), face_weight / 2.0},
+		{"a quarter of a face", image.Rect(0, 0, 20, 20), face_weight / 4.0},
+		{"both faces", image.Rect(0, 0, 200, 200), face_weight * 2.0},
+		{"one and a half", image.Rect(0, 0, 200, 120), face_weight * 1.5},
+	}
+
+	bonus := facesBonus(faces)
+
+	for _, test := range tests {
+
+		got := bonus(test.window)
+
+		if got < test.expected-0.0001 || got > test.expected+0.0001 {
+			t.Errorf("%s: expected %f, got %f", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestFacesRectangle(t *testing.T) {
+
+	// a flat image with a busy patch on the left, which is where the window
+	// would go if it wasn't for the face on the right
+
+	grey := image.NewGray(image.Rect(0, 0, 400, 200))
+
+	for y := 0; y < 200; y++ {
+
+		for x := 0; x < 400; x++ {
+
+			v := uint8(128)
+
+			if x < 100 {
+				v = uint8((x*37 + y*91 + x*y*13) % 256)
+			}
+
+			grey.SetGray(x, y, color.Gray{v})
+		}
+	}
+
+	face := Face{Bounds: image.Rect(320, 80, 360, 120)}
+
+	tests := []struct {
+		name   string
+		faces  []Face
+		target image.Rectangle
+		check  func(image.Rectangle) bool
+	}{
+		{"no faces", nil, image.Rectangle{}, func(r image.Rectangle) bool { return r.Min.X < 100 }},
+		{"face", []Face{face}, image.Rectangle{}, func(r image.Rectangle) bool { return face.Bounds.In(r) }},
+		{"face outside target", []Face{face}, image.Rect(0, 0, 50, 50), func(r image.Rectangle) bool { return image.Rect(0, 0, 50, 50).In(r) }},
+	}
+
+	for _, test := range tests {
+
+		r := FacesRectangle(grey, 150, 150, test.target, test.faces)
+
+		if r.Dx() != 150 || r.Dy() != 150 || !r.In(grey.Bounds()) {
+			t.Errorf("%s: expected a 150x150 window inside the image, got %v", test.name, r)
+			continue
+		}
+
+		if !test.check(r) {
+			t.Errorf("%s: unexpected window %v", test.name, r)
+		}
+	}
+}
+
+func TestDetectFaces(t *testing.T) {
+
+	// there's not a lot to go on without a photo of a face but the cascade
+	// should load and find nothing in a blank image (of any size)
+
+	for _, dims := range []image.Rectangle{image.Rect(0, 0, 64, 48), image.Rect(10, 10, 2010, 1510)} {
+
+		im := image.NewGray(dims)
+
+		faces, err := DetectFaces(im)
+
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", dims, err)
+			continue
+		}
+
+		if len(faces) != 0 {
+			t.Errorf("%v: expected no faces, got %v", dims, faces)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package crop
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// GravityRectangle returns the w x h window of dims anchored to gravity, which
+// is one of "center" or a compass direction ("north", "south-east" and so on)
+// or the equivalent "top", "bottom-left" and so on.
+
+func GravityRectangle(dims image.Rectangle, w int, h int, gravity string) (image.Rectangle, error) {
+
+	g := strings.ToLower(strings.TrimSpace(gravity))
+	g = strings.Replace(g, "_", "-", -1)
+
+	var x_pos string
+	var y_pos string
+
+	switch g {
+	case "center", "centre":
+		x_pos, y_pos = "center", "center"
+	case "north", "top":
+		x_pos, y_pos = "center", "top"
+	case "south", "bottom":
+		x_pos, y_pos = "center", "bottom"
+	case "east", "right":
+		x_pos, y_pos = "right", "center"
+	case "west", "left":
+		x_pos, y_pos = "left", "center"
+	case "northeast", "north-east", "top-right":
+		x_pos, y_pos = "right", "top"
+	case "northwest", "north-west", "top-left":
+		x_pos, y_pos = "left", "top"
+	case "southeast", "south-east", "bottom-right":
+		x_pos, y_pos = "right", "bottom"
+	case "southwest", "south-west", "bottom-left":
+		x_pos, y_pos = "left", "bottom"
+	default:
+		return image.Rectangle{}, errors.New("Invalid or unsupported gravity")
+	}
+
+	x := dims.Min.X
+	y := dims.Min.Y
+
+	switch x_pos {
+	case "center":
+		x = dims.Min.X + (dims.Dx()-w)/2
+	case "right":
+		x = dims.Max.X - w
+	}
+
+	switch y_pos {
+	case "center":
+		y = dims.Min.Y + (dims.Dy()-h)/2
+	case "bottom":
+		y = dims.Max.Y - h
+	}
+
+	return image.Rect(x, y, x+w, y+h), nil
+}
+
+// OffsetRectangle returns the w x h window of dims whose top-left corner is
+// offset (relative to dims) moved, if necessary, so it stays inside dims.
+
+func OffsetRectangle(dims image.Rectangle, w int, h int, offset image.Point) image.Rectangle {
+
+	x := clamp(dims.Min.X+offset.X, dims.Min.X, dims.Max.X-w)
+	y := clamp(dims.Min.Y+offset.Y, dims.Min.Y, dims.Max.Y-h)
+
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// ParseOffset parses an "x,y" string
+
+func ParseOffset(offset string) (image.Point, error) {
+
+	parts := strings.Split(offset, ",")
+
+	if len(parts) != 2 {
+		msg := fmt.Sprintf("Invalid offset '%s'", offset)
+		return image.Point{}, errors.New(msg)
+	}
+
+	x, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+
+	if err != nil {
+		return image.Point{}, err
+	}
+
+	y, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+
+	if err != nil {
+		return image.Point{}, err
+	}
+
+	return image.Pt(x, y), nil
+}
+
+// AspectSize returns the largest width and height, with the same aspect ratio
+// as w x h, that fit inside dims.
+
+func AspectSize(dims image.Rectangle, w int, h int) (int, int) {
+
+	im_w := dims.Dx()
+	im_h := dims.Dy()
+
+	aspect_w := im_w
+	aspect_h := int(float64(im_w) * float64(h) / float64(w))
+
+	if aspect_h > im_h {
+		aspect_w = int(float64(im_h) * float64(w) / float64(h))
+		aspect_h = im_h
+	}
+
+	if aspect_w < 1 {
+		aspect_w = 1
+	}
+
+	if aspect_h < 1 {
+		aspect_h = 1
+	}
+
+	return aspect_w, aspect_h
+}
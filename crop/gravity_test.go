@@ -0,0 +1,217 @@
+package crop
+
+import (
+	"image"
+	"testing"
+)
+
+func TestGravityRectangle(t *testing.T) {
+
+	dims := image.Rect(0, 0, 400, 300)
+
+	tests := []struct {
+		gravity  string
+		dims     image.Rectangle
+		expected image.Rectangle
+		ok       bool
+	}{
+		{"center", dims, image.Rect(150, 100, 250, 200), true},
+		{"centre", dims, image.Rect(150, 100, 250, 200), true},
+		{"north", dims, image.Rect(150, 0, 250, 100), true},
+		{"top", dims, image.Rect(150, 0, 250, 100), true},
+		{"south", dims, image.Rect(150, 200, 250, 300), true},
+		{"east", dims, image.Rect(300, 100, 400, 200), true},
+		{"west", dims, image.Rect(0, 100, 100, 200), true},
+		{"northeast", dims, image.Rect(300, 0, 400, 100), true},
+		{"north-west", dims, image.Rect(0, 0, 100, 100), true},
+		{"south_east", dims, image.Rect(300, 200, 400, 300), true},
+		{" Bottom-Left ", dims, image.Rect(0, 200, 100, 300), true},
+		{"top-right", dims, image.Rect(300, 0, 400, 100), true},
+		{"center", image.Rect(0, 0, 401, 301), image.Rect(150, 100, 250, 200), true},
+		{"south-east", image.Rect(50, 50, 450, 350), image.Rect(350, 250, 450, 350), true},
+		{"center", image.Rect(50, 50, 450, 350), image.Rect(200, 150, 300, 250), true},
+		{"middle", dims, image.Rectangle{}, false},
+		{"", dims, image.Rectangle{}, false},
+	}
+
+	for _, test := range tests {
+
+		r, err := GravityRectangle(test.dims, 100, 100, test.gravity)
+
+		if !test.ok {
+
+			if err == nil {
+				t.Errorf("'%s': expected an error", test.gravity)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("'%s': unexpected error %v", test.gravity, err)
+			continue
+		}
+
+		if r != test.expected {
+			t.Errorf("'%s' in %v: expected %v, got %v", test.gravity, test.dims, test.expected, r)
+		}
+	}
+}
+
+func TestOffsetRectangle(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		dims     image.Rectangle
+		offset   image.Point
+		expected image.Rectangle
+	}{
+		{"origin", image.Rect(0, 0, 400, 300), image.Pt(0, 0), image.Rect(0, 0, 100, 100)},
+		{"inside", image.Rect(0, 0, 400, 300), image.Pt(50, 60), image.Rect(50, 60, 150, 160)},
+		{"past the edge", image.Rect(0, 0, 400, 300), image.Pt(350, 250), image.Rect(300, 200, 400, 300)},
+		{"negative", image.Rect(0, 0, 400, 300), image.Pt(-10, -20), image.Rect(0, 0, 100, 100)},
+		{"offset bounds", image.Rect(50, 50, 450, 350), image.Pt(10, 20), image.Rect(60, 70, 160, 170)},
+		{"offset bounds past the edge", image.Rect(50, 50, 450, 350), image.Pt(1000, 1000), image.Rect(350, 250, 450, 350)},
+	}
+
+	for _, test := range tests {
+
+		r := OffsetRectangle(test.dims, 100, 100, test.offset)
+
+		if r != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, r)
+		}
+	}
+}
+
+func TestParseOffset(t *testing.T) {
+
+	tests := []struct {
+		offset   string
+		expected image.Point
+		ok       bool
+	}{
+		{"10,20", image.Pt(10, 20), true},
+		{" 10 , 20 ", image.Pt(10, 20), true},
+		{"-5,0", image.Pt(-5, 0), true},
+		{"10", image.Point{}, false},
+		{"10,20,30", image.Point{}, false},
+		{"ten,20", image.Point{}, false},
+		{"10,", image.Point{}, false},
+		{"1.5,2", image.Point{}, false},
+		{"", image.Point{}, false},
+	}
+
+	for _, test := range tests {
+
+		pt, err := ParseOffset(test.offset)
+
+		if !test.ok {
+
+			if err == nil {
+				t.Errorf("'%s': expected an error", test.offset)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("'%s': unexpected error %v", test.offset, err)
+			continue
+		}
+
+		if pt != test.expected {
+			t.Errorf("'%s': expected %v, got %v", test.offset, test.expected, pt)
+		}
+	}
+}
+
+func TestAspectSize(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		dims   image.Rectangle
+		w, h   int
+		ew, eh int
+	}{
+		{"square in landscape", image.Rect(0, 0, 400, 300), 100, 100, 300, 300},
+		{"square in portrait", image.Rect(0, 0, 300, 400), 100, 100, 300, 300},
+		{"wide", image.Rect(0, 0, 400, 300), 200, 100, 400, 200},
+		{"tall", image.Rect(0, 0, 400, 300), 100, 200, 150, 300},
+		{"same aspect", image.Rect(0, 0, 400, 300), 4, 3, 400, 300},
+		{"bigger than the image", image.Rect(0, 0, 400, 300), 800, 800, 300, 300},
+		{"very wide", image.Rect(0, 0, 400, 300), 1000, 1, 400, 1},
+		{"offset bounds", image.Rect(50, 50, 450, 350), 100, 100, 300, 300},
+	}
+
+	for _, test := range tests {
+
+		w, h := AspectSize(test.dims, test.w, test.h)
+
+		if w != test.ew || h != test.eh {
+			t.Errorf("%s: expected %dx%d, got %dx%d", test.name, test.ew, test.eh, w, h)
+		}
+	}
+}
+
+func TestCropGravityAndOffset(t *testing.T) {
+
+	im := trimPhoto(400, 300, trim_white, image.Rect(50, 50, 350, 250))
+
+	offset := image.Pt(10, 20)
+
+	tests := []struct {
+		name     string
+		gravity  string
+		offset   *image.Point
+		resize   bool
+		expected image.Rectangle
+		size     image.Point
+	}{
+		{"gravity", "south-west", nil, false, image.Rect(0, 200, 100, 300), image.Pt(100, 100)},
+		{"offset", "", &offset, false, image.Rect(10, 20, 110, 120), image.Pt(100, 100)},
+		{"offset wins", "south-west", &offset, false, image.Rect(10, 20, 110, 120), image.Pt(100, 100)},
+		{"resize", "north-east", nil, true, image.Rect(100, 0, 400, 300), image.Pt(100, 100)},
+	}
+
+	for _, test := range tests {
+
+		opts := NewDefaultCropOptions()
+		opts.Width = 100
+		opts.Height = 100
+		opts.Gravity = test.gravity
+		opts.Offset = test.offset
+		opts.Resize = test.resize
+
+		r, err := CropRectangle(im, opts)
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if r != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, r)
+		}
+
+		cropped, err := Crop(im, opts)
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if cropped.Bounds().Size() != test.size {
+			t.Errorf("%s: expected a %v image, got %v", test.name, test.size, cropped.Bounds().Size())
+		}
+	}
+
+	opts := NewDefaultCropOptions()
+	opts.Gravity = "upwards"
+
+	_, err := CropRectangle(im, opts)
+
+	if err == nil {
+		t.Errorf("Expected an error for an invalid gravity")
+	}
+}
@@ -0,0 +1,311 @@
+package crop
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"image"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Hint is a focal point or region of interest. Points have a zero width and
+// height. Normalized hints are expressed as fractions (0.0 - 1.0) of the
+// image dimensions rather than pixels.
+
+type Hint struct {
+	Label      string
+	X          float64
+	Y          float64
+	Width      float64
+	Height     float64
+	Normalized bool
+}
+
+func (h Hint) Rectangle(dims image.Rectangle) image.Rectangle {
+
+	x := h.X
+	y := h.Y
+	w := h.Width
+	ht := h.Height
+
+	if h.Normalized {
+		x = x * float64(dims.Dx())
+		y = y * float64(dims.Dy())
+		w = w * float64(dims.Dx())
+		ht = ht * float64(dims.Dy())
+	}
+
+	x0 := dims.Min.X + int(math.Floor(x))
+	y0 := dims.Min.Y + int(math.Floor(y))
+	x1 := dims.Min.X + int(math.Ceil(x+w))
+	y1 := dims.Min.Y + int(math.Ceil(y+ht))
+
+	if x1 == x0 {
+		x1 = x0 + 1
+	}
+
+	if y1 == y0 {
+		y1 = y0 + 1
+	}
+
+	return image.Rect(x0, y0, x1, y1).Intersect(dims)
+}
+
+// HintsRectangle returns the smallest rectangle containing all of hints,
+// clipped to dims.
+
+func HintsRectangle(hints []Hint, dims image.Rectangle) image.Rectangle {
+
+	target := image.Rectangle{}
+
+	for _, h := range hints {
+
+		r := h.Rectangle(dims)
+
+		if r.Empty() {
+			continue
+		}
+
+		target = target.Union(r)
+	}
+
+	return target
+}
+
+// HintsFromSidecar reads hints from a JSON file next to path, either
+// "foo.jpg.json" or "foo.json". A missing sidecar is not an error. The
+// JSON is expected to look like:
+//
+//	{ "focus": { "x": 120, "y": 80 } }
+//	{ "regions": [ { "x": 10, "y": 10, "w": 100, "h": 100, "label": "face" } ], "unit": "normalized" }
+
+func HintsFromSidecar(path string) ([]Hint, error) {
+
+	abs_path, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := os.DirFS(filepath.Dir(abs_path))
+	return HintsFromSidecarFS(fsys, filepath.Base(abs_path))
+}
+
+// HintsFromSidecarFS is like HintsFromSidecar for an image at (the fs.FS
+// path) name in fsys, for example a zip file
+
+func HintsFromSidecarFS(fsys fs.FS, name string) ([]Hint, error) {
+
+	ext := path.Ext(name)
+
+	candidates := []string{
+		fmt.Sprintf("%s.json", name),
+		fmt.Sprintf("%s.json", strings.TrimSuffix(name, ext)),
+	}
+
+	for _, sidecar := range candidates {
+
+		_, err := fs.Stat(fsys, sidecar)
+
+		if err != nil {
+			continue
+		}
+
+		body, err := fs.ReadFile(fsys, sidecar)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return HintsFromJSON(body)
+	}
+
+	return nil, nil
+}
+
+func HintsFromJSON(body []byte) ([]Hint, error) {
+
+	var item interface{}
+	err := json.Unmarshal(body, &item)
+
+	if err != nil {
+		return nil, err
+	}
+
+	hints := make([]Hint, 0)
+
+	normalized := gjson.GetBytes(body, "unit").String() == "normalized"
+
+	rsp := gjson.GetBytes(body, "focus")
+
+	if rsp.Exists() {
+
+		h := Hint{
+			Label:      "focus",
+			X:          rsp.Get("x").Float(),
+			Y:          rsp.Get("y").Float(),
+			Normalized: normalized,
+		}
+
+		hints = append(hints, h)
+	}
+
+	rsp = gjson.GetBytes(body, "regions")
+
+	for _, r := range rsp.Array() {
+
+		h := Hint{
+			Label:      r.Get("label").String(),
+			X:          r.Get("x").Float(),
+			Y:          r.Get("y").Float(),
+			Width:      r.Get("w").Float(),
+			Height:     r.Get("h").Float(),
+			Normalized: normalized,
+		}
+
+		hints = append(hints, h)
+	}
+
+	return hints, nil
+}
+
+// HintsManifest maps image paths to hints
+
+type HintsManifest map[string][]Hint
+
+// Lookup returns the hints for path trying, in order, the path as-is, its
+// absolute path and its filename.
+
+func (m HintsManifest) Lookup(path string) []Hint {
+
+	hints, ok := m[path]
+
+	if ok {
+		return hints
+	}
+
+	abs_path, err := filepath.Abs(path)
+
+	if err == nil {
+
+		hints, ok = m[abs_path]
+
+		if ok {
+			return hints
+		}
+	}
+
+	return m[filepath.Base(path)]
+}
+
+func HintsManifestFromCSV(path string) (HintsManifest, error) {
+
+	fh, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	return HintsManifestFromCSVReader(fh)
+}
+
+// HintsManifestFromCSVReader reads a CSV document with a header row. The
+// "path", "x" and "y" columns are required. The "w", "h", "label" and "unit"
+// columns are optional. Rows without a width and height are focal points.
+
+func HintsManifestFromCSVReader(fh io.Reader) (HintsManifest, error) {
+
+	rdr := csv.NewReader(fh)
+	rdr.FieldsPerRecord = -1
+
+	header, err := rdr.Read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	cols := make(map[string]int)
+
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range []string{"path", "x", "y"} {
+
+		_, ok := cols[name]
+
+		if !ok {
+			msg := fmt.Sprintf("Manifest is missing required '%s' column", name)
+			return nil, errors.New(msg)
+		}
+	}
+
+	manifest := make(HintsManifest)
+
+	for {
+
+		row, err := rdr.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		value := func(name string) string {
+
+			i, ok := cols[name]
+
+			if !ok || i >= len(row) {
+				return ""
+			}
+
+			return strings.TrimSpace(row[i])
+		}
+
+		number := func(name string) (float64, error) {
+
+			v := value(name)
+
+			if v == "" {
+				return 0.0, nil
+			}
+
+			return strconv.ParseFloat(v, 64)
+		}
+
+		h := Hint{
+			Label:      value("label"),
+			Normalized: value("unit") == "normalized",
+		}
+
+		for name, ptr := range map[string]*float64{"x": &h.X, "y": &h.Y, "w": &h.Width, "h": &h.Height} {
+
+			v, err := number(name)
+
+			if err != nil {
+				return nil, err
+			}
+
+			*ptr = v
+		}
+
+		path := value("path")
+		manifest[path] = append(manifest[path], h)
+	}
+
+	return manifest, nil
+}
@@ -0,0 +1,235 @@
+package crop
+
+import (
+	"image"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHintRectangle(t *testing.T) {
+
+	dims := image.Rect(0, 0, 400, 300)
+
+	tests := []struct {
+		name     string
+		hint     Hint
+		dims     image.Rectangle
+		expected image.Rectangle
+	}{
+		{"pixels", Hint{X: 10, Y: 20, Width: 100, Height: 50}, dims, image.Rect(10, 20, 110, 70)},
+		{"point", Hint{X: 10, Y: 20}, dims, image.Rect(10, 20, 11, 21)},
+		{"fractional", Hint{X: 10.5, Y: 20.5, Width: 10, Height: 10}, dims, image.Rect(10, 20, 21, 31)},
+		{"normalized", Hint{X: 0.25, Y: 0.5, Width: 0.5, Height: 0.25, Normalized: true}, dims, image.Rect(100, 150, 300, 225)},
+		{"normalized point", Hint{X: 0.5, Y: 0.5, Normalized: true}, dims, image.Rect(200, 150, 201, 151)},
+		{"clipped", Hint{X: 350, Y: 250, Width: 100, Height: 100}, dims, image.Rect(350, 250, 400, 300)},
+		{"outside", Hint{X: 500, Y: 500, Width: 10, Height: 10}, dims, image.Rectangle{}},
+		{"offset bounds", Hint{X: 10, Y: 20, Width: 100, Height: 50}, image.Rect(50, 50, 450, 350), image.Rect(60, 70, 160, 120)},
+	}
+
+	for _, test := range tests {
+
+		r := test.hint.Rectangle(test.dims)
+
+		if r != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, r)
+		}
+	}
+}
+
+func TestHintsRectangle(t *testing.T) {
+
+	dims := image.Rect(0, 0, 400, 300)
+
+	tests := []struct {
+		name     string
+		hints    []Hint
+		expected image.Rectangle
+	}{
+		{"none", []Hint{}, image.Rectangle{}},
+		{"one", []Hint{{X: 10, Y: 20, Width: 100, Height: 50}}, image.Rect(10, 20, 110, 70)},
+		{"two", []Hint{{X: 10, Y: 20, Width: 100, Height: 50}, {X: 0.75, Y: 0.75, Normalized: true}}, image.Rect(10, 20, 301, 226)},
+		{"outside ignored", []Hint{{X: 10, Y: 20, Width: 100, Height: 50}, {X: 500, Y: 500}}, image.Rect(10, 20, 110, 70)},
+	}
+
+	for _, test := range tests {
+
+		r := HintsRectangle(test.hints, dims)
+
+		if r != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, r)
+		}
+	}
+}
+
+func TestHintsFromJSON(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		body     string
+		expected []Hint
+		ok       bool
+	}{
+		{"focus", `{"focus": {"x": 120, "y": 80}}`, []Hint{{Label: "focus", X: 120, Y: 80}}, true},
+		{
+			"regions",
+			`{"regions": [{"x": 0.1, "y": 0.2, "w": 0.3, "h": 0.4, "label": "face"}, {"x": 0.5, "y": 0.5, "w": 0.1, "h": 0.1}], "unit": "normalized"}`,
+			[]Hint{{Label: "face", X: 0.1, Y: 0.2, Width: 0.3, Height: 0.4, Normalized: true}, {X: 0.5, Y: 0.5, Width: 0.1, Height: 0.1, Normalized: true}},
+			true,
+		},
+		{
+			"focus and regions",
+			`{"focus": {"x": 10, "y": 20}, "regions": [{"x": 1, "y": 2, "w": 3, "h": 4}]}`,
+			[]Hint{{Label: "focus", X: 10, Y: 20}, {X: 1, Y: 2, Width: 3, Height: 4}},
+			true,
+		},
+		{"nothing", `{"title": "A photo"}`, []Hint{}, true},
+		{"invalid", `{"focus": `, nil, false},
+	}
+
+	for _, test := range tests {
+
+		hints, err := HintsFromJSON([]byte(test.body))
+
+		if !test.ok {
+
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if !sameHints(hints, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, hints)
+		}
+	}
+}
+
+func TestHintsManifestFromCSVReader(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		csv      string
+		expected HintsManifest
+		ok       bool
+	}{
+		{
+			"points and regions",
+			"path,x,y,w,h,label,unit\na.jpg,10,20,,,,\na.jpg,0.1,0.2,0.3,0.4,face,normalized\nb.jpg,5,6,7,8,,\n",
+			HintsManifest{
+				"a.jpg": {{X: 10, Y: 20}, {Label: "face", X: 0.1, Y: 0.2, Width: 0.3, Height: 0.4, Normalized: true}},
+				"b.jpg": {{X: 5, Y: 6, Width: 7, Height: 8}},
+			},
+			true,
+		},
+		{
+			"column order and case",
+			" Y , X ,Path\n20,10,a.jpg\n",
+			HintsManifest{"a.jpg": {{X: 10, Y: 20}}},
+			true,
+		},
+		{
+			"short rows",
+			"path,x,y,w,h\na.jpg,10,20\n",
+			HintsManifest{"a.jpg": {{X: 10, Y: 20}}},
+			true,
+		},
+		{"missing column", "path,x\na.jpg,10\n", nil, false},
+		{"not a number", "path,x,y\na.jpg,ten,20\n", nil, false},
+		{"empty", "", nil, false},
+	}
+
+	for _, test := range tests {
+
+		manifest, err := HintsManifestFromCSVReader(strings.NewReader(test.csv))
+
+		if !test.ok {
+
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if len(manifest) != len(test.expected) {
+			t.Errorf("%s: expected %d images, got %d", test.name, len(test.expected), len(manifest))
+			continue
+		}
+
+		for path, expected := range test.expected {
+
+			if !sameHints(manifest[path], expected) {
+				t.Errorf("%s: expected %v for %s, got %v", test.name, expected, path, manifest[path])
+			}
+		}
+	}
+}
+
+func TestHintsManifestLookup(t *testing.T) {
+
+	abs_path, err := filepath.Abs("c.jpg")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := HintsManifest{
+		"photos/a.jpg": {{Label: "as-is"}},
+		"b.jpg":        {{Label: "filename"}},
+		abs_path:       {{Label: "absolute"}},
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"photos/a.jpg", "as-is"},
+		{"elsewhere/b.jpg", "filename"},
+		{"c.jpg", "absolute"},
+		{"d.jpg", ""},
+	}
+
+	for _, test := range tests {
+
+		label := ""
+		hints := manifest.Lookup(test.path)
+
+		if len(hints) > 0 {
+			label = hints[0].Label
+		}
+
+		if label != test.expected {
+			t.Errorf("%s: expected '%s', got '%s'", test.path, test.expected, label)
+		}
+	}
+}
+
+// sameHints returns true if a and b are the same hints in the same order
+
+func sameHints(a []Hint, b []Hint) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+
+		if !closeHint(a[i], b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
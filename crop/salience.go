@@ -0,0 +1,226 @@
+package crop
+
+// this is adapted from github.com/iand/salience which only exposes
+// a Crop method and we need to be able to constrain the windows that
+// are considered...
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+func GreyImage(im image.Image) *image.Gray {
+
+	if grey, ok := im.(*image.Gray); ok {
+		return grey
+	}
+
+	dims := im.Bounds()
+
+	grey := image.NewGray(dims)
+	draw.Draw(grey, dims, im, dims.Min, draw.Src)
+
+	return grey
+}
+
+// SalientRectangle returns the w x h window of grey with the highest entropy.
+
+func SalientRectangle(grey *image.Gray, w int, h int) image.Rectangle {
+	return searchRectangle(grey, w, h, image.Rectangle{}, nil, nil)
+}
+
+// HintedRectangle returns the w x h window of grey with the highest entropy
+// that also contains target. If target is larger than the window in either
+// dimension the window is centered on target in that dimension.
+
+func HintedRectangle(grey *image.Gray, w int, h int, target image.Rectangle) image.Rectangle {
+	return searchRectangle(grey, w, h, target, nil, nil)
+}
+
+// FacesRectangle returns the w x h window of grey with the highest combined
+// entropy and share of faces, optionally constrained to contain target.
+
+func FacesRectangle(grey *image.Gray, w int, h int, target image.Rectangle, faces []Face) image.Rectangle {
+	return searchRectangle(grey, w, h, target, faces, nil)
+}
+
+func searchRectangle(grey *image.Gray, w int, h int, target image.Rectangle, faces []Face, trace *CropTrace) image.Rectangle {
+
+	lo, hi := origins(grey.Bounds(), w, h, target)
+
+	if len(faces) == 0 {
+		return bestRectangle(grey, w, h, lo, hi, nil, nil, trace)
+	}
+
+	centers := make([]image.Point, len(faces))
+
+	for i, f := range faces {
+		centers[i] = image.Pt((f.Bounds.Min.X+f.Bounds.Max.X)/2, (f.Bounds.Min.Y+f.Bounds.Max.Y)/2)
+	}
+
+	return bestRectangle(grey, w, h, lo, hi, facesBonus(faces), centers, trace)
+}
+
+// origins returns the smallest and largest (inclusive) top-left corners for a
+// w x h window inside dims that contains target, if target is not empty.
+
+func origins(dims image.Rectangle, w int, h int, target image.Rectangle) (image.Point, image.Point) {
+
+	if target.Empty() {
+		return dims.Min, image.Pt(dims.Max.X-w, dims.Max.Y-h)
+	}
+
+	min_x, max_x := hintedRange(dims.Min.X, dims.Max.X, target.Min.X, target.Max.X, w)
+	min_y, max_y := hintedRange(dims.Min.Y, dims.Max.Y, target.Min.Y, target.Max.Y, h)
+
+	return image.Pt(min_x, min_y), image.Pt(max_x, max_y)
+}
+
+// hintedRange returns the range of window origins, along one axis, for a window
+// of size sz that contains [t_min, t_max) and stays inside [d_min, d_max).
+
+func hintedRange(d_min int, d_max int, t_min int, t_max int, sz int) (int, int) {
+
+	if t_max-t_min >= sz {
+		origin := clamp(t_min+((t_max-t_min)-sz)/2, d_min, d_max-sz)
+		return origin, origin
+	}
+
+	lo := clamp(t_max-sz, d_min, d_max-sz)
+	hi := clamp(t_min, d_min, d_max-sz)
+
+	return lo, hi
+}
+
+// bestRectangle scores every w x h window whose origin is between lo and hi
+// (on a grid, plus any windows centered on centers) and returns the best one.
+// Windows are scored by their entropy plus bonus, if it is not nil. If trace
+// is not nil every window that was considered is recorded there.
+
+func bestRectangle(grey *image.Gray, w int, h int, lo image.Point, hi image.Point, bonus func(image.Rectangle) float64, centers []image.Point, trace *CropTrace) image.Rectangle {
+
+	dims := grey.Bounds()
+
+	step := dims.Dx() / 8
+
+	if dims.Dy()/8 < step {
+		step = dims.Dy() / 8
+	}
+
+	if step < 1 {
+		step = 1
+	}
+
+	xs := steps(lo.X, hi.X, step)
+	ys := steps(lo.Y, hi.Y, step)
+
+	for _, pt := range centers {
+		xs = append(xs, clamp(pt.X-w/2, lo.X, hi.X))
+		ys = append(ys, clamp(pt.Y-h/2, lo.Y, hi.Y))
+	}
+
+	best := image.Rect(lo.X, lo.Y, lo.X+w, lo.Y+h)
+	best_score := -1.0
+
+	for _, x := range xs {
+
+		for _, y := range ys {
+
+			r := image.Rect(x, y, x+w, y+h)
+			score := Entropy(grey, r)
+
+			if bonus != nil {
+				score += bonus(r)
+			}
+
+			if trace != nil {
+				trace.Candidates = append(trace.Candidates, Candidate{Bounds: r, Score: score})
+			}
+
+			if score > best_score {
+				best = r
+				best_score = score
+			}
+		}
+	}
+
+	return best
+}
+
+// steps returns the values from lo to hi (inclusive) in increments of step,
+// making sure hi is always considered.
+
+func steps(lo int, hi int, step int) []int {
+
+	if hi < lo {
+		hi = lo
+	}
+
+	values := make([]int, 0)
+
+	for i := lo; i < hi; i += step {
+		values = append(values, i)
+	}
+
+	values = append(values, hi)
+	return values
+}
+
+// Entropy returns the entropy of the horizontal pixel differences in r
+// http://www.astro.cornell.edu/research/projects/compression/entropy.html
+
+func Entropy(grey *image.Gray, r image.Rectangle) float64 {
+
+	r = r.Intersect(grey.Bounds())
+
+	freq := make([]float64, 511)
+	n := 0.0
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+
+		offset := grey.PixOffset(r.Min.X, y)
+		row := grey.Pix[offset : offset+r.Dx()]
+
+		for x := 0; x < len(row)-1; x++ {
+			diff := int(row[x]) - int(row[x+1])
+			freq[diff+255] += 1
+			n += 1
+		}
+	}
+
+	if n == 0.0 {
+		return 0.0
+	}
+
+	e := 0.0
+
+	for _, v := range freq {
+
+		if v == 0.0 {
+			continue
+		}
+
+		p := v / n
+		e -= p * math.Log2(p)
+	}
+
+	return e
+}
+
+func clamp(v int, lo int, hi int) int {
+
+	if hi < lo {
+		hi = lo
+	}
+
+	if v < lo {
+		return lo
+	}
+
+	if v > hi {
+		return hi
+	}
+
+	return v
+}
@@ -0,0 +1,116 @@
+package crop
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestHintedRange(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		d_min  int
+		d_max  int
+		t_min  int
+		t_max  int
+		sz     int
+		lo, hi int
+	}{
+		{"middle", 0, 400, 150, 200, 100, 100, 150},
+		{"near the start", 0, 400, 10, 50, 100, 0, 10},
+		{"near the end", 0, 400, 350, 390, 100, 290, 300},
+		{"exact fit", 0, 400, 150, 250, 100, 150, 150},
+		{"bigger than the window", 0, 400, 100, 300, 100, 150, 150},
+		{"bigger than the window at the end", 0, 400, 200, 400, 300, 100, 100},
+		{"offset", 100, 500, 250, 300, 100, 200, 250},
+	}
+
+	for _, test := range tests {
+
+		lo, hi := hintedRange(test.d_min, test.d_max, test.t_min, test.t_max, test.sz)
+
+		if lo != test.lo || hi != test.hi {
+			t.Errorf("%s: expected %d - %d, got %d - %d", test.name, test.lo, test.hi, lo, hi)
+		}
+	}
+}
+
+func TestOrigins(t *testing.T) {
+
+	dims := image.Rect(0, 0, 400, 300)
+
+	tests := []struct {
+		name   string
+		target image.Rectangle
+		lo, hi image.Point
+	}{
+		{"no target", image.Rectangle{}, image.Pt(0, 0), image.Pt(300, 200)},
+		{"target", image.Rect(150, 120, 200, 160), image.Pt(100, 60), image.Pt(150, 120)},
+		{"corner", image.Rect(380, 280, 390, 290), image.Pt(290, 190), image.Pt(300, 200)},
+	}
+
+	for _, test := range tests {
+
+		lo, hi := origins(dims, 100, 100, test.target)
+
+		if lo != test.lo || hi != test.hi {
+			t.Errorf("%s: expected %v - %v, got %v - %v", test.name, test.lo, test.hi, lo, hi)
+		}
+	}
+}
+
+func TestHintedRectangle(t *testing.T) {
+
+	// a flat image with a busy patch in the top left corner
+
+	busy := image.Rect(0, 0, 100, 100)
+	grey := image.NewGray(image.Rect(0, 0, 400, 300))
+
+	for i := range grey.Pix {
+		grey.Pix[i] = 128
+	}
+
+	for y := busy.Min.Y; y < busy.Max.Y; y++ {
+
+		for x := busy.Min.X; x < busy.Max.X; x++ {
+			grey.SetGray(x, y, color.Gray{uint8((x*37 + y*91) % 256)})
+		}
+	}
+
+	tests := []struct {
+		name   string
+		target image.Rectangle
+	}{
+		{"no target", image.Rectangle{}},
+		{"target", image.Rect(300, 200, 320, 220)},
+		{"big target", image.Rect(50, 50, 350, 250)},
+	}
+
+	for _, test := range tests {
+
+		r := HintedRectangle(grey, 100, 100, test.target)
+
+		if r.Dx() != 100 || r.Dy() != 100 || !r.In(grey.Bounds()) {
+			t.Errorf("%s: expected a 100x100 window inside the image, got %v", test.name, r)
+			continue
+		}
+
+		if test.target.Empty() {
+
+			if !r.Overlaps(busy) {
+				t.Errorf("%s: expected a window over the busy corner, got %v", test.name, r)
+			}
+
+			continue
+		}
+
+		if test.target.Dx() <= 100 && !test.target.In(r) {
+			t.Errorf("%s: expected the window to contain %v, got %v", test.name, test.target, r)
+		}
+
+		if test.target.Dx() > 100 && r != image.Rect(150, 100, 250, 200) {
+			t.Errorf("%s: expected the window to be centered on %v, got %v", test.name, test.target, r)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package crop
+
+// https://en.wikipedia.org/wiki/Seam_carving
+// http://www.faculty.idc.ac.il/arik/SCWeb/imret/imret.pdf
+
+import (
+	"github.com/nfnt/resize"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// how much a fully white pixel in a protect (or remove) mask adds to (or
+// subtracts from) the energy of a pixel; large enough to dwarf any gradient
+
+const mask_weight = 1000000.0
+
+type carver struct {
+	width  int
+	height int
+	pix    []uint8
+	bias   []float64
+}
+
+// SeamCarve narrows and/or shortens im to w x h. If im is larger than w x h in
+// both dimensions it is first scaled down, preserving its aspect ratio, until
+// one dimension matches and seams are then removed from the other one. The
+// optional protect and remove masks are greyscale images where white pixels
+// should be kept, or removed, in preference to everything else.
+
+func SeamCarve(im image.Image, w int, h int, protect image.Image, remove image.Image) (image.Image, error) {
+
+	dims := im.Bounds()
+
+	im_w := dims.Dx()
+	im_h := dims.Dy()
+
+	if w > im_w {
+		w = im_w
+	}
+
+	if h > im_h {
+		h = im_h
+	}
+
+	scale := math.Max(float64(w)/float64(im_w), float64(h)/float64(im_h))
+
+	if scale < 1.0 {
+
+		scale_w := uint(math.Max(math.Ceil(float64(im_w)*scale), float64(w)))
+		scale_h := uint(math.Max(math.Ceil(float64(im_h)*scale), float64(h)))
+
+		im = resize.Resize(scale_w, scale_h, im, resize.Lanczos3)
+	}
+
+	protect = fitMask(protect, im.Bounds())
+	remove = fitMask(remove, im.Bounds())
+
+	c := newCarver(im, protect, remove)
+
+	for c.width > w {
+		c.removeSeam(c.findSeam())
+	}
+
+	if c.height > h {
+
+		c.transpose()
+
+		for c.width > h {
+			c.removeSeam(c.findSeam())
+		}
+
+		c.transpose()
+	}
+
+	return c.image(), nil
+}
+
+// fitMask scales m, if necessary, so that it has the same dimensions as dims
+
+func fitMask(m image.Image, dims image.Rectangle) image.Image {
+
+	if m == nil {
+		return nil
+	}
+
+	if m.Bounds().Size() == dims.Size() {
+		return m
+	}
+
+	return resize.Resize(uint(dims.Dx()), uint(dims.Dy()), m, resize.Bilinear)
+}
+
+func newCarver(im image.Image, protect image.Image, remove image.Image) *carver {
+
+	dims := im.Bounds()
+
+	w := dims.Dx()
+	h := dims.Dy()
+
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), im, dims.Min, draw.Src)
+
+	bias := make([]float64, w*h)
+
+	masks := map[float64]image.Image{
+		1.0:  protect,
+		-1.0: remove,
+	}
+
+	for sign, m := range masks {
+
+		if m == nil {
+			continue
+		}
+
+		grey := image.NewGray(rgba.Bounds())
+		draw.Draw(grey, grey.Bounds(), m, m.Bounds().Min, draw.Src)
+
+		for i, v := range grey.Pix[:w*h] {
+			bias[i] += sign * mask_weight * (float64(v) / 255.0)
+		}
+	}
+
+	c := carver{
+		width:  w,
+		height: h,
+		pix:    rgba.Pix,
+		bias:   bias,
+	}
+
+	return &c
+}
+
+func (c *carver) luminance() []float64 {
+
+	lum := make([]float64, c.width*c.height)
+
+	for i := range lum {
+		p := c.pix[i*4 : i*4+3]
+		lum[i] = 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+	}
+
+	return lum
+}
+
+// energy returns the gradient magnitude of each pixel plus any mask bias
+
+func (c *carver) energy() []float64 {
+
+	lum := c.luminance()
+	e := make([]float64, len(lum))
+
+	w := c.width
+	h := c.height
+
+	for y := 0; y < h; y++ {
+
+		for x := 0; x < w; x++ {
+
+			l := lum[y*w+clamp(x-1, 0, w-1)]
+			r := lum[y*w+clamp(x+1, 0, w-1)]
+			u := lum[clamp(y-1, 0, h-1)*w+x]
+			d := lum[clamp(y+1, 0, h-1)*w+x]
+
+			e[y*w+x] = math.Abs(r-l) + math.Abs(d-u) + c.bias[y*w+x]
+		}
+	}
+
+	return e
+}
+
+// findSeam returns the x coordinate, for each row, of the vertical seam with
+// the lowest cumulative energy
+
+func (c *carver) findSeam() []int {
+
+	w := c.width
+	h := c.height
+
+	m := c.energy()
+
+	for y := 1; y < h; y++ {
+
+		for x := 0; x < w; x++ {
+
+			best := m[(y-1)*w+x]
+
+			if x > 0 && m[(y-1)*w+x-1] < best {
+				best = m[(y-1)*w+x-1]
+			}
+
+			if x < w-1 && m[(y-1)*w+x+1] < best {
+				best = m[(y-1)*w+x+1]
+			}
+
+			m[y*w+x] += best
+		}
+	}
+
+	seam := make([]int, h)
+
+	x := 0
+
+	for i := 1; i < w; i++ {
+
+		if m[(h-1)*w+i] < m[(h-1)*w+x] {
+			x = i
+		}
+	}
+
+	seam[h-1] = x
+
+	for y := h - 2; y >= 0; y-- {
+
+		prev := seam[y+1]
+		x := prev
+
+		if prev > 0 && m[y*w+prev-1] < m[y*w+x] {
+			x = prev - 1
+		}
+
+		if prev < w-1 && m[y*w+prev+1] < m[y*w+x] {
+			x = prev + 1
+		}
+
+		seam[y] = x
+	}
+
+	return seam
+}
+
+func (c *carver) removeSeam(seam []int) {
+
+	w := c.width
+	h := c.height
+
+	pix := make([]uint8, 0, (w-1)*h*4)
+	bias := make([]float64, 0, (w-1)*h)
+
+	for y := 0; y < h; y++ {
+
+		x := seam[y]
+		row := y * w
+
+		pix = append(pix, c.pix[row*4:(row+x)*4]...)
+		pix = append(pix, c.pix[(row+x+1)*4:(row+w)*4]...)
+
+		bias = append(bias, c.bias[row:row+x]...)
+		bias = append(bias, c.bias[row+x+1:row+w]...)
+	}
+
+	c.pix = pix
+	c.bias = bias
+	c.width = w - 1
+}
+
+func (c *carver) transpose() {
+
+	w := c.width
+	h := c.height
+
+	pix := make([]uint8, len(c.pix))
+	bias := make([]float64, len(c.bias))
+
+	for y := 0; y < h; y++ {
+
+		for x := 0; x < w; x++ {
+
+			src := y*w + x
+			dest := x*h + y
+
+			copy(pix[dest*4:dest*4+4], c.pix[src*4:src*4+4])
+			bias[dest] = c.bias[src]
+		}
+	}
+
+	c.pix = pix
+	c.bias = bias
+	c.width = h
+	c.height = w
+}
+
+func (c *carver) image() image.Image {
+
+	rgba := image.NewRGBA(image.Rect(0, 0, c.width, c.height))
+	copy(rgba.Pix, c.pix)
+
+	return rgba
+}
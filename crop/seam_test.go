@@ -0,0 +1,133 @@
+package crop
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+var seam_red = image.Rect(20, 0, 26, 40)
+
+// seamImage returns a 60 x 40 image with a red stripe in seam_red. If busy is
+// true the background is noisy and the stripe flat, otherwise it's the other
+// way around, so that seams go through the flat part unless told otherwise.
+
+func seamImage(busy bool) *image.NRGBA {
+
+	im := image.NewNRGBA(image.Rect(0, 0, 60, 40))
+
+	for y := 0; y < 40; y++ {
+
+		for x := 0; x < 60; x++ {
+
+			noise := uint8((x*37 + y*91 + x*y*13) % 41)
+
+			pt := image.Pt(x, y)
+
+			switch {
+			case pt.In(seam_red) && busy:
+				im.Set(x, y, color.NRGBA{230, 20, 20, 255})
+			case pt.In(seam_red):
+				im.Set(x, y, color.NRGBA{215 + noise, noise, noise, 255})
+			case busy:
+				im.Set(x, y, color.NRGBA{60 + noise*3, 60 + noise*3, 60 + noise*3, 255})
+			default:
+				im.Set(x, y, color.NRGBA{128, 128, 128, 255})
+			}
+		}
+	}
+
+	return im
+}
+
+// seamMask returns a w x h mask which is white in r
+
+func seamMask(w int, h int, r image.Rectangle) *image.Gray {
+
+	m := image.NewGray(image.Rect(0, 0, w, h))
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+
+		for x := r.Min.X; x < r.Max.X; x++ {
+			m.SetGray(x, y, color.Gray{255})
+		}
+	}
+
+	return m
+}
+
+// redPixels returns the number of pixels in im that are part of the stripe
+
+func redPixels(im image.Image) int {
+
+	count := 0
+	dims := im.Bounds()
+
+	for y := dims.Min.Y; y < dims.Max.Y; y++ {
+
+		for x := dims.Min.X; x < dims.Max.X; x++ {
+
+			r, g, b, _ := im.At(x, y).RGBA()
+
+			if r>>8 > 200 && g>>8 < 60 && b>>8 < 60 {
+				count += 1
+			}
+		}
+	}
+
+	return count
+}
+
+func TestSeamCarve(t *testing.T) {
+
+	stripe := seam_red.Dx() * seam_red.Dy()
+
+	tests := []struct {
+		name    string
+		busy    bool
+		w       int
+		h       int
+		protect image.Image
+		remove  image.Image
+		size    image.Point
+		red     int
+	}{
+		{"narrower", false, 50, 40, nil, nil, image.Pt(50, 40), stripe},
+		{"shorter", false, 60, 30, nil, nil, image.Pt(60, 30), seam_red.Dx() * 30},
+		{"scaled and narrower", false, 30, 30, nil, nil, image.Pt(30, 30), -1},
+		{"bigger than the image", false, 100, 100, nil, nil, image.Pt(60, 40), stripe},
+		{"flat stripe", true, 50, 40, nil, nil, image.Pt(50, 40), 0},
+		{"protected stripe", true, 50, 40, seamMask(60, 40, seam_red), nil, image.Pt(50, 40), stripe},
+		{"removed stripe", false, 54, 40, nil, seamMask(60, 40, seam_red), image.Pt(54, 40), 0},
+		{"half size mask", true, 50, 40, seamMask(30, 20, image.Rect(10, 0, 13, 20)), nil, image.Pt(50, 40), stripe},
+	}
+
+	for _, test := range tests {
+
+		im, err := SeamCarve(seamImage(test.busy), test.w, test.h, test.protect, test.remove)
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if im.Bounds().Size() != test.size {
+			t.Errorf("%s: expected %v, got %v", test.name, test.size, im.Bounds().Size())
+			continue
+		}
+
+		if test.red == -1 {
+			continue
+		}
+
+		red := redPixels(im)
+
+		if test.red == 0 && red > stripe/10 {
+			t.Errorf("%s: expected (almost) none of the stripe to be left, got %d pixels", test.name, red)
+		}
+
+		if test.red > 0 && red != test.red {
+			t.Errorf("%s: expected %d pixels of the stripe, got %d", test.name, test.red, red)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package crop
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// the share of pixels in a row or column that are allowed to differ from the
+// border colour, to account for dust and scanner noise
+
+const trim_outliers = 0.02
+
+// TrimRectangle returns the bounds of im with any near-uniform borders, like
+// the white or black bed around a scanned photo, removed. The border colour
+// is taken from the corners of im and tolerance is the largest difference
+// (0 - 255) in any one channel for a pixel to still be considered part of the
+// border. Each side is trimmed one line at a time, stopping at the first line
+// that isn't border, so a clear sky or a plain wall that only reaches some of
+// the edges of the picture is left alone.
+
+func TrimRectangle(im image.Image, tolerance float64) image.Rectangle {
+
+	dims := im.Bounds()
+
+	if dims.Empty() {
+		return dims
+	}
+
+	ref := cornerColour(im)
+
+	isBorder := func(line image.Rectangle) bool {
+		return matchesColour(im, line, ref, tolerance)
+	}
+
+	r := dims
+
+	for r.Min.Y < r.Max.Y-1 {
+
+		line := image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+1)
+
+		if !isBorder(line) {
+			break
+		}
+
+		r.Min.Y += 1
+	}
+
+	for r.Max.Y > r.Min.Y+1 {
+
+		line := image.Rect(r.Min.X, r.Max.Y-1, r.Max.X, r.Max.Y)
+
+		if !isBorder(line) {
+			break
+		}
+
+		r.Max.Y -= 1
+	}
+
+	for r.Min.X < r.Max.X-1 {
+
+		line := image.Rect(r.Min.X, r.Min.Y, r.Min.X+1, r.Max.Y)
+
+		if !isBorder(line) {
+			break
+		}
+
+		r.Min.X += 1
+	}
+
+	for r.Max.X > r.Min.X+1 {
+
+		line := image.Rect(r.Max.X-1, r.Min.Y, r.Max.X, r.Max.Y)
+
+		if !isBorder(line) {
+			break
+		}
+
+		r.Max.X -= 1
+	}
+
+	return r
+}
+
+// cornerColour returns the colour of the corners of im: the median, for each
+// channel, of its four corner pixels so that a speck of dust, or a picture
+// that runs right up to one of the corners, doesn't throw things off
+
+func cornerColour(im image.Image) [3]float64 {
+
+	dims := im.Bounds()
+
+	corners := [][3]float64{
+		rgb(im.At(dims.Min.X, dims.Min.Y)),
+		rgb(im.At(dims.Max.X-1, dims.Min.Y)),
+		rgb(im.At(dims.Min.X, dims.Max.Y-1)),
+		rgb(im.At(dims.Max.X-1, dims.Max.Y-1)),
+	}
+
+	var ref [3]float64
+
+	for i := 0; i < 3; i++ {
+
+		values := make([]float64, len(corners))
+
+		for j, c := range corners {
+			values[j] = c[i]
+		}
+
+		sort.Float64s(values)
+		ref[i] = (values[1] + values[2]) / 2
+	}
+
+	return ref
+}
+
+// matchesColour returns true if (nearly) all the pixels in r are within
+// tolerance of ref
+
+func matchesColour(im image.Image, r image.Rectangle, ref [3]float64, tolerance float64) bool {
+
+	total := r.Dx() * r.Dy()
+	allowed := int(float64(total) * trim_outliers)
+
+	outliers := 0
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+
+		for x := r.Min.X; x < r.Max.X; x++ {
+
+			c := rgb(im.At(x, y))
+
+			for i := 0; i < 3; i++ {
+
+				d := c[i] - ref[i]
+
+				if d > tolerance || d < -tolerance {
+					outliers += 1
+					break
+				}
+			}
+
+			if outliers > allowed {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func rgb(c color.Color) [3]float64 {
+
+	r, g, b, _ := c.RGBA()
+	return [3]float64{float64(r >> 8), float64(g >> 8), float64(b >> 8)}
+}
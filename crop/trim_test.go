@@ -0,0 +1,98 @@
+package crop
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+var trim_white = color.NRGBA{255, 255, 255, 255}
+var trim_black = color.NRGBA{0, 0, 0, 255}
+var trim_sky = color.NRGBA{120, 170, 230, 255}
+var trim_grass = color.NRGBA{60, 140, 40, 255}
+
+// trimPhoto returns a w x h image filled with bg with a "photo" (a pattern of
+// colours, none of them close to white or black) in r
+
+func trimPhoto(w int, h int, bg color.Color, r image.Rectangle) *image.NRGBA {
+
+	im := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(im, im.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+
+		for x := r.Min.X; x < r.Max.X; x++ {
+			im.Set(x, y, color.NRGBA{uint8(60 + (x*7)%120), uint8(60 + (y*5)%120), uint8(60 + ((x+y)*3)%120), 255})
+		}
+	}
+
+	return im
+}
+
+func TestTrimRectangle(t *testing.T) {
+
+	inner := image.Rect(20, 15, 120, 95)
+
+	// a landscape with a clear sky along the top and sides, with or without
+	// a white border around it
+
+	landscape := func(border int) *image.NRGBA {
+
+		im := image.NewNRGBA(image.Rect(0, 0, 140+(border*2), 110+(border*2)))
+		draw.Draw(im, im.Bounds(), image.NewUniform(trim_white), image.Point{}, draw.Src)
+
+		r := im.Bounds().Inset(border)
+		horizon := r.Min.Y + (r.Dy() / 2)
+
+		draw.Draw(im, image.Rect(r.Min.X, r.Min.Y, r.Max.X, horizon), image.NewUniform(trim_sky), image.Point{}, draw.Src)
+		draw.Draw(im, image.Rect(r.Min.X, horizon, r.Max.X, r.Max.Y), image.NewUniform(trim_grass), image.Point{}, draw.Src)
+
+		return im
+	}
+
+	// a white border with dust and scanner noise in it
+
+	noisy := trimPhoto(140, 110, trim_white, inner)
+
+	for y := 0; y < inner.Min.Y; y++ {
+		noisy.Set(30+y, y, trim_black)
+	}
+
+	for x := 0; x < 140; x++ {
+		noisy.Set(x, 100, color.NRGBA{240, 245, 235, 255})
+	}
+
+	// a picture that runs right up to one corner
+
+	corner := trimPhoto(140, 110, trim_white, inner)
+	draw.Draw(corner, image.Rect(120, 0, 140, 15), image.NewUniform(trim_grass), image.Point{}, draw.Src)
+
+	tests := []struct {
+		name      string
+		im        image.Image
+		tolerance float64
+		expected  image.Rectangle
+	}{
+		{"white border", trimPhoto(140, 110, trim_white, inner), 24, inner},
+		{"black border", trimPhoto(140, 110, trim_black, inner), 24, inner},
+		{"touching one side", trimPhoto(140, 110, trim_white, image.Rect(0, 15, 120, 95)), 24, image.Rect(0, 15, 120, 95)},
+		{"most of the corners", trimPhoto(140, 110, trim_white, image.Rect(0, 0, 120, 110)), 24, image.Rect(0, 0, 140, 110)},
+		{"no border", trimPhoto(140, 110, trim_white, image.Rect(0, 0, 140, 110)), 24, image.Rect(0, 0, 140, 110)},
+		{"dust and noise", noisy, 24, inner},
+		{"noise above tolerance", noisy, 10, image.Rect(20, 15, 120, 101)},
+		{"photo in a corner", corner, 24, image.Rect(20, 0, 140, 95)},
+		{"sky", landscape(0), 24, image.Rect(0, 0, 140, 110)},
+		{"sky with a border", landscape(10), 24, image.Rect(10, 10, 150, 120)},
+		{"offset bounds", trimPhoto(140, 110, trim_white, inner).SubImage(image.Rect(10, 10, 140, 110)), 24, inner},
+	}
+
+	for _, test := range tests {
+
+		r := TrimRectangle(test.im, test.tolerance)
+
+		if r != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, r)
+		}
+	}
+}
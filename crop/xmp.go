@@ -0,0 +1,278 @@
+package crop
+
+// https://www.exiftool.org/TagNames/MWG.html#Regions
+// http://www.metadataworkinggroup.org/pdf/mwg_guidance.pdf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const mwg_regions_ns = "http://www.metadataworkinggroup.com/schemas/regions/"
+const st_area_ns = "http://ns.adobe.com/xmp/sType/Area#"
+const rdf_ns = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+
+// HintsFromXMP reads MWG regions from the XMP packet embedded in path or,
+// failing that, from a "foo.xmp" sidecar file. No XMP is not an error.
+
+func HintsFromXMP(path string) ([]Hint, error) {
+
+	abs_path, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := os.DirFS(filepath.Dir(abs_path))
+	return HintsFromXMPFS(fsys, filepath.Base(abs_path))
+}
+
+// HintsFromXMPFS is like HintsFromXMP for an image at (the fs.FS path) name in
+// fsys, for example a zip file
+
+func HintsFromXMPFS(fsys fs.FS, name string) ([]Hint, error) {
+
+	body, err := fs.ReadFile(fsys, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	packet := ExtractXMP(body)
+
+	if packet == nil {
+
+		ext := path.Ext(name)
+		sidecar := strings.TrimSuffix(name, ext) + ".xmp"
+
+		_, err := fs.Stat(fsys, sidecar)
+
+		if err != nil {
+			return nil, nil
+		}
+
+		packet, err = fs.ReadFile(fsys, sidecar)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return HintsFromXMPPacket(packet)
+}
+
+// ExtractXMP returns the first <x:xmpmeta> element found in body or nil.
+
+func ExtractXMP(body []byte) []byte {
+
+	start := bytes.Index(body, []byte("<x:xmpmeta"))
+
+	if start == -1 {
+		return nil
+	}
+
+	end := bytes.Index(body[start:], []byte("</x:xmpmeta>"))
+
+	if end == -1 {
+		return nil
+	}
+
+	end = start + end + len("</x:xmpmeta>")
+	return body[start:end]
+}
+
+// HintsFromXMPPacket returns a hint for each mwg-rs:Area in packet. Areas may
+// be written as attributes or as child elements. MWG areas are centered on
+// x,y so they are converted to top-left origins here.
+
+// An area and its name belong to the same rdf:li (or rdf:Description) and can
+// come in any order (exiftool writes the name after the area, for instance) so
+// nothing is emitted until that element is closed.
+
+func HintsFromXMPPacket(packet []byte) ([]Hint, error) {
+
+	hints := make([]Hint, 0)
+
+	dec := xml.NewDecoder(bytes.NewReader(packet))
+
+	// the document itself is a region too, for areas that aren't in a list
+
+	regions := []*xmpRegion{new(xmpRegion)}
+
+	var in_area bool
+	var field string
+
+	for {
+
+		t, err := dec.Token()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch el := t.(type) {
+
+		case xml.StartElement:
+
+			if isXMPRegionElement(el.Name) {
+				regions = append(regions, new(xmpRegion))
+			}
+
+			region := regions[len(regions)-1]
+
+			if el.Name.Space == mwg_regions_ns && el.Name.Local == "Area" {
+
+				region.area = make(map[string]string)
+				in_area = true
+
+				for _, a := range el.Attr {
+
+					if a.Name.Space == st_area_ns {
+						region.area[a.Name.Local] = a.Value
+					}
+				}
+
+				continue
+			}
+
+			if in_area && el.Name.Space == st_area_ns {
+				field = el.Name.Local
+				continue
+			}
+
+			if el.Name.Space == mwg_regions_ns && el.Name.Local == "Name" {
+				field = "name"
+				continue
+			}
+
+			for _, a := range el.Attr {
+
+				if a.Name.Space == mwg_regions_ns && a.Name.Local == "Name" {
+					region.label = a.Value
+				}
+			}
+
+		case xml.CharData:
+
+			if field == "" {
+				continue
+			}
+
+			region := regions[len(regions)-1]
+
+			if field == "name" {
+				region.label = string(el)
+			} else if in_area {
+				region.area[field] = string(el)
+			}
+
+		case xml.EndElement:
+
+			field = ""
+
+			if el.Name.Space == mwg_regions_ns && el.Name.Local == "Area" {
+				in_area = false
+				continue
+			}
+
+			if isXMPRegionElement(el.Name) && len(regions) > 1 {
+
+				h, ok := regions[len(regions)-1].hint()
+
+				if ok {
+					hints = append(hints, h)
+				}
+
+				regions = regions[:len(regions)-1]
+			}
+		}
+	}
+
+	h, ok := regions[0].hint()
+
+	if ok {
+		hints = append(hints, h)
+	}
+
+	return hints, nil
+}
+
+// xmpRegion is the area and name collected for a single region so far
+
+type xmpRegion struct {
+	area  map[string]string
+	label string
+}
+
+func (r *xmpRegion) hint() (Hint, bool) {
+
+	if r.area == nil {
+		return Hint{}, false
+	}
+
+	return hintFromArea(r.area, r.label)
+}
+
+func isXMPRegionElement(name xml.Name) bool {
+
+	if name.Space != rdf_ns {
+		return false
+	}
+
+	return name.Local == "li" || name.Local == "Description"
+}
+
+func hintFromArea(area map[string]string, label string) (Hint, bool) {
+
+	values := make(map[string]float64)
+
+	for _, k := range []string{"x", "y", "w", "h"} {
+
+		v, ok := area[k]
+
+		if !ok {
+
+			if k == "w" || k == "h" {
+				continue
+			}
+
+			return Hint{}, false
+		}
+
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+
+		if err != nil {
+			return Hint{}, false
+		}
+
+		values[k] = f
+	}
+
+	unit, ok := area["unit"]
+
+	if !ok {
+		unit = "normalized"
+	}
+
+	h := Hint{
+		Label:      label,
+		X:          values["x"] - (values["w"] / 2.0),
+		Y:          values["y"] - (values["h"] / 2.0),
+		Width:      values["w"],
+		Height:     values["h"],
+		Normalized: unit == "normalized",
+	}
+
+	return h, true
+}
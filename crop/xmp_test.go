@@ -0,0 +1,196 @@
+package crop
+
+import (
+	"fmt"
+	"testing"
+)
+
+// xmpPacket wraps regions, the contents of an rdf:Bag, in an XMP packet with
+// the namespaces that MWG regions use
+
+func xmpPacket(regions string) string {
+
+	packet := `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:mwg-rs="http://www.metadataworkinggroup.com/schemas/regions/"
+    xmlns:stArea="http://ns.adobe.com/xmp/sType/Area#"
+    xmlns:stDim="http://ns.adobe.com/xap/1.0/sType/Dimensions#">
+   <mwg-rs:Regions rdf:parseType="Resource">
+    <mwg-rs:AppliedToDimensions stDim:w="400" stDim:h="300" stDim:unit="pixel"/>
+    <mwg-rs:RegionList>
+     <rdf:Bag>%s</rdf:Bag>
+    </mwg-rs:RegionList>
+   </mwg-rs:Regions>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+	return fmt.Sprintf(packet, regions)
+}
+
+func TestHintsFromXMPPacket(t *testing.T) {
+
+	// the way exiftool writes regions: child elements, in alphabetical
+	// order, so the name comes after the area
+
+	elements := xmpPacket(`
+      <rdf:li rdf:parseType="Resource">
+       <mwg-rs:Area rdf:parseType="Resource">
+        <stArea:h>0.2</stArea:h>
+        <stArea:unit>normalized</stArea:unit>
+        <stArea:w>0.1</stArea:w>
+        <stArea:x>0.25</stArea:x>
+        <stArea:y>0.5</stArea:y>
+       </mwg-rs:Area>
+       <mwg-rs:Name>Alice</mwg-rs:Name>
+       <mwg-rs:Type>Face</mwg-rs:Type>
+      </rdf:li>
+      <rdf:li rdf:parseType="Resource">
+       <mwg-rs:Area rdf:parseType="Resource">
+        <stArea:h>0.4</stArea:h>
+        <stArea:unit>normalized</stArea:unit>
+        <stArea:w>0.2</stArea:w>
+        <stArea:x>0.75</stArea:x>
+        <stArea:y>0.5</stArea:y>
+       </mwg-rs:Area>
+       <mwg-rs:Name>Bob</mwg-rs:Name>
+       <mwg-rs:Type>Face</mwg-rs:Type>
+      </rdf:li>`)
+
+	// the same with the name first, and a region without one
+
+	named_first := xmpPacket(`
+      <rdf:li rdf:parseType="Resource">
+       <mwg-rs:Name>Alice</mwg-rs:Name>
+       <mwg-rs:Area rdf:parseType="Resource">
+        <stArea:x>0.25</stArea:x>
+        <stArea:y>0.5</stArea:y>
+        <stArea:w>0.1</stArea:w>
+        <stArea:h>0.2</stArea:h>
+       </mwg-rs:Area>
+      </rdf:li>
+      <rdf:li rdf:parseType="Resource">
+       <mwg-rs:Area rdf:parseType="Resource">
+        <stArea:x>0.75</stArea:x>
+        <stArea:y>0.5</stArea:y>
+        <stArea:w>0.2</stArea:w>
+        <stArea:h>0.4</stArea:h>
+       </mwg-rs:Area>
+      </rdf:li>`)
+
+	// attributes, on an rdf:Description inside each rdf:li
+
+	attributes := xmpPacket(`
+      <rdf:li>
+       <rdf:Description mwg-rs:Name="Alice" mwg-rs:Type="Face">
+        <mwg-rs:Area stArea:x="0.25" stArea:y="0.5" stArea:w="0.1" stArea:h="0.2" stArea:unit="normalized"/>
+       </rdf:Description>
+      </rdf:li>
+      <rdf:li>
+       <rdf:Description mwg-rs:Type="Focus">
+        <mwg-rs:Area stArea:x="200" stArea:y="150" stArea:unit="pixel"/>
+       </rdf:Description>
+      </rdf:li>`)
+
+	// an area that can't be read is left out without losing the others
+
+	broken := xmpPacket(`
+      <rdf:li rdf:parseType="Resource">
+       <mwg-rs:Area stArea:x="left" stArea:y="0.5"/>
+       <mwg-rs:Name>Broken</mwg-rs:Name>
+      </rdf:li>
+      <rdf:li rdf:parseType="Resource">
+       <mwg-rs:Area stArea:x="0.75" stArea:y="0.5" stArea:w="0.2" stArea:h="0.4"/>
+       <mwg-rs:Name>Bob</mwg-rs:Name>
+      </rdf:li>`)
+
+	alice := Hint{Label: "Alice", X: 0.2, Y: 0.4, Width: 0.1, Height: 0.2, Normalized: true}
+	bob := Hint{Label: "Bob", X: 0.65, Y: 0.3, Width: 0.2, Height: 0.4, Normalized: true}
+
+	unnamed := bob
+	unnamed.Label = ""
+
+	tests := []struct {
+		name     string
+		packet   string
+		expected []Hint
+	}{
+		{"elements", elements, []Hint{alice, bob}},
+		{"name first", named_first, []Hint{alice, unnamed}},
+		{"attributes", attributes, []Hint{alice, {X: 200, Y: 150}}},
+		{"broken area", broken, []Hint{bob}},
+		{"no regions", xmpPacket(""), []Hint{}},
+	}
+
+	for _, test := range tests {
+
+		hints, err := HintsFromXMPPacket([]byte(test.packet))
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if len(hints) != len(test.expected) {
+			t.Errorf("%s: expected %d hints, got %d (%v)", test.name, len(test.expected), len(hints), hints)
+			continue
+		}
+
+		for i, h := range hints {
+
+			if !closeHint(h, test.expected[i]) {
+				t.Errorf("%s: expected hint %d to be %v, got %v", test.name, i, test.expected[i], h)
+			}
+		}
+	}
+
+	_, err := HintsFromXMPPacket([]byte(`<x:xmpmeta><rdf:RDF>`))
+
+	if err == nil {
+		t.Errorf("Expected an error for a truncated packet")
+	}
+}
+
+func TestExtractXMP(t *testing.T) {
+
+	packet := xmpPacket("")
+
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"embedded", "\xff\xd8\xff\xe1junk<?xpacket begin?>" + packet + "<?xpacket end?>\xff\xd9", packet},
+		{"sidecar", packet, packet},
+		{"none", "\xff\xd8\xff\xd9", ""},
+		{"unterminated", "<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">", ""},
+	}
+
+	for _, test := range tests {
+
+		got := string(ExtractXMP([]byte(test.body)))
+
+		if got != test.expected {
+			t.Errorf("%s: expected '%s', got '%s'", test.name, test.expected, got)
+		}
+	}
+}
+
+// closeHint returns true if a and b are the same hint, give or take rounding
+
+func closeHint(a Hint, b Hint) bool {
+
+	if a.Label != b.Label || a.Normalized != b.Normalized {
+		return false
+	}
+
+	for _, d := range []float64{a.X - b.X, a.Y - b.Y, a.Width - b.Width, a.Height - b.Height} {
+
+		if d < -0.0001 || d > 0.0001 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,317 @@
+package deskew
+
+// https://en.wikipedia.org/wiki/Document_layout_analysis
+// http://www.leptonica.org/skew-measurement.html
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nfnt/resize"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type DeskewOptions struct {
+	MaxAngle float64
+	Fill     color.Color
+}
+
+func NewDefaultDeskewOptions() DeskewOptions {
+
+	opts := DeskewOptions{
+		MaxAngle: 10.0,
+		Fill:     color.White,
+	}
+
+	return opts
+}
+
+// Deskew returns a copy of im rotated to correct its skew along with the
+// detected skew angle, in degrees. Corners exposed by the rotation are
+// painted with opts.Fill.
+
+func Deskew(im image.Image, opts DeskewOptions) (image.Image, float64, error) {
+
+	angle, err := DetectSkew(im, opts)
+
+	if err != nil {
+		return nil, 0.0, err
+	}
+
+	if angle == 0.0 {
+		return im, angle, nil
+	}
+
+	return Rotate(im, angle, opts.Fill), angle, nil
+}
+
+// DetectSkew estimates how far, in degrees, the content of im has been rotated
+// clockwise using projection profiles: the edges in the image are projected on
+// to the vertical axis for each candidate angle and the angle that produces
+// the sharpest profile (the one where rows of text, or the sides of a photo,
+// line up) wins. Angles are searched coarse-to-fine up to +/- opts.MaxAngle.
+
+func DetectSkew(im image.Image, opts DeskewOptions) (float64, error) {
+
+	if opts.MaxAngle <= 0.0 {
+		return 0.0, errors.New("Invalid max angle")
+	}
+
+	points := edgePoints(im)
+
+	if len(points) == 0 {
+		return 0.0, nil
+	}
+
+	best := 0.0
+	lo := -opts.MaxAngle
+	hi := opts.MaxAngle
+
+	for _, step := range []float64{0.5, 0.1, 0.02} {
+
+		best_score := -1.0
+
+		for a := lo; a <= hi+(step/2.0); a += step {
+
+			score := profileScore(points, a)
+
+			if score > best_score {
+				best = a
+				best_score = score
+			}
+		}
+
+		lo = best - step
+		hi = best + step
+	}
+
+	// round to the precision of the final step
+	best = math.Round(best*50.0) / 50.0
+
+	if best == 0.0 {
+		return 0.0, nil
+	}
+
+	return best, nil
+}
+
+// Rotate returns a copy of im, with the same dimensions, rotated counter-
+// clockwise by angle degrees around its centre (undoing a clockwise skew of
+// angle degrees) using bilinear sampling.
+
+func Rotate(im image.Image, angle float64, fill color.Color) image.Image {
+
+	dims := im.Bounds()
+
+	src := image.NewNRGBA(dims)
+	draw.Draw(src, dims, im, dims.Min, draw.Src)
+
+	dest := image.NewNRGBA(dims)
+	draw.Draw(dest, dims, image.NewUniform(fill), image.Point{}, draw.Src)
+
+	rad := angle * math.Pi / 180.0
+	sin := math.Sin(rad)
+	cos := math.Cos(rad)
+
+	cx := float64(dims.Min.X) + float64(dims.Dx())/2.0
+	cy := float64(dims.Min.Y) + float64(dims.Dy())/2.0
+
+	fill_c := color.NRGBAModel.Convert(fill).(color.NRGBA)
+
+	for y := dims.Min.Y; y < dims.Max.Y; y++ {
+
+		for x := dims.Min.X; x < dims.Max.X; x++ {
+
+			px := float64(x) + 0.5 - cx
+			py := float64(y) + 0.5 - cy
+
+			sx := px*cos - py*sin + cx - 0.5
+			sy := px*sin + py*cos + cy - 0.5
+
+			c, ok := bilinear(src, sx, sy, fill_c)
+
+			if ok {
+				dest.SetNRGBA(x, y, c)
+			}
+		}
+	}
+
+	return dest
+}
+
+// ParseFill parses "white", "black", "transparent" or a "#rrggbb" string
+
+func ParseFill(fill string) (color.Color, error) {
+
+	fill = strings.ToLower(strings.TrimSpace(fill))
+
+	switch fill {
+	case "white":
+		return color.White, nil
+	case "black":
+		return color.Black, nil
+	case "transparent":
+		return color.Transparent, nil
+	}
+
+	hex := strings.TrimPrefix(fill, "#")
+
+	if len(hex) != 6 {
+		msg := fmt.Sprintf("Invalid fill colour '%s'", fill)
+		return nil, errors.New(msg)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := color.NRGBA{
+		R: uint8(v >> 16),
+		G: uint8(v >> 8),
+		B: uint8(v),
+		A: 255,
+	}
+
+	return c, nil
+}
+
+// edgePoints returns the coordinates, relative to the centre of a thumbnail
+// of im, of every pixel with a strong vertical gradient
+
+func edgePoints(im image.Image) [][2]float64 {
+
+	thumb := resize.Thumbnail(800, 800, im, resize.Bilinear)
+	dims := thumb.Bounds()
+
+	grey := image.NewGray(dims)
+	draw.Draw(grey, dims, thumb, dims.Min, draw.Src)
+
+	w := dims.Dx()
+	h := dims.Dy()
+
+	cx := float64(w) / 2.0
+	cy := float64(h) / 2.0
+
+	gradients := make([]float64, w*h)
+	sum := 0.0
+
+	for y := 1; y < h-1; y++ {
+
+		for x := 0; x < w; x++ {
+
+			up := float64(grey.Pix[(y-1)*grey.Stride+x])
+			down := float64(grey.Pix[(y+1)*grey.Stride+x])
+
+			g := math.Abs(down - up)
+
+			gradients[y*w+x] = g
+			sum += g
+		}
+	}
+
+	mean := sum / float64(w*h)
+	threshold := math.Max(mean*4.0, 32.0)
+
+	points := make([][2]float64, 0)
+
+	for y := 1; y < h-1; y++ {
+
+		for x := 0; x < w; x++ {
+
+			if gradients[y*w+x] >= threshold {
+				points = append(points, [2]float64{float64(x) - cx, float64(y) - cy})
+			}
+		}
+	}
+
+	return points
+}
+
+// profileScore projects points on to the vertical axis after undoing a skew of
+// angle degrees and returns the sum of the squared differences between
+// neighbouring bins; the sharper the profile the higher the score
+
+func profileScore(points [][2]float64, angle float64) float64 {
+
+	rad := angle * math.Pi / 180.0
+	sin := math.Sin(rad)
+	cos := math.Cos(rad)
+
+	bins := make(map[int]float64)
+
+	lo := math.MaxInt32
+	hi := math.MinInt32
+
+	for _, pt := range points {
+
+		y := int(math.Floor(-pt[0]*sin + pt[1]*cos))
+		bins[y] += 1
+
+		if y < lo {
+			lo = y
+		}
+
+		if y > hi {
+			hi = y
+		}
+	}
+
+	score := 0.0
+
+	for y := lo; y < hi; y++ {
+		d := bins[y+1] - bins[y]
+		score += d * d
+	}
+
+	return score
+}
+
+func bilinear(im *image.NRGBA, x float64, y float64, fill color.NRGBA) (color.NRGBA, bool) {
+
+	dims := im.Bounds()
+
+	x0 := int(math.Floor(x))
+	y0 := int(math.Floor(y))
+
+	if x0 < dims.Min.X-1 || y0 < dims.Min.Y-1 || x0 >= dims.Max.X || y0 >= dims.Max.Y {
+		return fill, false
+	}
+
+	fx := x - float64(x0)
+	fy := y - float64(y0)
+
+	at := func(px int, py int) color.NRGBA {
+
+		if px < dims.Min.X || py < dims.Min.Y || px >= dims.Max.X || py >= dims.Max.Y {
+			return fill
+		}
+
+		return im.NRGBAAt(px, py)
+	}
+
+	c00 := at(x0, y0)
+	c10 := at(x0+1, y0)
+	c01 := at(x0, y0+1)
+	c11 := at(x0+1, y0+1)
+
+	mix := func(a uint8, b uint8, c uint8, d uint8) uint8 {
+		top := float64(a)*(1-fx) + float64(b)*fx
+		bottom := float64(c)*(1-fx) + float64(d)*fx
+		return uint8(math.Round(top*(1-fy) + bottom*fy))
+	}
+
+	c := color.NRGBA{
+		R: mix(c00.R, c10.R, c01.R, c11.R),
+		G: mix(c00.G, c10.G, c01.G, c11.G),
+		B: mix(c00.B, c10.B, c01.B, c11.B),
+		A: mix(c00.A, c10.A, c01.A, c11.A),
+	}
+
+	return c, true
+}
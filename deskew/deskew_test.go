@@ -0,0 +1,141 @@
+package deskew
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+// testDocument returns a page of "text": rows of black bars on white
+
+func testDocument() image.Image {
+
+	im := image.NewNRGBA(image.Rect(0, 0, 400, 300))
+	draw.Draw(im, im.Bounds(), image.White, image.Point{}, draw.Src)
+
+	for y := 40; y < 260; y += 16 {
+		draw.Draw(im, image.Rect(50, y, 350, y+5), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	}
+
+	return im
+}
+
+func TestDetectSkew(t *testing.T) {
+
+	doc := testDocument()
+
+	blank := image.NewNRGBA(image.Rect(0, 0, 200, 200))
+	draw.Draw(blank, blank.Bounds(), image.White, image.Point{}, draw.Src)
+
+	tests := []struct {
+		name     string
+		im       image.Image
+		skew     float64
+		expected float64
+	}{
+		{"straight", doc, 0.0, 0.0},
+		{"clockwise", doc, 1.0, 1.0},
+		{"counter-clockwise", doc, -2.5, -2.5},
+		{"more clockwise", doc, 4.0, 4.0},
+		{"near the limit", doc, -9.0, -9.0},
+		{"blank", blank, 0.0, 0.0},
+	}
+
+	opts := NewDefaultDeskewOptions()
+
+	for _, test := range tests {
+
+		// rotating counter-clockwise by -skew skews the image clockwise by
+		// skew
+
+		im := test.im
+
+		if test.skew != 0.0 {
+			im = Rotate(im, -test.skew, color.White)
+		}
+
+		angle, err := DetectSkew(im, opts)
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if math.Abs(angle-test.expected) > 0.2 {
+			t.Errorf("%s: expected %0.2f, got %0.2f", test.name, test.expected, angle)
+		}
+	}
+}
+
+func TestDetectSkewOptions(t *testing.T) {
+
+	doc := Rotate(testDocument(), -6.0, color.White)
+
+	opts := NewDefaultDeskewOptions()
+	opts.MaxAngle = 0.0
+
+	_, err := DetectSkew(doc, opts)
+
+	if err == nil {
+		t.Error("Expected an error for a maximum angle of 0")
+	}
+
+	// skews larger than MaxAngle aren't found
+
+	opts.MaxAngle = 3.0
+
+	angle, err := DetectSkew(doc, opts)
+
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if math.Abs(angle) > 3.0 {
+		t.Errorf("Expected an angle of at most 3 degrees, got %0.2f", angle)
+	}
+}
+
+func TestParseFill(t *testing.T) {
+
+	tests := []struct {
+		fill     string
+		expected color.Color
+	}{
+		{"white", color.White},
+		{" Black ", color.Black},
+		{"transparent", color.Transparent},
+		{"#ff8000", color.NRGBA{255, 128, 0, 255}},
+		{"FF8000", color.NRGBA{255, 128, 0, 255}},
+		{"#fff", nil},
+		{"beige", nil},
+		{"#gggggg", nil},
+	}
+
+	for _, test := range tests {
+
+		c, err := ParseFill(test.fill)
+
+		if test.expected == nil {
+
+			if err == nil {
+				t.Errorf("'%s': expected an error", test.fill)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("'%s': unexpected error %v", test.fill, err)
+			continue
+		}
+
+		r1, g1, b1, a1 := c.RGBA()
+		r2, g2, b2, a2 := test.expected.RGBA()
+
+		if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+			t.Errorf("'%s': expected %v, got %v", test.fill, test.expected, c)
+		}
+	}
+}
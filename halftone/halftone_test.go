@@ -0,0 +1,72 @@
+package halftone
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestHalftone(t *testing.T) {
+
+	// a left to right gradient from black to white
+
+	src := image.NewNRGBA(image.Rect(0, 0, 120, 80))
+
+	for y := 0; y < 80; y++ {
+
+		for x := 0; x < 120; x++ {
+			v := uint8(x * 255 / 119)
+			src.Set(x, y, color.NRGBA{v, v, v, 255})
+		}
+	}
+
+	tests := []struct {
+		mode         string
+		scale_factor float64
+		ok           bool
+	}{
+		{"atkinson", 2.0, true},
+		{"atkinson", 1.0, true},
+		{"threshold", 2.0, true},
+		{"threshold", 4.0, true},
+		{"floyd-steinberg", 2.0, false},
+		{"", 2.0, false},
+	}
+
+	for _, test := range tests {
+
+		opts := NewDefaultHalftoneOptions()
+		opts.Mode = test.mode
+		opts.ScaleFactor = test.scale_factor
+
+		im, err := Halftone(src, opts)
+
+		if !test.ok {
+
+			if err == nil {
+				t.Errorf("%s: expected an error", test.mode)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s x %0.1f: unexpected error %v", test.mode, test.scale_factor, err)
+			continue
+		}
+
+		if im.Bounds() != src.Bounds() {
+			t.Errorf("%s x %0.1f: expected %v, got %v", test.mode, test.scale_factor, src.Bounds(), im.Bounds())
+			continue
+		}
+
+		// the dark end stays dark and the light end stays light
+
+		dark, _, _, _ := im.At(2, 40).RGBA()
+		light, _, _, _ := im.At(117, 40).RGBA()
+
+		if dark > 0x4000 || light < 0xc000 {
+			t.Errorf("%s x %0.1f: expected dark and light ends, got %04x and %04x", test.mode, test.scale_factor, dark, light)
+		}
+	}
+}
@@ -1,35 +1,14 @@
 package picturebook
 
 import (
-	"fmt"
-	"regexp"
+	"github.com/straup/go-image-tools/util"
 	"strings"
 )
 
-type RegexpFlag []*regexp.Regexp
+// RegexpFlag has moved to the util package, along with the rest of the code
+// for finding and filtering images; see util.WalkOptions
 
-func (i *RegexpFlag) String() string {
-
-	patterns := make([]string, 0)
-
-	for _, re := range *i {
-		patterns = append(patterns, fmt.Sprintf("%v", re))
-	}
-
-	return strings.Join(patterns, "\n")
-}
-
-func (i *RegexpFlag) Set(value string) error {
-
-	re, err := regexp.Compile(value)
-
-	if err != nil {
-		return err
-	}
-
-	*i = append(*i, re)
-	return nil
-}
+type RegexpFlag = util.RegexpFlag
 
 type PreProcessFlag []string
 
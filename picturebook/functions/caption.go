@@ -5,9 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"github.com/tidwall/gjson"
-	"io/ioutil"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"path"
 	"strings"
 	"time"
 )
@@ -40,54 +39,47 @@ func PictureBookCaptionFuncFromString(caption string) (PictureBookCaptionFunc, e
 	return capt, nil
 }
 
-func DefaultCaptionFunc(path string) (string, error) {
-	return FilenameCaptionFunc(path)
+// paths are fs.FS paths so they always use "/" and the path package, rather
+// than path/filepath, is used to pick them apart
+
+func DefaultCaptionFunc(fsys fs.FS, img_path string) (string, error) {
+	return FilenameCaptionFunc(fsys, img_path)
 }
 
-func FilenameCaptionFunc(path string) (string, error) {
+func FilenameCaptionFunc(fsys fs.FS, img_path string) (string, error) {
 
-	fname := filepath.Base(path)
+	fname := path.Base(img_path)
 	return fname, nil
 }
 
-func FilenameAndParentCaptionFunc(path string) (string, error) {
+func FilenameAndParentCaptionFunc(fsys fs.FS, img_path string) (string, error) {
 
-	root := filepath.Dir(path)
-	parent := filepath.Base(root)
-	fname := filepath.Base(path)
+	root := path.Dir(img_path)
+	parent := path.Base(root)
+	fname := path.Base(img_path)
 
-	return filepath.Join(parent, fname), nil
+	return path.Join(parent, fname), nil
 }
 
-func NoneCaptionFunc(path string) (string, error) {
+func NoneCaptionFunc(fsys fs.FS, img_path string) (string, error) {
 	return "", nil
 }
 
-func FlickrArchiveCaptionFunc(path string) (string, error) {
+func FlickrArchiveCaptionFunc(fsys fs.FS, img_path string) (string, error) {
 
-	ext := filepath.Ext(path)
+	ext := path.Ext(img_path)
 
 	img_ext := fmt.Sprintf("_o%s", ext)
 	info_ext := "_i.json"
 
-	info := strings.Replace(path, img_ext, info_ext, -1)
-
-	_, err := os.Stat(info)
-
-	if err != nil {
-		return "", err
-	}
+	info := strings.Replace(img_path, img_ext, info_ext, -1)
 
-	fh, err := os.Open(info)
+	body, err := fs.ReadFile(fsys, info)
 
 	if err != nil {
 		return "", err
 	}
 
-	defer fh.Close()
-
-	body, err := ioutil.ReadAll(fh)
-
 	var item interface{}
 	err = json.Unmarshal(body, &item)
 
@@ -139,27 +131,17 @@ func FlickrArchiveCaptionFunc(path string) (string, error) {
 	return caption, nil
 }
 
-func CooperHewittShoeboxCaptionFunc(path string) (string, error) {
+func CooperHewittShoeboxCaptionFunc(fsys fs.FS, img_path string) (string, error) {
 
-	root := filepath.Dir(path)
-	info := filepath.Join(root, "index.json")
+	root := path.Dir(img_path)
+	info := path.Join(root, "index.json")
 
-	_, err := os.Stat(info)
+	body, err := fs.ReadFile(fsys, info)
 
 	if err != nil {
 		return "", err
 	}
 
-	fh, err := os.Open(info)
-
-	if err != nil {
-		return "", err
-	}
-
-	defer fh.Close()
-
-	body, err := ioutil.ReadAll(fh)
-
 	var item interface{}
 	err = json.Unmarshal(body, &item)
 
@@ -0,0 +1,74 @@
+package functions
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestPictureBookCaptionFuncFromString(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"photos/2016/a.jpg":            {Data: []byte("")},
+		"flickr/123_abc_o.jpg":         {Data: []byte("")},
+		"flickr/123_abc_i.json":        {Data: []byte(`{"photo": {"id": "123", "title": {"_content": "Hello"}, "dates": {"taken": "2016-02-03 10:11:12"}}}`)},
+		"flickr/456_def_o.jpg":         {Data: []byte("")},
+		"flickr/456_def_i.json":        {Data: []byte(`{"photo": {"id": "456"}}`)},
+		"shoebox/789/789.jpg":          {Data: []byte("")},
+		"shoebox/789/index.json":       {Data: []byte(`{"refers_to_a": "person"}`)},
+		"shoebox/missing/missing.jpg":  {Data: []byte("")},
+		"flickr/missing/999_xyz_o.jpg": {Data: []byte("")},
+	}
+
+	tests := []struct {
+		caption  string
+		path     string
+		expected string
+		ok       bool
+	}{
+		{"default", "photos/2016/a.jpg", "a.jpg", true},
+		{"filename", "photos/2016/a.jpg", "a.jpg", true},
+		{"parent", "photos/2016/a.jpg", "2016/a.jpg", true},
+		{"none", "photos/2016/a.jpg", "", true},
+		{"flickr", "flickr/123_abc_o.jpg", "<b>Hello</b><br />Feb 03, 2016 / 123", true},
+		{"flickr", "flickr/456_def_o.jpg", "", false},
+		{"flickr", "flickr/missing/999_xyz_o.jpg", "", false},
+		{"cooperhewitt", "shoebox/789/789.jpg", "", false},
+		{"cooperhewitt", "shoebox/missing/missing.jpg", "", false},
+	}
+
+	for _, test := range tests {
+
+		capt, err := PictureBookCaptionFuncFromString(test.caption)
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.caption, err)
+			continue
+		}
+
+		caption, err := capt(fsys, test.path)
+
+		if !test.ok {
+
+			if err == nil {
+				t.Errorf("%s %s: expected an error, got '%s'", test.caption, test.path, caption)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s %s: unexpected error %v", test.caption, test.path, err)
+			continue
+		}
+
+		if caption != test.expected {
+			t.Errorf("%s %s: expected '%s', got '%s'", test.caption, test.path, test.expected, caption)
+		}
+	}
+
+	_, err := PictureBookCaptionFuncFromString("instagram")
+
+	if err == nil {
+		t.Error("Expected an error for an unknown caption type")
+	}
+}
@@ -1,7 +1,15 @@
 package functions
 
+import (
+	"io/fs"
+)
+
 type PictureBookFilterFunc func(string) (bool, error)
 
 type PictureBookPreProcessFunc func(string) (string, error)
 
-type PictureBookCaptionFunc func(string) (string, error)
+// caption functions are passed the filesystem an image came from (which may be
+// a zip or tar file) and the path of the image in it, so that they can read
+// any sidecar files next to the image
+
+type PictureBookCaptionFunc func(fs.FS, string) (string, error)
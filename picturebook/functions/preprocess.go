@@ -1,87 +1,260 @@
 package functions
 
 import (
-	"github.com/microcosm-cc/exifutil"
-	"github.com/rwcarlsen/goexif/exif"
+	"bytes"
+	"github.com/straup/go-image-tools/crop"
+	"github.com/straup/go-image-tools/deskew"
 	"github.com/straup/go-image-tools/halftone"
 	"github.com/straup/go-image-tools/util"
-	_ "log"
-	"os"
-	"path/filepath"
+	"image"
+	"io/ioutil"
+	"log"
 )
 
+// DecodeOptions are used to decode images before they are pre-processed. The
+// limits are checked so that oversized images (or decompression bombs) are
+// rejected rather than decoded. Pre-processed images are written without any
+// metadata so embedded colour profiles need to be applied now or not at all.
+// Images are not auto-oriented since that is what the "rotate" step is for.
+
+// TempStore, if not nil, is where pre-processed images are written; otherwise
+// they are written to the system's temporary directory and it's up to the
+// caller to remove them.
+
+// Cache, if not nil, is checked before (and updated after) doing anything so
+// that images which have already been processed the same way aren't processed
+// again. Cached images are written to the cache instead of TempStore.
+
+type PreProcessOptions struct {
+	DecodeOptions util.DecodeOptions
+	EncodeOptions util.EncodeOptions
+	TempStore     *util.TempStore
+	Cache         *util.Cache
+}
+
+// bump this whenever a pre-process function changes what it produces so that
+// anything already in a cache is ignored
+
+const preprocess_cache_version = 1
+
+func NewDefaultPreProcessOptions() PreProcessOptions {
+
+	decode_opts := util.NewDefaultDecodeOptions()
+	decode_opts.AutoOrient = false
+
+	opts := PreProcessOptions{
+		DecodeOptions: decode_opts,
+		EncodeOptions: util.NewDefaultEncodeOptions(),
+	}
+
+	return opts
+}
+
 func DefaultPreProcessFunc(path string) (string, error) {
 	return "", nil
 }
 
 // https://www.daveperrett.com/articles/2012/07/28/exif-orientation-handling-is-a-ghetto/
 
+// orientation is read from the image itself (JPEG, PNG and TIFF) rather than
+// guessed from its extension, so "FOO.JPG" and "foo.png" work too
+
 func RotatePreProcessFunc(path string) (string, error) {
 
-	ext := filepath.Ext(path)
+	opts := NewDefaultPreProcessOptions()
+	return RotatePreProcessFuncWithOptions(path, opts)
+}
 
-	if ext != ".jpg" && ext != ".jpeg" {
-		return "", nil
+func RotatePreProcessFuncWithOptions(path string, pre_opts PreProcessOptions) (string, error) {
+
+	body, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return "", err
 	}
 
-	fh, err := os.Open(path)
+	orientation, err := util.Orientation(body)
 
 	if err != nil {
 		return "", err
 	}
 
-	defer fh.Close()
+	if orientation == 1 {
+		return "", nil
+	}
+
+	key, cached_path, ok, err := cacheLookup(path, "rotate", pre_opts)
+
+	if err != nil || ok {
+		return cached_path, err
+	}
 
-	x, err := exif.Decode(fh)
+	im, format, err := util.DecodeImageFromReaderWithOptions(bytes.NewReader(body), pre_opts.DecodeOptions)
 
 	if err != nil {
 		return "", err
 	}
 
-	tag, err := x.Get(exif.Orientation)
+	rotated := util.Orient(im, orientation)
 
-	if err != nil {
-		return "", nil
+	return encodeTempImage(key, rotated, util.OutputFormat(format), pre_opts)
+}
+
+func HalftonePreProcessFunc(path string) (string, error) {
+
+	opts := NewDefaultPreProcessOptions()
+	return HalftonePreProcessFuncWithOptions(path, opts)
+}
+
+func HalftonePreProcessFuncWithOptions(path string, pre_opts PreProcessOptions) (string, error) {
+
+	opts := halftone.NewDefaultHalftoneOptions()
+
+	key, cached_path, ok, err := cacheLookup(path, "halftone", pre_opts, opts)
+
+	if err != nil || ok {
+		return cached_path, err
 	}
 
-	// log.Println(path, tag)
+	im, format, err := util.DecodeImageWithOptions(path, pre_opts.DecodeOptions)
 
-	orientation, err := tag.Int64(0)
+	if err != nil {
+		return "", err
+	}
+
+	dithered, err := halftone.Halftone(im, opts)
 
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
-	if orientation == 1 {
-		return "", nil
+	return encodeTempImage(key, dithered, util.OutputFormat(format), pre_opts)
+}
+
+func TrimPreProcessFunc(path string) (string, error) {
+
+	opts := NewDefaultPreProcessOptions()
+	return TrimPreProcessFuncWithOptions(path, opts)
+}
+
+func TrimPreProcessFuncWithOptions(path string, pre_opts PreProcessOptions) (string, error) {
+
+	opts := crop.NewDefaultCropOptions()
+
+	key, cached_path, ok, err := cacheLookup(path, "trim", pre_opts, opts.Tolerance)
+
+	if err != nil || ok {
+		return cached_path, err
 	}
 
-	im, format, err := util.DecodeImage(path)
+	im, format, err := util.DecodeImageWithOptions(path, pre_opts.DecodeOptions)
 
 	if err != nil {
 		return "", err
 	}
 
-	angle, _, _ := exifutil.ProcessOrientation(orientation)
-	rotated := exifutil.Rotate(im, angle)
+	r := crop.TrimRectangle(im, opts.Tolerance)
+
+	if r == im.Bounds() {
+		return cacheNone(key, pre_opts)
+	}
 
-	return util.EncodeTempImage(rotated, format)
+	trimmed := crop.CropImage(im, r)
+
+	return encodeTempImage(key, trimmed, util.OutputFormat(format), pre_opts)
 }
 
-func HalftonePreProcessFunc(path string) (string, error) {
+func DeskewPreProcessFunc(path string) (string, error) {
+
+	opts := NewDefaultPreProcessOptions()
+	return DeskewPreProcessFuncWithOptions(path, opts)
+}
+
+func DeskewPreProcessFuncWithOptions(path string, pre_opts PreProcessOptions) (string, error) {
+
+	opts := deskew.NewDefaultDeskewOptions()
 
-	im, format, err := util.DecodeImage(path)
+	key, cached_path, ok, err := cacheLookup(path, "deskew", pre_opts, opts)
+
+	if err != nil || ok {
+		return cached_path, err
+	}
+
+	im, format, err := util.DecodeImageWithOptions(path, pre_opts.DecodeOptions)
 
 	if err != nil {
 		return "", err
 	}
 
-	opts := halftone.NewDefaultHalftoneOptions()
-	dithered, err := halftone.Halftone(im, opts)
+	deskewed, angle, err := deskew.Deskew(im, opts)
 
 	if err != nil {
 		return "", err
 	}
 
-	return util.EncodeTempImage(dithered, format)
+	log.Printf("%s skewed by %0.2f degrees\n", path, angle)
+
+	if angle == 0.0 {
+		return cacheNone(key, pre_opts)
+	}
+
+	return encodeTempImage(key, deskewed, util.OutputFormat(format), pre_opts)
+}
+
+// cacheLookup returns the cache key for op, with params, applied to the image
+// at path and, if it has already been done, the result. There is never a key
+// or a result if opts.Cache is nil.
+
+func cacheLookup(path string, op string, opts PreProcessOptions, params ...interface{}) (string, string, bool, error) {
+
+	if opts.Cache == nil {
+		return "", "", false, nil
+	}
+
+	// the encode options are part of the key but a pointer to some
+	// metadata is not
+
+	encode_opts := opts.EncodeOptions
+	encode_opts.Metadata = nil
+
+	params = append(params, preprocess_cache_version, opts.DecodeOptions, encode_opts)
+
+	key, err := opts.Cache.Key(path, op, params...)
+
+	if err != nil {
+		return "", "", false, err
+	}
+
+	cached_path, ok := opts.Cache.Lookup(key)
+	return key, cached_path, ok, nil
+}
+
+// cacheNone records that there was nothing to do for key, so that we don't
+// find that out the hard way next time
+
+func cacheNone(key string, opts PreProcessOptions) (string, error) {
+
+	if opts.Cache != nil && key != "" {
+
+		err := opts.Cache.AddNone(key)
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return "", nil
+}
+
+func encodeTempImage(key string, im image.Image, format string, opts PreProcessOptions) (string, error) {
+
+	if opts.Cache != nil && key != "" {
+		return opts.Cache.EncodeImageWithOptions(key, im, format, opts.EncodeOptions)
+	}
+
+	if opts.TempStore != nil {
+		return opts.TempStore.EncodeImageWithOptions(im, format, opts.EncodeOptions)
+	}
+
+	return util.EncodeTempImageWithOptions(im, format, opts.EncodeOptions)
 }
@@ -0,0 +1,97 @@
+package functions
+
+import (
+	"errors"
+	"github.com/straup/go-image-tools/util"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreProcessDecodeLimits(t *testing.T) {
+
+	im := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			im.Set(x, y, color.NRGBA{uint8(x * 4), uint8(y * 5), 0, 255})
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "photo.png")
+
+	fh, err := os.Create(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = png.Encode(fh, im)
+	fh.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	funcs := map[string]func(string, PreProcessOptions) (string, error){
+		"halftone": HalftonePreProcessFuncWithOptions,
+		"trim":     TrimPreProcessFuncWithOptions,
+		"deskew":   DeskewPreProcessFuncWithOptions,
+	}
+
+	tests := []struct {
+		name          string
+		max_dimension int
+		max_pixels    int64
+		max_memory    int64
+		limit         string
+	}{
+		{"dimension", 50, 0, 0, "dimension"},
+		{"pixels", 0, 1000, 0, "pixels"},
+		{"memory", 0, 0, 1024, "memory"},
+		{"within limits", 64, 64 * 48, 1024 * 1024, ""},
+	}
+
+	for _, test := range tests {
+
+		for op, fn := range funcs {
+
+			opts := NewDefaultPreProcessOptions()
+			opts.TempStore, err = util.NewTempStore("preprocess-test-")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opts.DecodeOptions.MaxDimension = test.max_dimension
+			opts.DecodeOptions.MaxPixels = test.max_pixels
+			opts.DecodeOptions.MaxMemory = test.max_memory
+
+			_, err := fn(path, opts)
+			opts.TempStore.Close()
+
+			if test.limit == "" {
+
+				if err != nil {
+					t.Errorf("%s: %s: unexpected error %v", test.name, op, err)
+				}
+
+				continue
+			}
+
+			var limit_err *util.DecodeLimitError
+
+			if !errors.As(err, &limit_err) {
+				t.Errorf("%s: %s: expected a DecodeLimitError, got %v", test.name, op, err)
+				continue
+			}
+
+			if limit_err.Limit != test.limit {
+				t.Errorf("%s: %s: expected the %s limit, got %s", test.name, op, test.limit, limit_err.Limit)
+			}
+		}
+	}
+}
@@ -1,29 +1,38 @@
 package picturebook
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/jung-kurt/gofpdf"
 	"github.com/rainycape/unidecode"
 	"github.com/straup/go-image-tools/picturebook/functions"
 	"github.com/straup/go-image-tools/util"
+	"image"
+	"image/draw"
+	"io/fs"
+	"io/ioutil"
 	"log"
-	"os"
-	"path/filepath"
 	"sync"
 )
 
 type PictureBookOptions struct {
-	Orientation string
-	Size        string
-	Width       float64
-	Height      float64
-	DPI         float64
-	Border      float64
-	Filter      functions.PictureBookFilterFunc
-	PreProcess  functions.PictureBookPreProcessFunc
-	Caption     functions.PictureBookCaptionFunc
-	Debug       bool
+	Orientation  string
+	Size         string
+	Width        float64
+	Height       float64
+	DPI          float64
+	Border       float64
+	Filter       functions.PictureBookFilterFunc
+	Walk         util.WalkOptions
+	PreProcess   functions.PictureBookPreProcessFunc
+	Caption      functions.PictureBookCaptionFunc
+	ColourManage bool
+	MaxDimension int
+	MaxPixels    int64
+	MaxMemory    int64
+	TempStore    *util.TempStore
+	Debug        bool
 }
 
 type PictureBookBorder struct {
@@ -54,6 +63,7 @@ type PictureBook struct {
 	Text    PictureBookText
 	Options PictureBookOptions
 	pages   int
+	temp    *util.TempStore
 }
 
 func NewPictureBookDefaultOptions() PictureBookOptions {
@@ -63,16 +73,21 @@ func NewPictureBookDefaultOptions() PictureBookOptions {
 	capt := functions.DefaultCaptionFunc
 
 	opts := PictureBookOptions{
-		Orientation: "P",
-		Size:        "letter",
-		Width:       0.0,
-		Height:      0.0,
-		DPI:         150.0,
-		Border:      0.01,
-		Filter:      filter,
-		PreProcess:  prep,
-		Caption:     capt,
-		Debug:       false,
+		Orientation:  "P",
+		Size:         "letter",
+		Width:        0.0,
+		Height:       0.0,
+		DPI:          150.0,
+		Border:       0.01,
+		Filter:       filter,
+		Walk:         util.NewDefaultWalkOptions(),
+		PreProcess:   prep,
+		Caption:      capt,
+		ColourManage: true,
+		MaxDimension: 50000,
+		MaxPixels:    200 * 1000 * 1000,
+		MaxMemory:    2 * 1024 * 1024 * 1024,
+		Debug:        false,
 	}
 
 	return opts
@@ -142,6 +157,23 @@ func NewPictureBook(opts PictureBookOptions) (*PictureBook, error) {
 		Height: canvas_h,
 	}
 
+	// if we weren't given somewhere to put temporary files then we make (and
+	// are responsible for removing) our own; see Close
+
+	var temp *util.TempStore
+
+	if opts.TempStore == nil {
+
+		store, err := util.NewTempStore("picturebook-")
+
+		if err != nil {
+			return nil, err
+		}
+
+		temp = store
+		opts.TempStore = store
+	}
+
 	mu := new(sync.Mutex)
 
 	pb := PictureBook{
@@ -152,29 +184,59 @@ func NewPictureBook(opts PictureBookOptions) (*PictureBook, error) {
 		Text:    t,
 		Options: opts,
 		pages:   0,
+		temp:    temp,
 	}
 
 	return &pb, nil
 }
 
+// AddPictures adds every image in paths, each of which may be a directory, a
+// single image or a zip or tar(.gz) file of images; see util.OpenSource
+
 func (pb *PictureBook) AddPictures(paths []string) error {
 
-	cb := func(path string, info os.FileInfo, err error) error {
+	for _, path := range paths {
+
+		src, err := util.OpenSource(path)
 
 		if err != nil {
 			return err
 		}
 
-		if info.IsDir() {
-			return nil
-		}
+		err = pb.AddPicturesFromSource(src)
 
-		abs_path, err := filepath.Abs(path)
+		src.Close()
 
 		if err != nil {
-			// log.Println("PATH", abs_path, err)
-			return nil
+			return err
 		}
+	}
+
+	return nil
+}
+
+// AddPicturesFromFS adds every image in fsys, starting at root
+
+func (pb *PictureBook) AddPicturesFromFS(fsys fs.FS, root string) error {
+
+	src := util.NewSource(fsys, root, root)
+	return pb.AddPicturesFromSource(src)
+}
+
+// AddPicturesFromSource adds every image in src that is included by the Walk
+// options and the Filter function
+
+func (pb *PictureBook) AddPicturesFromSource(src *util.Source) error {
+
+	temp := pb.Options.TempStore
+
+	cb := func(name string) error {
+
+		// the path that filter functions see, and that we report, is the
+		// file's absolute path or, for files in archives, the path of the
+		// archive followed by the path in the archive
+
+		abs_path := src.Path(name)
 
 		ok, err := pb.Options.Filter(abs_path)
 
@@ -187,14 +249,35 @@ func (pb *PictureBook) AddPictures(paths []string) error {
 			return nil
 		}
 
-		processed_path, err := pb.Options.PreProcess(abs_path)
+		// pre-process functions (and gofpdf) want a file on disk which,
+		// for files in archives, means a temporary copy
+
+		local_path, err := src.LocalPath(name, temp)
+
+		if err != nil {
+			// log.Println("PATH", abs_path, err)
+			return nil
+		}
+
+		defer temp.Release(local_path)
+
+		processed_path, err := pb.Options.PreProcess(local_path)
 
 		if err != nil {
+
+			// images that are too big to decode safely, or broken, are
+			// skipped and reported rather than bringing everything else
+			// down
+
+			if skippable(err) {
+				log.Printf("skipping %s, %v\n", abs_path, err)
+			}
+
 			// log.Println("PROCESS", abs_path, err)
 			return nil
 		}
 
-		caption, err := pb.Options.Caption(abs_path)
+		caption, err := pb.Options.Caption(src.FS, name)
 
 		if err != nil {
 			// log.Println("CAPTION", abs_path, err)
@@ -206,26 +289,36 @@ func (pb *PictureBook) AddPictures(paths []string) error {
 		pagenum := pb.pages
 		pb.Mutex.Unlock()
 
-		err = pb.AddPicture(pagenum, processed_path, caption)
+		// pre-process functions return "" if there was nothing to do
 
-		if err != nil {
-			// log.Println("ADD", abs_path, err)
-			return nil
+		if processed_path == "" {
+			processed_path = local_path
 		}
 
-		return nil
-	}
+		err = pb.AddPicture(pagenum, processed_path, caption)
 
-	for _, path := range paths {
+		// gofpdf has read the image by now (or failed to) so we're done
+		// with any pre-processed file; this is a no-op for anything that
+		// isn't in the temp store
 
-		err := filepath.Walk(path, cb)
+		if processed_path != local_path {
+			temp.Release(processed_path)
+		}
 
 		if err != nil {
-			return err
+
+			if skippable(err) {
+				log.Printf("skipping %s, %v\n", abs_path, err)
+			}
+
+			// log.Println("ADD", abs_path, err)
+			return nil
 		}
+
+		return nil
 	}
 
-	return nil
+	return src.WalkWithOptions(pb.Options.Walk, cb)
 }
 
 func (pb *PictureBook) AddPicture(pagenum int, abs_path string, caption string) error {
@@ -233,12 +326,82 @@ func (pb *PictureBook) AddPicture(pagenum int, abs_path string, caption string)
 	pb.Mutex.Lock()
 	defer pb.Mutex.Unlock()
 
-	im, format, err := util.DecodeImage(abs_path)
+	body, err := ioutil.ReadFile(abs_path)
+
+	if err != nil {
+		return err
+	}
+
+	// orientation is left to the pre-process function and colour profiles
+	// are dealt with below, but the limits are always applied
+
+	decode_opts := util.DecodeOptions{
+		MaxDimension: pb.Options.MaxDimension,
+		MaxPixels:    pb.Options.MaxPixels,
+		MaxMemory:    pb.Options.MaxMemory,
+	}
+
+	im, format, err := util.DecodeImageFromReaderWithOptions(bytes.NewReader(body), decode_opts)
 
 	if err != nil {
 		return err
 	}
 
+	// gofpdf only knows how to embed JPEG, PNG and GIF images so anything
+	// else (TIFF, BMP, WebP) is converted to a temporary PNG file first; it
+	// is safe to remove that as soon as we're done because gofpdf reads the
+	// image data when it is registered
+
+	convert := !embeddable(format)
+
+	// gofpdf decides what kind of image it's looking at by its extension so
+	// a PNG file called "foo.jpg" needs to be renamed, which is to say
+	// converted, too
+
+	err = util.CheckExtension(abs_path, body)
+
+	if err != nil {
+		log.Printf("Warning: %v\n", err)
+		convert = true
+	}
+
+	// gofpdf also ignores embedded colour profiles so images that aren't
+	// sRGB need to be converted too
+
+	if pb.Options.ColourManage {
+
+		managed, ok, err := util.ColourManage(im, body)
+
+		if util.IsDecodeLimitError(err) {
+			return err
+		}
+
+		if err == nil && ok {
+			im = managed
+			convert = true
+		}
+	}
+
+	if convert {
+
+		tmp_format := "png"
+
+		if format == "jpeg" {
+			tmp_format = "jpeg"
+		}
+
+		tmp_path, err := pb.Options.TempStore.EncodeImage(toNRGBA(im), tmp_format)
+
+		if err != nil {
+			return err
+		}
+
+		defer pb.Options.TempStore.Release(tmp_path)
+
+		abs_path = tmp_path
+		format = tmp_format
+	}
+
 	dims := im.Bounds()
 
 	info := pb.PDF.GetImageInfo(abs_path)
@@ -319,7 +482,7 @@ func (pb *PictureBook) AddPicture(pagenum int, abs_path string, caption string)
 	}
 
 	// if max_h > max_w && h < (max_h - pb.Border.Top) {
-	
+
 	if h < (max_h - pb.Border.Top) {
 
 		y = y + pb.Border.Top
@@ -407,6 +570,57 @@ func (pb *PictureBook) AddPicture(pagenum int, abs_path string, caption string)
 	return nil
 }
 
+// skippable returns true for errors that mean there's something wrong with a
+// particular image. Files that aren't images at all (like JSON sidecar files)
+// are skipped too, but quietly, so they aren't included.
+
+func skippable(err error) bool {
+
+	for _, kind := range []error{util.ErrDecodeLimit, util.ErrTruncatedImage, util.ErrCorruptImage} {
+
+		if errors.Is(err, kind) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func embeddable(format string) bool {
+
+	switch format {
+	case "jpeg", "png", "gif":
+		return true
+	default:
+		return false
+	}
+}
+
+// toNRGBA returns an 8-bit copy of im since gofpdf can't embed 16-bit PNG
+// files, which is what the encoder writes for 16-bit TIFF masters
+
+func toNRGBA(im image.Image) image.Image {
+
+	dims := im.Bounds()
+
+	nrgba := image.NewNRGBA(image.Rect(0, 0, dims.Dx(), dims.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), im, dims.Min, draw.Src)
+
+	return nrgba
+}
+
+// Close removes any temporary files the picture book made. Temp stores passed
+// in PictureBookOptions are left for the caller to close.
+
+func (pb *PictureBook) Close() error {
+
+	if pb.temp == nil {
+		return nil
+	}
+
+	return pb.temp.Close()
+}
+
 func (pb *PictureBook) Save(path string) error {
 
 	if pb.Options.Debug {
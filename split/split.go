@@ -0,0 +1,392 @@
+package split
+
+import (
+	"github.com/nfnt/resize"
+	"github.com/straup/go-image-tools/crop"
+	"github.com/straup/go-image-tools/deskew"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+)
+
+type SplitOptions struct {
+	Tolerance float64
+	MinArea   float64
+	Padding   int
+	Deskew    bool
+}
+
+// Region is a single picture found in a scan. Angle is the skew that was
+// corrected, if SplitOptions.Deskew is true.
+
+type Region struct {
+	Bounds image.Rectangle
+	Image  image.Image
+	Angle  float64
+}
+
+// images are scaled down to this size (on their longest side) before being
+// segmented to keep things fast
+
+const split_max_dimension = 1000
+
+func NewDefaultSplitOptions() SplitOptions {
+
+	opts := SplitOptions{
+		Tolerance: 32.0,
+		MinArea:   0.01,
+		Padding:   0,
+		Deskew:    false,
+	}
+
+	return opts
+}
+
+// Split finds the separate pictures in a scan of several prints and returns
+// each one, in reading order (top to bottom, left to right). If opts.Deskew is
+// true each picture is straightened using the outline of the region it was
+// found in, rather than its contents, since that is what is really skewed.
+
+func Split(im image.Image, opts SplitOptions) ([]Region, error) {
+
+	seg := segmentImage(im, opts)
+
+	regions := make([]Region, 0)
+
+	for i, b := range seg.regions {
+
+		r := Region{
+			Bounds: b,
+			Image:  crop.CropImage(im, b),
+		}
+
+		if opts.Deskew {
+
+			outline := seg.outline(seg.boxes[i])
+
+			d_opts := deskew.NewDefaultDeskewOptions()
+			d_opts.Fill = seg.background
+
+			angle, err := deskew.DetectSkew(outline, d_opts)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if angle != 0.0 {
+				deskewed := deskew.Rotate(r.Image, angle, seg.background)
+				trimmed := crop.TrimRectangle(deskewed, opts.Tolerance)
+				r.Image = crop.CropImage(deskewed, trimmed)
+				r.Angle = angle
+			}
+		}
+
+		regions = append(regions, r)
+	}
+
+	return regions, nil
+}
+
+// Regions returns the bounding box of each picture in a scan, in reading order,
+// along with the colour of the scanner bed. Pixels are considered background if
+// they are within opts.Tolerance (0 - 255, in any one channel) of the average
+// colour around the edge of the scan. Bounding boxes smaller than opts.MinArea
+// (as a fraction of the scan) are ignored and overlapping ones are merged.
+
+func Regions(im image.Image, opts SplitOptions) ([]image.Rectangle, color.Color) {
+
+	seg := segmentImage(im, opts)
+	return seg.regions, seg.background
+}
+
+type segmentation struct {
+	mask       []bool
+	width      int
+	background color.NRGBA
+	// bounding boxes in the scaled down mask
+	boxes []image.Rectangle
+	// the same bounding boxes in the original image
+	regions []image.Rectangle
+}
+
+func segmentImage(im image.Image, opts SplitOptions) *segmentation {
+
+	dims := im.Bounds()
+
+	scale := 1.0
+	thumb := im
+
+	longest := math.Max(float64(dims.Dx()), float64(dims.Dy()))
+
+	if longest > split_max_dimension {
+		scale = longest / split_max_dimension
+		thumb = resize.Thumbnail(split_max_dimension, split_max_dimension, im, resize.Bilinear)
+	}
+
+	t_dims := thumb.Bounds()
+
+	w := t_dims.Dx()
+	h := t_dims.Dy()
+
+	rgba := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), thumb, t_dims.Min, draw.Src)
+
+	background := borderColour(rgba)
+
+	mask := foreground(rgba, background, opts.Tolerance)
+	mask = dilate(mask, w, h, 2)
+
+	min_area := opts.MinArea * float64(w*h)
+
+	type pair struct {
+		box    image.Rectangle
+		region image.Rectangle
+	}
+
+	pairs := make([]pair, 0)
+
+	for _, b := range merge(components(mask, w, h)) {
+
+		if float64(b.Dx()*b.Dy()) < min_area {
+			continue
+		}
+
+		r := image.Rect(
+			dims.Min.X+int(math.Floor(float64(b.Min.X)*scale))-opts.Padding,
+			dims.Min.Y+int(math.Floor(float64(b.Min.Y)*scale))-opts.Padding,
+			dims.Min.X+int(math.Ceil(float64(b.Max.X)*scale))+opts.Padding,
+			dims.Min.Y+int(math.Ceil(float64(b.Max.Y)*scale))+opts.Padding,
+		)
+
+		pairs = append(pairs, pair{box: b, region: r.Intersect(dims)})
+	}
+
+	// reading order, allowing for pictures that are roughly but not exactly
+	// lined up with one another
+
+	sort.Slice(pairs, func(i, j int) bool {
+
+		a := pairs[i].box
+		b := pairs[j].box
+
+		if a.Max.Y <= b.Min.Y+(b.Dy()/4) {
+			return true
+		}
+
+		if b.Max.Y <= a.Min.Y+(a.Dy()/4) {
+			return false
+		}
+
+		return a.Min.X < b.Min.X
+	})
+
+	seg := segmentation{
+		mask:       mask,
+		width:      w,
+		background: background,
+		boxes:      make([]image.Rectangle, len(pairs)),
+		regions:    make([]image.Rectangle, len(pairs)),
+	}
+
+	for i, p := range pairs {
+		seg.boxes[i] = p.box
+		seg.regions[i] = p.region
+	}
+
+	return &seg
+}
+
+// outline returns the foreground mask inside box as a black and white image
+
+func (seg *segmentation) outline(box image.Rectangle) image.Image {
+
+	grey := image.NewGray(image.Rect(0, 0, box.Dx(), box.Dy()))
+
+	for y := box.Min.Y; y < box.Max.Y; y++ {
+
+		for x := box.Min.X; x < box.Max.X; x++ {
+
+			if seg.mask[y*seg.width+x] {
+				grey.Pix[(y-box.Min.Y)*grey.Stride+(x-box.Min.X)] = 255
+			}
+		}
+	}
+
+	return grey
+}
+
+// borderColour returns the average colour of the outermost pixels of im
+
+func borderColour(im *image.NRGBA) color.NRGBA {
+
+	dims := im.Bounds()
+
+	var r, g, b, n float64
+
+	add := func(x int, y int) {
+		c := im.NRGBAAt(x, y)
+		r += float64(c.R)
+		g += float64(c.G)
+		b += float64(c.B)
+		n += 1
+	}
+
+	for x := dims.Min.X; x < dims.Max.X; x++ {
+		add(x, dims.Min.Y)
+		add(x, dims.Max.Y-1)
+	}
+
+	for y := dims.Min.Y + 1; y < dims.Max.Y-1; y++ {
+		add(dims.Min.X, y)
+		add(dims.Max.X-1, y)
+	}
+
+	c := color.NRGBA{
+		R: uint8(r / n),
+		G: uint8(g / n),
+		B: uint8(b / n),
+		A: 255,
+	}
+
+	return c
+}
+
+func foreground(im *image.NRGBA, background color.NRGBA, tolerance float64) []bool {
+
+	dims := im.Bounds()
+	mask := make([]bool, dims.Dx()*dims.Dy())
+
+	for y := 0; y < dims.Dy(); y++ {
+
+		for x := 0; x < dims.Dx(); x++ {
+
+			c := im.NRGBAAt(x, y)
+
+			dr := math.Abs(float64(c.R) - float64(background.R))
+			dg := math.Abs(float64(c.G) - float64(background.G))
+			db := math.Abs(float64(c.B) - float64(background.B))
+
+			mask[y*dims.Dx()+x] = dr > tolerance || dg > tolerance || db > tolerance
+		}
+	}
+
+	return mask
+}
+
+// dilate grows the foreground by radius pixels so that pictures with areas
+// close to the colour of the scanner bed aren't broken in to pieces
+
+func dilate(mask []bool, w int, h int, radius int) []bool {
+
+	grown := make([]bool, len(mask))
+
+	for y := 0; y < h; y++ {
+
+		for x := 0; x < w; x++ {
+
+			if !mask[y*w+x] {
+				continue
+			}
+
+			for dy := -radius; dy <= radius; dy++ {
+
+				for dx := -radius; dx <= radius; dx++ {
+
+					nx := x + dx
+					ny := y + dy
+
+					if nx >= 0 && ny >= 0 && nx < w && ny < h {
+						grown[ny*w+nx] = true
+					}
+				}
+			}
+		}
+	}
+
+	return grown
+}
+
+// components returns the bounding box of every 4-connected foreground region
+
+func components(mask []bool, w int, h int) []image.Rectangle {
+
+	seen := make([]bool, len(mask))
+	boxes := make([]image.Rectangle, 0)
+
+	stack := make([]int, 0)
+
+	for i, fg := range mask {
+
+		if !fg || seen[i] {
+			continue
+		}
+
+		box := image.Rect(i%w, i/w, i%w+1, i/w+1)
+
+		seen[i] = true
+		stack = append(stack[:0], i)
+
+		for len(stack) > 0 {
+
+			p := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			x := p % w
+			y := p / w
+
+			box = box.Union(image.Rect(x, y, x+1, y+1))
+
+			neighbours := [][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}}
+
+			for _, n := range neighbours {
+
+				if n[0] < 0 || n[1] < 0 || n[0] >= w || n[1] >= h {
+					continue
+				}
+
+				j := n[1]*w + n[0]
+
+				if mask[j] && !seen[j] {
+					seen[j] = true
+					stack = append(stack, j)
+				}
+			}
+		}
+
+		boxes = append(boxes, box)
+	}
+
+	return boxes
+}
+
+// merge combines overlapping bounding boxes until none overlap
+
+func merge(boxes []image.Rectangle) []image.Rectangle {
+
+	for {
+
+		merged := false
+
+		for i := 0; i < len(boxes) && !merged; i++ {
+
+			for j := i + 1; j < len(boxes); j++ {
+
+				if !boxes[i].Overlaps(boxes[j]) {
+					continue
+				}
+
+				boxes[i] = boxes[i].Union(boxes[j])
+				boxes = append(boxes[:j], boxes[j+1:]...)
+
+				merged = true
+				break
+			}
+		}
+
+		if !merged {
+			return boxes
+		}
+	}
+}
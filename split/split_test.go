@@ -0,0 +1,127 @@
+package split
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// testScan returns a w x h scan of a bed of colour bg with a "photo" (a
+// pattern of darker colours) in each of photos
+
+func testScan(w int, h int, bg color.Color, photos ...image.Rectangle) *image.NRGBA {
+
+	im := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(im, im.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	for _, r := range photos {
+
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+
+			for x := r.Min.X; x < r.Max.X; x++ {
+				im.Set(x, y, color.NRGBA{uint8(40 + (x*7)%120), uint8(40 + (y*5)%120), uint8(40 + ((x+y)*3)%120), 255})
+			}
+		}
+	}
+
+	return im
+}
+
+// closeRectangle returns true if every edge of a is within tolerance pixels
+// of the same edge of b
+
+func closeRectangle(a image.Rectangle, b image.Rectangle, tolerance int) bool {
+
+	for _, d := range []int{a.Min.X - b.Min.X, a.Min.Y - b.Min.Y, a.Max.X - b.Max.X, a.Max.Y - b.Max.Y} {
+
+		if d < -tolerance || d > tolerance {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestRegions(t *testing.T) {
+
+	white := color.NRGBA{255, 255, 255, 255}
+	bed := color.NRGBA{250, 250, 248, 255}
+	black := color.NRGBA{0, 0, 0, 255}
+
+	a := image.Rect(30, 40, 250, 200)
+	b := image.Rect(320, 30, 560, 180)
+	c := image.Rect(100, 250, 400, 370)
+
+	speck := image.Rect(500, 300, 503, 303)
+
+	tests := []struct {
+		name      string
+		im        image.Image
+		padding   int
+		expected  []image.Rectangle
+		bg        color.NRGBA
+		tolerance int
+	}{
+		{"reading order", testScan(600, 400, bed, c, b, a), 0, []image.Rectangle{a, b, c}, bed, 3},
+		{"specks are ignored", testScan(600, 400, bed, a, speck), 0, []image.Rectangle{a}, bed, 3},
+		{"padding", testScan(600, 400, bed, a, b), 5, []image.Rectangle{a.Inset(-5), b.Inset(-5)}, bed, 3},
+		{"black bed", testScan(600, 400, black, a), 0, []image.Rectangle{a}, black, 3},
+		{"overlapping", testScan(600, 400, white, image.Rect(50, 50, 300, 300), image.Rect(250, 100, 500, 350)), 0, []image.Rectangle{image.Rect(50, 50, 500, 350)}, white, 3},
+		{"large scan", testScan(2400, 1600, white, image.Rect(100, 120, 1000, 900), image.Rect(1300, 200, 2300, 1500)), 0, []image.Rectangle{image.Rect(100, 120, 1000, 900), image.Rect(1300, 200, 2300, 1500)}, white, 8},
+		{"empty", testScan(300, 200, white), 0, []image.Rectangle{}, white, 0},
+	}
+
+	for _, test := range tests {
+
+		opts := NewDefaultSplitOptions()
+		opts.Padding = test.padding
+
+		regions, bg := Regions(test.im, opts)
+
+		if len(regions) != len(test.expected) {
+			t.Errorf("%s: expected %d regions, got %v", test.name, len(test.expected), regions)
+			continue
+		}
+
+		for i, r := range regions {
+
+			if !closeRectangle(r, test.expected[i], test.tolerance) {
+				t.Errorf("%s: expected region %d to be %v, got %v", test.name, i+1, test.expected[i], r)
+			}
+		}
+
+		if color.NRGBAModel.Convert(bg) != test.bg {
+			t.Errorf("%s: expected the background to be %v, got %v", test.name, test.bg, bg)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+
+	a := image.Rect(30, 40, 250, 200)
+	b := image.Rect(320, 30, 560, 180)
+
+	im := testScan(600, 400, color.White, a, b)
+
+	regions, err := Split(im, NewDefaultSplitOptions())
+
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if len(regions) != 2 {
+		t.Fatalf("Expected 2 regions, got %d", len(regions))
+	}
+
+	for i, r := range regions {
+
+		if r.Image.Bounds().Size() != r.Bounds.Size() {
+			t.Errorf("Expected region %d to be %v, got %v", i+1, r.Bounds.Size(), r.Image.Bounds().Size())
+		}
+
+		if r.Angle != 0.0 {
+			t.Errorf("Expected region %d not to be deskewed", i+1)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package util
+
+// https://www.w3.org/Graphics/GIF/spec-gif89a.txt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Animation is an animated GIF image. Frames are stored fully composited (each
+// one is what you'd see on screen at that point in the animation, at the full
+// size of the animation) rather than as the partial updates GIF files contain,
+// so that they can be processed like any other image.
+
+type Animation struct {
+	Frames    []image.Image
+	Delay     []int
+	Disposal  []byte
+	LoopCount int
+}
+
+func DecodeAnimation(path string) (*Animation, error) {
+
+	opts := DecodeOptions{}
+	return DecodeAnimationWithOptions(path, opts)
+}
+
+func DecodeAnimationWithOptions(path string, opts DecodeOptions) (*Animation, error) {
+
+	abs_path, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fh, err := os.Open(abs_path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	br := bufio.NewReader(fh)
+
+	err = checkExtension(abs_path, br, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeAnimationFromReaderWithOptions(br, opts)
+}
+
+func DecodeAnimationFromReader(fh io.Reader) (*Animation, error) {
+
+	opts := DecodeOptions{}
+	return DecodeAnimationFromReaderWithOptions(fh, opts)
+}
+
+// DecodeAnimationFromReaderWithOptions decodes an animated GIF, enforcing the
+// limits in opts. Orientation and colour management don't apply to GIFs. Since
+// every frame is stored at full size the memory limit applies to all of them.
+
+func DecodeAnimationFromReaderWithOptions(fh io.Reader, opts DecodeOptions) (*Animation, error) {
+
+	body, err := ioutil.ReadAll(fh)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if hasDecodeLimits(opts) {
+
+		cfg, err := gif.DecodeConfig(bytes.NewReader(body))
+
+		if err != nil {
+			return nil, decodeError(err)
+		}
+
+		err = CheckDecodeLimits(cfg, opts)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the frames are counted before anything is decoded since a small file
+	// can contain thousands of them, every one of which ends up full size
+
+	if opts.MaxMemory > 0 {
+
+		memory, err := animationMemory(body)
+
+		if err != nil {
+			return nil, decodeError(err)
+		}
+
+		if memory > opts.MaxMemory {
+			return nil, &DecodeLimitError{Limit: "memory", Value: memory, Max: opts.MaxMemory}
+		}
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(body))
+
+	if err != nil {
+		return nil, decodeError(err)
+	}
+
+	if len(g.Image) == 0 {
+		return nil, errors.New("GIF image has no frames")
+	}
+
+	canvas_r := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+
+	// some encoders don't bother with the logical screen size
+
+	if canvas_r.Empty() {
+
+		for _, frame := range g.Image {
+			canvas_r = canvas_r.Union(frame.Bounds())
+		}
+	}
+
+	canvas := image.NewNRGBA(canvas_r)
+
+	a := Animation{
+		Frames:    make([]image.Image, len(g.Image)),
+		Delay:     g.Delay,
+		Disposal:  g.Disposal,
+		LoopCount: g.LoopCount,
+	}
+
+	if len(a.Disposal) != len(g.Image) {
+		a.Disposal = make([]byte, len(g.Image))
+	}
+
+	for i, frame := range g.Image {
+
+		disposal := a.Disposal[i]
+
+		var previous *image.NRGBA
+
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(canvas_r)
+			draw.Draw(previous, canvas_r, canvas, canvas_r.Min, draw.Src)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		composite := image.NewNRGBA(canvas_r)
+		draw.Draw(composite, canvas_r, canvas, canvas_r.Min, draw.Src)
+
+		a.Frames[i] = composite
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return &a, nil
+}
+
+// animationMemory returns (roughly) how much memory decoding the GIF in body
+// with DecodeAnimation will take: a paletted image for every frame, as it is
+// in the file, and then a composited NRGBA copy of each at the size of the
+// animation. It walks the blocks of the file without decoding any of the
+// image data.
+
+func animationMemory(body []byte) (int64, error) {
+
+	if len(body) < 13 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	screen := image.Rect(0, 0, int(binary.LittleEndian.Uint16(body[6:8])), int(binary.LittleEndian.Uint16(body[8:10])))
+
+	pos := 13
+
+	// global colour table
+
+	if body[10]&0x80 != 0 {
+		pos += 3 * (1 << (uint(body[10]&0x07) + 1))
+	}
+
+	// skipSubBlocks moves pos past a sequence of data sub-blocks, which
+	// ends with an empty one
+
+	skipSubBlocks := func() error {
+
+		for {
+
+			if pos >= len(body) {
+				return io.ErrUnexpectedEOF
+			}
+
+			size := int(body[pos])
+			pos += size + 1
+
+			if size == 0 {
+				return nil
+			}
+		}
+	}
+
+	canvas_r := screen
+	frames := int64(0)
+	paletted := int64(0)
+
+	for pos < len(body) {
+
+		switch body[pos] {
+		case 0x21:
+
+			// extension: the introducer, a label and then sub-blocks
+
+			pos += 2
+
+			err := skipSubBlocks()
+
+			if err != nil {
+				return 0, err
+			}
+
+		case 0x2c:
+
+			// image descriptor: the separator, the frame's position and
+			// size, a flags byte, an optional local colour table, the LZW
+			// code size and then the image data sub-blocks
+
+			if pos+10 > len(body) {
+				return 0, io.ErrUnexpectedEOF
+			}
+
+			d := body[pos+1 : pos+10]
+
+			x := int(binary.LittleEndian.Uint16(d[0:2]))
+			y := int(binary.LittleEndian.Uint16(d[2:4]))
+			w := int(binary.LittleEndian.Uint16(d[4:6]))
+			h := int(binary.LittleEndian.Uint16(d[6:8]))
+
+			pos += 10
+
+			if d[8]&0x80 != 0 {
+				pos += 3 * (1 << (uint(d[8]&0x07) + 1))
+			}
+
+			pos += 1
+
+			err := skipSubBlocks()
+
+			if err != nil {
+				return 0, err
+			}
+
+			frame_r := image.Rect(x, y, x+w, y+h)
+
+			// some encoders don't bother with the logical screen size
+
+			if screen.Empty() {
+				canvas_r = canvas_r.Union(frame_r)
+			}
+
+			frames += 1
+			paletted += int64(w) * int64(h)
+
+		case 0x3b:
+
+			pos = len(body)
+
+		default:
+			return 0, errors.New("Invalid GIF block")
+		}
+	}
+
+	return paletted + int64(canvas_r.Dx())*int64(canvas_r.Dy())*4*frames, nil
+}
+
+// Apply returns a new Animation with cb applied to every frame. The delays,
+// disposal methods and loop count are left as they are.
+
+func (a *Animation) Apply(cb func(image.Image) (image.Image, error)) (*Animation, error) {
+
+	frames := make([]image.Image, len(a.Frames))
+
+	for i, frame := range a.Frames {
+
+		processed, err := cb(frame)
+
+		if err != nil {
+			return nil, err
+		}
+
+		frames[i] = processed
+	}
+
+	new_a := Animation{
+		Frames:    frames,
+		Delay:     a.Delay,
+		Disposal:  a.Disposal,
+		LoopCount: a.LoopCount,
+	}
+
+	return &new_a, nil
+}
+
+func EncodeAnimation(a *Animation, wr io.Writer) error {
+
+	opts := NewDefaultEncodeOptions()
+	return EncodeAnimationWithOptions(a, wr, opts)
+}
+
+// EncodeAnimationWithOptions writes a as an animated GIF, quantizing each frame
+// using the GIF settings in opts. All the frames are expected to be the same
+// size as the first one.
+
+func EncodeAnimationWithOptions(a *Animation, wr io.Writer, opts EncodeOptions) error {
+
+	if len(a.Frames) == 0 {
+		return errors.New("Animation has no frames")
+	}
+
+	gif_opts, err := gifOptions(opts)
+
+	if err != nil {
+		return err
+	}
+
+	dims := a.Frames[0].Bounds()
+
+	g := gif.GIF{
+		Image:     make([]*image.Paletted, len(a.Frames)),
+		Delay:     a.Delay,
+		Disposal:  a.Disposal,
+		LoopCount: a.LoopCount,
+		Config: image.Config{
+			Width:  dims.Dx(),
+			Height: dims.Dy(),
+		},
+	}
+
+	for i, frame := range a.Frames {
+
+		if frame.Bounds().Dx() != dims.Dx() || frame.Bounds().Dy() != dims.Dy() {
+			return errors.New("Animation frames have different dimensions")
+		}
+
+		g.Image[i] = palettedFrame(frame, gif_opts)
+	}
+
+	return gif.EncodeAll(wr, &g)
+}
+
+// palettedFrame quantizes im, moved to the origin, reserving the last entry
+// in its palette for transparent pixels if there are any
+
+func palettedFrame(im image.Image, gif_opts *gif.Options) *image.Paletted {
+
+	dims := im.Bounds()
+	r := image.Rect(0, 0, dims.Dx(), dims.Dy())
+
+	transparent := false
+
+	for y := dims.Min.Y; y < dims.Max.Y && !transparent; y++ {
+
+		for x := dims.Min.X; x < dims.Max.X; x++ {
+
+			_, _, _, a := im.At(x, y).RGBA()
+
+			if a < 0x8000 {
+				transparent = true
+				break
+			}
+		}
+	}
+
+	count := gif_opts.NumColors
+
+	if transparent {
+		count -= 1
+	}
+
+	var p color.Palette
+
+	if gif_opts.Quantizer != nil {
+		p = gif_opts.Quantizer.Quantize(make(color.Palette, 0, count), im)
+	} else {
+		p = make(color.Palette, count)
+		copy(p, palette.Plan9[:count])
+	}
+
+	if transparent {
+		p = append(p, color.Transparent)
+	}
+
+	pm := image.NewPaletted(r, p)
+	gif_opts.Drawer.Draw(pm, r, im, dims.Min)
+
+	if transparent {
+
+		idx := uint8(len(p) - 1)
+
+		for y := 0; y < r.Dy(); y++ {
+
+			for x := 0; x < r.Dx(); x++ {
+
+				_, _, _, a := im.At(dims.Min.X+x, dims.Min.Y+y).RGBA()
+
+				if a < 0x8000 {
+					pm.SetColorIndex(x, y, idx)
+				}
+			}
+		}
+	}
+
+	return pm
+}
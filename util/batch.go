@@ -0,0 +1,214 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// BatchOptions control how RunBatch processes images. Workers is the number
+// of images processed at the same time. If ContinueOnError is false nothing
+// new is started after the first failure. Images are found according to the
+// WalkOptions.
+
+type BatchOptions struct {
+	WalkOptions
+	Workers         int
+	ContinueOnError bool
+}
+
+var errBatchStopped = errors.New("Batch stopped")
+
+func NewDefaultBatchOptions() BatchOptions {
+
+	opts := BatchOptions{
+		WalkOptions:     NewDefaultWalkOptions(),
+		Workers:         1,
+		ContinueOnError: true,
+	}
+
+	return opts
+}
+
+// FileError is the error for one image (or path) in a batch
+
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is returned by RunBatch when anything failed. Errors has one
+// FileError for each failure, in the order they happened.
+
+type BatchError struct {
+	Total  int
+	Errors []*FileError
+}
+
+func (e *BatchError) Error() string {
+
+	lines := []string{
+		fmt.Sprintf("%d of %d images failed", len(e.Errors), e.Total),
+	}
+
+	for _, err := range e.Errors {
+		lines = append(lines, fmt.Sprintf("  %v", err))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// RunBatch calls cb for every image in paths, like WalkSourcesWithOptions, but
+// with up to opts.Workers images at once. Errors, from cb or from paths that
+// can't be read, are logged as they happen and then returned together as a
+// BatchError once everything else is done. Images in archives are read in
+// order, one at a time, and only copied to temp when there is a worker ready
+// for them.
+
+func RunBatch(paths []string, temp *TempStore, opts BatchOptions, cb func(*SourceFile) error) error {
+
+	workers := opts.Workers
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	mutex := new(sync.Mutex)
+
+	total := 0
+	failures := make([]*FileError, 0)
+	stopped := false
+
+	fail := func(path string, err error) {
+
+		log.Printf("%s: %v\n", path, err)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		failures = append(failures, &FileError{Path: path, Err: err})
+
+		if !opts.ContinueOnError {
+			stopped = true
+		}
+	}
+
+	// paths that can't be read at all count as one image
+
+	failPath := func(path string, err error) {
+
+		mutex.Lock()
+		total += 1
+		mutex.Unlock()
+
+		fail(path, err)
+	}
+
+	isStopped := func() bool {
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return stopped
+	}
+
+	jobs := make(chan *SourceFile)
+
+	// pending is the images from the current source that haven't finished
+	// yet, since the source can't be closed until they have
+
+	pending := new(sync.WaitGroup)
+	running := new(sync.WaitGroup)
+
+	for i := 0; i < workers; i++ {
+
+		running.Add(1)
+
+		go func() {
+
+			defer running.Done()
+
+			for f := range jobs {
+
+				if !isStopped() {
+
+					mutex.Lock()
+					total += 1
+					mutex.Unlock()
+
+					err := cb(f)
+
+					if err != nil {
+						fail(f.Source.Path(f.Name), err)
+					}
+				}
+
+				temp.Release(f.Path)
+				pending.Done()
+			}
+		}()
+	}
+
+	for _, p := range paths {
+
+		if isStopped() {
+			break
+		}
+
+		src, err := OpenSource(p)
+
+		if err != nil {
+			failPath(p, err)
+			continue
+		}
+
+		// the local copy of an image is released by walkImages as soon as
+		// this returns so keep it around until the worker is done with it
+
+		dispatch := func(f *SourceFile) error {
+
+			if isStopped() {
+				return errBatchStopped
+			}
+
+			temp.Retain(f.Path)
+			pending.Add(1)
+
+			jobs <- f
+			return nil
+		}
+
+		err = src.walkImages(opts.WalkOptions, temp, dispatch)
+
+		pending.Wait()
+		src.Close()
+
+		if err != nil && err != errBatchStopped {
+			failPath(p, err)
+		}
+	}
+
+	close(jobs)
+	running.Wait()
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	e := BatchError{
+		Total:  total,
+		Errors: failures,
+	}
+
+	return &e
+}
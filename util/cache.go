@@ -0,0 +1,434 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache is an on-disk cache of derivative images, keyed by a hash of the
+// source image and the operation (and its parameters) that produced them. When
+// the cache grows beyond MaxSize bytes the least recently used images are
+// removed; a MaxSize of 0 means the cache can grow forever. Files are stored
+// as Root/ab/abcdef...(.ext) so that no one directory gets too big.
+//
+// A Cache can be shared by different processes, although each one keeps its
+// own idea of what's in it and how big it is.
+
+type Cache struct {
+	Root    string
+	MaxSize int64
+	mutex   *sync.Mutex
+	entries map[string]*cacheEntry
+	size    int64
+}
+
+type cacheEntry struct {
+	path string
+	size int64
+	used time.Time
+}
+
+// the extension for cache entries that record there being nothing to do (for
+// example rotating an image that is already the right way up)
+
+const cache_none = ".none"
+
+func NewCache(root string, max_size int64) (*Cache, error) {
+
+	abs_root, err := filepath.Abs(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(abs_root, 0755)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := Cache{
+		Root:    abs_root,
+		MaxSize: max_size,
+		mutex:   new(sync.Mutex),
+		entries: make(map[string]*cacheEntry),
+	}
+
+	walk := func(path string, info os.FileInfo, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		fname := info.Name()
+
+		// files being written by someone else
+
+		if strings.HasPrefix(fname, ".") {
+			return nil
+		}
+
+		key := strings.TrimSuffix(fname, filepath.Ext(fname))
+
+		c.entries[key] = &cacheEntry{
+			path: path,
+			size: info.Size(),
+			used: info.ModTime(),
+		}
+
+		c.size += info.Size()
+		return nil
+	}
+
+	err = filepath.Walk(abs_root, walk)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// Key returns the cache key for the result of applying op, with params, to the
+// image at path. Params are formatted with "%v" so they shouldn't contain
+// pointers (like the Metadata in EncodeOptions) whose values change from one
+// run to the next.
+
+func (c *Cache) Key(path string, op string, params ...interface{}) (string, error) {
+
+	fh, err := os.Open(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer fh.Close()
+
+	h := sha256.New()
+
+	_, err = io.Copy(h, fh)
+
+	if err != nil {
+		return "", err
+	}
+
+	return cacheKey(h.Sum(nil), op, params...), nil
+}
+
+// KeyFromBytes is like Key for an image that has already been read
+
+func (c *Cache) KeyFromBytes(body []byte, op string, params ...interface{}) string {
+
+	sum := sha256.Sum256(body)
+	return cacheKey(sum[:], op, params...)
+}
+
+// Lookup returns the path of the cached image for key, if there is one. An
+// empty path and true means that the operation was recorded (see AddNone) as
+// having nothing to do.
+
+func (c *Cache) Lookup(key string) (string, bool) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[key]
+
+	if !ok {
+		return "", false
+	}
+
+	// someone else may have removed it
+
+	_, err := os.Stat(e.path)
+
+	if err != nil {
+		c.remove(key)
+		return "", false
+	}
+
+	now := time.Now()
+	e.used = now
+
+	// the modification time is used as the last used time the next time the
+	// cache is opened
+
+	os.Chtimes(e.path, now, now)
+
+	if filepath.Ext(e.path) == cache_none {
+		return "", true
+	}
+
+	return e.path, true
+}
+
+// Add copies the file at path in to the cache, as key, and returns the path of
+// the copy.
+
+func (c *Cache) Add(key string, path string) (string, error) {
+
+	src, err := os.Open(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer src.Close()
+
+	return c.Write(key, filepath.Ext(path), func(wr io.Writer) error {
+		_, err := io.Copy(wr, src)
+		return err
+	})
+}
+
+// AddNone records that there was nothing to do for key
+
+func (c *Cache) AddNone(key string) error {
+
+	_, err := c.Write(key, cache_none, func(wr io.Writer) error {
+		return nil
+	})
+
+	return err
+}
+
+func (c *Cache) EncodeImage(key string, im image.Image, format string) (string, error) {
+
+	opts := NewDefaultEncodeOptions()
+	return c.EncodeImageWithOptions(key, im, format, opts)
+}
+
+// EncodeImageWithOptions writes im to the cache, as key, and returns its path
+
+func (c *Cache) EncodeImageWithOptions(key string, im image.Image, format string, opts EncodeOptions) (string, error) {
+
+	return c.Write(key, FormatExtension(format), func(wr io.Writer) error {
+		return EncodeImageWithOptions(im, format, wr, opts)
+	})
+}
+
+// Prune removes the least recently used images until the cache is no bigger
+// than MaxSize.
+
+func (c *Cache) Prune() error {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.prune("")
+}
+
+// Write calls cb to write the image for key, with the extension ext, in to
+// the cache and returns its path. Nothing is added if cb fails.
+
+func (c *Cache) Write(key string, ext string, cb func(io.Writer) error) (string, error) {
+
+	if len(key) < 2 {
+		return "", errors.New("Invalid cache key")
+	}
+
+	root := filepath.Join(c.Root, key[0:2])
+
+	err := os.MkdirAll(root, 0755)
+
+	if err != nil {
+		return "", err
+	}
+
+	// write to a temporary file and then move it in to place so that nothing
+	// else ever sees a partial file
+
+	fh, err := ioutil.TempFile(root, ".tmp-")
+
+	if err != nil {
+		return "", err
+	}
+
+	err = cb(fh)
+
+	close_err := fh.Close()
+
+	if err == nil {
+		err = close_err
+	}
+
+	if err != nil {
+		os.Remove(fh.Name())
+		return "", err
+	}
+
+	path := filepath.Join(root, key+ext)
+
+	err = os.Rename(fh.Name(), path)
+
+	if err != nil {
+		os.Remove(fh.Name())
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[key]
+
+	if ok {
+
+		c.size -= e.size
+
+		if e.path != path {
+			os.Remove(e.path)
+		}
+	}
+
+	c.entries[key] = &cacheEntry{
+		path: path,
+		size: info.Size(),
+		used: time.Now(),
+	}
+
+	c.size += info.Size()
+
+	// never the image we've just written, even if it's bigger than the
+	// whole cache, since the caller is about to use it
+
+	err = c.prune(key)
+
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+func (c *Cache) prune(keep string) error {
+
+	if c.MaxSize <= 0 || c.size <= c.MaxSize {
+		return nil
+	}
+
+	keys := make([]string, 0, len(c.entries))
+
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].used.Before(c.entries[keys[j]].used)
+	})
+
+	for _, key := range keys {
+
+		if c.size <= c.MaxSize {
+			break
+		}
+
+		if key == keep {
+			continue
+		}
+
+		err := c.remove(key)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Cache) remove(key string) error {
+
+	e, ok := c.entries[key]
+
+	if !ok {
+		return nil
+	}
+
+	delete(c.entries, key)
+	c.size -= e.size
+
+	err := os.Remove(e.path)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// KeyFromInput is like Key for an Input
+
+func (c *Cache) KeyFromInput(in *Input, op string, params ...interface{}) (string, error) {
+
+	if in.Body != nil {
+		return c.KeyFromBytes(in.Body, op, params...), nil
+	}
+
+	return c.Key(in.Path, op, params...)
+}
+
+// CopyFile copies the file at src to dest, replacing dest if it exists, for
+// example to put a cached image where it would have been written. If dest is
+// "-" the file is written to stdout (see CreateOutput).
+
+func CopyFile(src string, dest string) error {
+
+	in, err := os.Open(src)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := CreateOutput(dest)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+
+	close_err := out.Close()
+
+	if err == nil {
+		err = close_err
+	}
+
+	return err
+}
+
+func cacheKey(sum []byte, op string, params ...interface{}) string {
+
+	h := sha256.New()
+	h.Write(sum)
+
+	fmt.Fprintf(h, "\x00%s", op)
+
+	for _, p := range params {
+		fmt.Fprintf(h, "\x00%v", p)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,460 @@
+package util
+
+// http://www.color.org/specification/ICC1v43_2010-12.pdf
+// http://www.brucelindbloom.com/index.html?Eqn_RGB_XYZ_Matrix.html
+// http://www.brucelindbloom.com/index.html?Eqn_ChromAdapt.html
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// ICCProfile is a parsed "matrix/TRC" ICC profile: a tone reproduction curve
+// for each channel (or just one, for greyscale profiles) followed, for RGB
+// profiles, by a matrix that converts linear RGB to the (D50) XYZ profile
+// connection space. Profiles that use lookup tables instead are not supported.
+
+type ICCProfile struct {
+	ColourSpace string
+	curves      []iccCurve
+	matrix      [3][3]float64
+}
+
+type iccCurve func(float64) float64
+
+// linear (D50, Bradford adapted) XYZ to linear sRGB
+
+var xyz_to_srgb = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// the sRGB colorants, in D50, as they appear in an sRGB profile's rXYZ, gXYZ
+// and bXYZ tags (stored by column, like ICCProfile.matrix)
+
+var srgb_colorants = [3][3]float64{
+	{0.4361, 0.3851, 0.1431},
+	{0.2225, 0.7169, 0.0606},
+	{0.0139, 0.0971, 0.7141},
+}
+
+// ParseICCProfile parses the RGB or greyscale matrix/TRC profile in icc
+
+func ParseICCProfile(icc []byte) (*ICCProfile, error) {
+
+	if len(icc) < 132 || string(icc[36:40]) != "acsp" {
+		return nil, errors.New("Invalid ICC profile")
+	}
+
+	colour_space := string(icc[16:20])
+	pcs := string(icc[20:24])
+
+	if pcs != "XYZ " {
+		return nil, errors.New("Unsupported ICC profile connection space")
+	}
+
+	tags := make(map[string][]byte)
+
+	count := int(binary.BigEndian.Uint32(icc[128:132]))
+
+	for i := 0; i < count; i++ {
+
+		entry := 132 + (i * 12)
+
+		if entry+12 > len(icc) {
+			return nil, errors.New("Truncated ICC tag table")
+		}
+
+		sig := string(icc[entry : entry+4])
+		offset := int(binary.BigEndian.Uint32(icc[entry+4 : entry+8]))
+		size := int(binary.BigEndian.Uint32(icc[entry+8 : entry+12]))
+
+		if offset < 0 || size < 0 || offset+size > len(icc) {
+			return nil, errors.New("Truncated ICC tag")
+		}
+
+		tags[sig] = icc[offset : offset+size]
+	}
+
+	p := ICCProfile{
+		ColourSpace: colour_space,
+	}
+
+	switch colour_space {
+	case "RGB ":
+
+		for _, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+
+			c, err := parseICCCurve(tags[sig])
+
+			if err != nil {
+				return nil, err
+			}
+
+			p.curves = append(p.curves, c)
+		}
+
+		for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+
+			xyz, err := parseICCXYZ(tags[sig])
+
+			if err != nil {
+				return nil, err
+			}
+
+			for j := 0; j < 3; j++ {
+				p.matrix[j][i] = xyz[j]
+			}
+		}
+
+	case "GRAY":
+
+		c, err := parseICCCurve(tags["kTRC"])
+
+		if err != nil {
+			return nil, err
+		}
+
+		p.curves = append(p.curves, c)
+
+	default:
+		return nil, errors.New("Unsupported ICC colour space")
+	}
+
+	return &p, nil
+}
+
+// IsSRGB returns true if p is (near enough) sRGB, in which case there's no
+// need to convert anything
+
+func (p *ICCProfile) IsSRGB() bool {
+
+	for _, c := range p.curves {
+
+		for _, v := range []float64{0.02, 0.1, 0.25, 0.5, 0.75, 0.9} {
+
+			if math.Abs(c(v)-srgbToLinear(v)) > 0.005 {
+				return false
+			}
+		}
+	}
+
+	if p.ColourSpace == "GRAY" {
+		return true
+	}
+
+	for i := 0; i < 3; i++ {
+
+		for j := 0; j < 3; j++ {
+
+			if math.Abs(p.matrix[i][j]-srgb_colorants[i][j]) > 0.002 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Convert returns a copy of im, which is assumed to be in the colour space
+// described by p, converted to sRGB. Greyscale profiles are only applied to
+// the first (red) channel of each pixel.
+
+func (p *ICCProfile) Convert(im image.Image) image.Image {
+
+	dims := im.Bounds()
+
+	src := image.NewNRGBA(image.Rect(0, 0, dims.Dx(), dims.Dy()))
+	draw.Draw(src, src.Bounds(), im, dims.Min, draw.Src)
+
+	// decoding the source is just a lookup table, one per curve
+
+	luts := make([][256]float64, len(p.curves))
+
+	for i, c := range p.curves {
+
+		for v := 0; v < 256; v++ {
+			luts[i][v] = c(float64(v) / 255.0)
+		}
+	}
+
+	// as is encoding sRGB, at a higher precision than 8 bits since linear
+	// values near 0 change very quickly once they're encoded
+
+	const steps = 4096
+
+	var encode [steps + 1]uint8
+
+	for i := 0; i <= steps; i++ {
+		encode[i] = uint8(math.Round(linearToSRGB(float64(i)/steps) * 255.0))
+	}
+
+	to_srgb := func(v float64) uint8 {
+		v = math.Max(0.0, math.Min(1.0, v))
+		return encode[int(math.Round(v*steps))]
+	}
+
+	// device RGB to XYZ to sRGB is a single matrix
+
+	var m [3][3]float64
+
+	for i := 0; i < 3; i++ {
+
+		for j := 0; j < 3; j++ {
+
+			for k := 0; k < 3; k++ {
+				m[i][j] += xyz_to_srgb[i][k] * p.matrix[k][j]
+			}
+		}
+	}
+
+	for i := 0; i < len(src.Pix); i += 4 {
+
+		pix := src.Pix[i : i+4]
+
+		if p.ColourSpace == "GRAY" {
+
+			v := to_srgb(luts[0][pix[0]])
+
+			pix[0] = v
+			pix[1] = v
+			pix[2] = v
+			continue
+		}
+
+		r := luts[0][pix[0]]
+		g := luts[1][pix[1]]
+		b := luts[2][pix[2]]
+
+		pix[0] = to_srgb(m[0][0]*r + m[0][1]*g + m[0][2]*b)
+		pix[1] = to_srgb(m[1][0]*r + m[1][1]*g + m[1][2]*b)
+		pix[2] = to_srgb(m[2][0]*r + m[2][1]*g + m[2][2]*b)
+	}
+
+	return src
+}
+
+// ColourManage converts im to sRGB using the ICC profile embedded in body (the
+// encoded image im was decoded from). It returns im unchanged and false if
+// there is no profile, the profile is already sRGB, the profile doesn't match
+// the image or it isn't a kind of profile we know how to apply.
+
+func ColourManage(im image.Image, body []byte) (image.Image, bool, error) {
+
+	md, err := ReadMetadata(body)
+
+	if err != nil {
+		return im, false, err
+	}
+
+	p, ok := convertibleProfile(md.ICC, im)
+
+	if !ok {
+		return im, false, nil
+	}
+
+	return p.Convert(im), true, nil
+}
+
+// ResetColourProfile removes the ICC profile from md if it's one that
+// ColourManage converts from. This should be done whenever the pixels have
+// been converted to sRGB otherwise they'll be converted all over again when
+// they're displayed.
+
+func (md *Metadata) ResetColourProfile() {
+
+	if md.ICC == nil {
+		return
+	}
+
+	p, err := ParseICCProfile(md.ICC)
+
+	if err != nil || p.IsSRGB() {
+		return
+	}
+
+	md.ICC = nil
+}
+
+func convertibleProfile(icc []byte, im image.Image) (*ICCProfile, bool) {
+
+	if icc == nil {
+		return nil, false
+	}
+
+	p, err := ParseICCProfile(icc)
+
+	if err != nil || p.IsSRGB() {
+		return nil, false
+	}
+
+	// an RGB profile for a greyscale image (or vice versa) is broken
+
+	grey := false
+
+	switch im.(type) {
+	case *image.Gray, *image.Gray16:
+		grey = true
+	}
+
+	if grey != (p.ColourSpace == "GRAY") {
+		return nil, false
+	}
+
+	return p, true
+}
+
+func parseICCCurve(tag []byte) (iccCurve, error) {
+
+	if len(tag) < 12 {
+		return nil, errors.New("Missing or invalid ICC curve")
+	}
+
+	switch string(tag[0:4]) {
+	case "curv":
+
+		count := int(binary.BigEndian.Uint32(tag[8:12]))
+
+		if 12+(count*2) > len(tag) {
+			return nil, errors.New("Truncated ICC curve")
+		}
+
+		switch count {
+		case 0:
+			return func(v float64) float64 { return v }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:14])) / 256.0
+			return func(v float64) float64 { return math.Pow(v, gamma) }, nil
+		}
+
+		table := make([]float64, count)
+
+		for i := 0; i < count; i++ {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+(i*2):14+(i*2)])) / 65535.0
+		}
+
+		c := func(v float64) float64 {
+
+			pos := v * float64(count-1)
+			idx := int(math.Floor(pos))
+
+			if idx >= count-1 {
+				return table[count-1]
+			}
+
+			if idx < 0 {
+				return table[0]
+			}
+
+			f := pos - float64(idx)
+			return table[idx]*(1-f) + table[idx+1]*f
+		}
+
+		return c, nil
+
+	case "para":
+
+		fn := int(binary.BigEndian.Uint16(tag[8:10]))
+
+		counts := map[int]int{0: 1, 1: 3, 2: 4, 3: 5, 4: 7}
+
+		n, ok := counts[fn]
+
+		if !ok {
+			return nil, errors.New("Unsupported ICC parametric curve")
+		}
+
+		if 12+(n*4) > len(tag) {
+			return nil, errors.New("Truncated ICC parametric curve")
+		}
+
+		params := make([]float64, 7)
+
+		for i := 0; i < n; i++ {
+			params[i] = s15Fixed16(tag[12+(i*4) : 16+(i*4)])
+		}
+
+		g, a, b, c, d, e, f := params[0], params[1], params[2], params[3], params[4], params[5], params[6]
+
+		pow := func(v float64) float64 {
+			return math.Pow(math.Max(v, 0.0), g)
+		}
+
+		switch fn {
+		case 0:
+			return func(v float64) float64 { return pow(v) }, nil
+		case 1:
+			return func(v float64) float64 {
+				if a != 0 && v >= -b/a {
+					return pow(a*v + b)
+				}
+				return 0
+			}, nil
+		case 2:
+			return func(v float64) float64 {
+				if a != 0 && v >= -b/a {
+					return pow(a*v+b) + c
+				}
+				return c
+			}, nil
+		case 3:
+			return func(v float64) float64 {
+				if v >= d {
+					return pow(a*v + b)
+				}
+				return c * v
+			}, nil
+		default:
+			return func(v float64) float64 {
+				if v >= d {
+					return pow(a*v+b) + e
+				}
+				return c*v + f
+			}, nil
+		}
+
+	default:
+		return nil, errors.New("Unsupported ICC curve type")
+	}
+}
+
+func parseICCXYZ(tag []byte) ([3]float64, error) {
+
+	var xyz [3]float64
+
+	if len(tag) < 20 || string(tag[0:4]) != "XYZ " {
+		return xyz, errors.New("Missing or invalid ICC XYZ tag")
+	}
+
+	for i := 0; i < 3; i++ {
+		xyz[i] = s15Fixed16(tag[8+(i*4) : 12+(i*4)])
+	}
+
+	return xyz, nil
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536.0
+}
+
+func srgbToLinear(v float64) float64 {
+
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) float64 {
+
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+
+	return 1.055*math.Pow(v, 1.0/2.4) - 0.055
+}
@@ -0,0 +1,345 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"golang.org/x/image/tiff"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// iccTag is a tag in a test profile: its signature and contents
+
+type iccTag struct {
+	sig  string
+	body []byte
+}
+
+// testICCProfile returns a minimal ICC profile with the colour space, profile
+// connection space and tags passed
+
+func testICCProfile(colour_space string, pcs string, tags ...iccTag) []byte {
+
+	header := make([]byte, 128)
+	copy(header[16:20], colour_space)
+	copy(header[20:24], pcs)
+	copy(header[36:40], "acsp")
+
+	table := make([]byte, 4+(12*len(tags)))
+	binary.BigEndian.PutUint32(table[0:4], uint32(len(tags)))
+
+	offset := len(header) + len(table)
+	data := make([]byte, 0)
+
+	for i, tag := range tags {
+
+		entry := table[4+(i*12) : 16+(i*12)]
+
+		copy(entry[0:4], tag.sig)
+		binary.BigEndian.PutUint32(entry[4:8], uint32(offset+len(data)))
+		binary.BigEndian.PutUint32(entry[8:12], uint32(len(tag.body)))
+
+		data = append(data, tag.body...)
+	}
+
+	icc := append(header, table...)
+	return append(icc, data...)
+}
+
+func testGammaCurve(gamma float64) []byte {
+
+	tag := make([]byte, 14)
+	copy(tag[0:4], "curv")
+	binary.BigEndian.PutUint32(tag[8:12], 1)
+	binary.BigEndian.PutUint16(tag[12:14], uint16(math.Round(gamma*256.0)))
+
+	return tag
+}
+
+// testSRGBCurve returns the sRGB tone curve as a (type 3) parametric curve
+
+func testSRGBCurve() []byte {
+
+	params := []float64{2.4, 1.0 / 1.055, 0.055 / 1.055, 1.0 / 12.92, 0.04045}
+
+	tag := make([]byte, 12+(4*len(params)))
+	copy(tag[0:4], "para")
+	binary.BigEndian.PutUint16(tag[8:10], 3)
+
+	for i, p := range params {
+		binary.BigEndian.PutUint32(tag[12+(i*4):16+(i*4)], uint32(int32(math.Round(p*65536.0))))
+	}
+
+	return tag
+}
+
+func testXYZ(x float64, y float64, z float64) []byte {
+
+	tag := make([]byte, 20)
+	copy(tag[0:4], "XYZ ")
+
+	for i, v := range []float64{x, y, z} {
+		binary.BigEndian.PutUint32(tag[8+(i*4):12+(i*4)], uint32(int32(math.Round(v*65536.0))))
+	}
+
+	return tag
+}
+
+// testRGBProfile returns an RGB profile with the same curve for every channel
+// and the colorants (in D50) in m, by column
+
+func testRGBProfile(curve []byte, m [3][3]float64) []byte {
+
+	return testICCProfile("RGB ", "XYZ ",
+		iccTag{"rTRC", curve},
+		iccTag{"gTRC", curve},
+		iccTag{"bTRC", curve},
+		iccTag{"rXYZ", testXYZ(m[0][0], m[1][0], m[2][0])},
+		iccTag{"gXYZ", testXYZ(m[0][1], m[1][1], m[2][1])},
+		iccTag{"bXYZ", testXYZ(m[0][2], m[1][2], m[2][2])},
+	)
+}
+
+// the Adobe RGB (1998) colorants, in D50
+
+var adobe_colorants = [3][3]float64{
+	{0.6097, 0.2053, 0.1492},
+	{0.3111, 0.6257, 0.0632},
+	{0.0195, 0.0609, 0.7446},
+}
+
+func TestParseICCProfile(t *testing.T) {
+
+	srgb := testRGBProfile(testSRGBCurve(), srgb_colorants)
+
+	tests := []struct {
+		name         string
+		icc          []byte
+		ok           bool
+		colour_space string
+		is_srgb      bool
+	}{
+		{"srgb", srgb, true, "RGB ", true},
+		{"adobe rgb", testRGBProfile(testGammaCurve(2.2), adobe_colorants), true, "RGB ", false},
+		{"srgb colorants, gamma 1.8", testRGBProfile(testGammaCurve(1.8), srgb_colorants), true, "RGB ", false},
+		{"srgb curves, adobe colorants", testRGBProfile(testSRGBCurve(), adobe_colorants), true, "RGB ", false},
+		{"grey srgb", testICCProfile("GRAY", "XYZ ", iccTag{"kTRC", testSRGBCurve()}), true, "GRAY", true},
+		{"grey gamma 2.2", testICCProfile("GRAY", "XYZ ", iccTag{"kTRC", testGammaCurve(2.2)}), true, "GRAY", false},
+		{"too short", srgb[0:100], false, "", false},
+		{"not a profile", append([]byte("nope"), srgb[4:]...)[0:36], false, "", false},
+		{"lab", testICCProfile("RGB ", "Lab "), false, "", false},
+		{"cmyk", testICCProfile("CMYK", "XYZ "), false, "", false},
+		{"missing curves", testICCProfile("RGB ", "XYZ "), false, "", false},
+		{"missing colorants", testICCProfile("RGB ", "XYZ ", iccTag{"rTRC", testGammaCurve(2.2)}, iccTag{"gTRC", testGammaCurve(2.2)}, iccTag{"bTRC", testGammaCurve(2.2)}), false, "", false},
+		{"truncated", srgb[0 : len(srgb)-10], false, "", false},
+	}
+
+	for _, test := range tests {
+
+		p, err := ParseICCProfile(test.icc)
+
+		if !test.ok {
+
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if p.ColourSpace != test.colour_space {
+			t.Errorf("%s: expected colour space '%s', got '%s'", test.name, test.colour_space, p.ColourSpace)
+		}
+
+		if p.IsSRGB() != test.is_srgb {
+			t.Errorf("%s: expected IsSRGB to be %t", test.name, test.is_srgb)
+		}
+	}
+}
+
+func TestICCProfileConvert(t *testing.T) {
+
+	linear := testRGBProfile(testGammaCurve(1.0), srgb_colorants)
+	adobe := testRGBProfile(testGammaCurve(2.2), adobe_colorants)
+	grey := testICCProfile("GRAY", "XYZ ", iccTag{"kTRC", testGammaCurve(1.0)})
+
+	tests := []struct {
+		name     string
+		icc      []byte
+		in       color.NRGBA
+		expected color.NRGBA
+	}{
+		{"linear black", linear, color.NRGBA{0, 0, 0, 255}, color.NRGBA{0, 0, 0, 255}},
+		{"linear white", linear, color.NRGBA{255, 255, 255, 255}, color.NRGBA{255, 255, 255, 255}},
+		{"linear grey", linear, color.NRGBA{128, 128, 128, 255}, color.NRGBA{188, 188, 188, 255}},
+		{"linear keeps alpha", linear, color.NRGBA{128, 128, 128, 100}, color.NRGBA{188, 188, 188, 100}},
+		{"adobe white", adobe, color.NRGBA{255, 255, 255, 255}, color.NRGBA{255, 255, 255, 255}},
+		{"adobe red", adobe, color.NRGBA{255, 0, 0, 255}, color.NRGBA{255, 0, 0, 255}},
+		{"adobe green", adobe, color.NRGBA{0, 255, 0, 255}, color.NRGBA{0, 255, 0, 255}},
+		{"adobe grey", adobe, color.NRGBA{128, 128, 128, 255}, color.NRGBA{128, 128, 128, 255}},
+		{"adobe dull green", adobe, color.NRGBA{100, 150, 100, 255}, color.NRGBA{66, 151, 97, 255}},
+		{"grey", grey, color.NRGBA{128, 0, 0, 255}, color.NRGBA{188, 188, 188, 255}},
+	}
+
+	for _, test := range tests {
+
+		p, err := ParseICCProfile(test.icc)
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		src := image.NewNRGBA(image.Rect(5, 5, 7, 7))
+
+		for y := 5; y < 7; y++ {
+
+			for x := 5; x < 7; x++ {
+				src.Set(x, y, test.in)
+			}
+		}
+
+		im := p.Convert(src)
+
+		if im.Bounds() != image.Rect(0, 0, 2, 2) {
+			t.Errorf("%s: expected bounds (0,0)-(2,2), got %v", test.name, im.Bounds())
+			continue
+		}
+
+		got := im.(*image.NRGBA).NRGBAAt(1, 1)
+
+		if !closeColour(got, test.expected, 2) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestColourManageTIFF(t *testing.T) {
+
+	adobe := testRGBProfile(testGammaCurve(2.2), adobe_colorants)
+
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+
+	for y := 0; y < 2; y++ {
+
+		for x := 0; x < 2; x++ {
+			src.Set(x, y, color.NRGBA{100, 150, 100, 255})
+		}
+	}
+
+	var buf bytes.Buffer
+
+	err := tiff.Encode(&buf, src, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := buf.Bytes()
+	tagged := testTIFFWithICC(t, plain, adobe)
+
+	tests := []struct {
+		name     string
+		body     []byte
+		ok       bool
+		expected color.NRGBA
+	}{
+		{"no profile", plain, false, color.NRGBA{100, 150, 100, 255}},
+		{"adobe", tagged, true, color.NRGBA{66, 151, 97, 255}},
+	}
+
+	for _, test := range tests {
+
+		md, err := ReadMetadata(test.body)
+
+		if err != nil {
+			t.Errorf("%s: unexpected error reading metadata %v", test.name, err)
+			continue
+		}
+
+		if (md.ICC != nil) != test.ok {
+			t.Errorf("%s: expected a profile to be %t", test.name, test.ok)
+		}
+
+		im, _, err := DecodeImageFromReaderWithOptions(bytes.NewReader(test.body), NewDefaultDecodeOptions())
+
+		if err != nil {
+			t.Errorf("%s: unexpected error decoding %v", test.name, err)
+			continue
+		}
+
+		got := color.NRGBAModel.Convert(im.At(1, 1)).(color.NRGBA)
+
+		if !closeColour(got, test.expected, 2) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+// testTIFFWithICC returns body, a little-endian TIFF image, with icc added as
+// its InterColorProfile by appending a copy of the first IFD, with the extra
+// tag, and pointing the header at it
+
+func testTIFFWithICC(t *testing.T, body []byte, icc []byte) []byte {
+
+	if string(body[0:2]) != "II" {
+		t.Fatal("Expected a little-endian TIFF image")
+	}
+
+	order := binary.LittleEndian
+
+	ifd := int(order.Uint32(body[4:8]))
+	count := int(order.Uint16(body[ifd : ifd+2]))
+	entries := body[ifd+2 : ifd+2+count*12]
+
+	var out bytes.Buffer
+	out.Write(body)
+
+	if out.Len()%2 != 0 {
+		out.WriteByte(0)
+	}
+
+	icc_offset := out.Len()
+	out.Write(icc)
+
+	if out.Len()%2 != 0 {
+		out.WriteByte(0)
+	}
+
+	new_ifd := out.Len()
+
+	binary.Write(&out, order, uint16(count+1))
+	out.Write(entries)
+
+	// tag, type (UNDEFINED), count, offset and then the next IFD, of
+	// which there isn't one
+
+	binary.Write(&out, order, []uint16{tiff_icc_tag, 7})
+	binary.Write(&out, order, []uint32{uint32(len(icc)), uint32(icc_offset), 0})
+
+	tagged := out.Bytes()
+	order.PutUint32(tagged[4:8], uint32(new_ifd))
+
+	return tagged
+}
+
+// closeColour returns true if every channel of a and b is within tolerance
+
+func closeColour(a color.NRGBA, b color.NRGBA, tolerance int) bool {
+
+	for _, d := range []int{int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B), int(a.A) - int(b.A)} {
+
+		if d < -tolerance || d > tolerance {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,271 @@
+package util
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"path/filepath"
+)
+
+// CommandOptions are the options shared by the tools that turn images in to
+// other images (convert, crop, deskew, halftone and split): how images are
+// found, decoded, encoded and written. RegisterFlags adds a flag for each of
+// them, with the current values as defaults, and Apply then copies the parsed
+// flags back in to Decode, Encode, Output and Batch.
+//
+// Format is the format new images are written in, or "" for the format of
+// the source image, and Metadata is the metadata policy (see Metadata.Filter).
+// File, if not empty, is a single file ("-" for stdout) to write to instead of
+// using Output. Stdin is true if the image should be read from stdin, which
+// is what passing "-" instead of any paths means. SkipOutputs is whether the
+// images written by an earlier run are left out (see SkipOutputsFor).
+
+type CommandOptions struct {
+	Decode      DecodeOptions
+	Encode      EncodeOptions
+	Output      OutputOptions
+	Batch       BatchOptions
+	Format      string
+	Metadata    string
+	File        string
+	Stdin       bool
+	CacheDir    string
+	CacheSize   int64
+	SkipOutputs bool
+	max_memory  int64
+	extensions  string
+	has_file    bool
+}
+
+func NewDefaultCommandOptions() *CommandOptions {
+
+	opts := CommandOptions{
+		Decode:    NewDefaultDecodeOptions(),
+		Encode:    NewDefaultEncodeOptions(),
+		Output:    NewDefaultOutputOptions(),
+		Batch:     NewDefaultBatchOptions(),
+		Metadata:  "keep",
+		CacheSize: 1024,
+	}
+
+	return &opts
+}
+
+// RegisterFlags adds the flags for decoding, encoding, finding and writing
+// images to fs
+
+func (o *CommandOptions) RegisterFlags(fs *flag.FlagSet) {
+
+	o.max_memory = o.Decode.MaxMemory / (1024 * 1024)
+
+	fs.BoolVar(&o.Decode.AutoOrient, "auto-orient", o.Decode.AutoOrient, "Rotate and flip images according to their EXIF orientation.")
+	fs.BoolVar(&o.Decode.ColourManage, "colour-manage", o.Decode.ColourManage, "Convert images with an embedded ICC colour profile to sRGB.")
+	fs.IntVar(&o.Decode.MaxDimension, "max-dimension", o.Decode.MaxDimension, "The largest width or height, in pixels, of an image to decode (0 for no limit).")
+	fs.Int64Var(&o.Decode.MaxPixels, "max-pixels", o.Decode.MaxPixels, "The largest number of pixels in an image to decode (0 for no limit).")
+	fs.Int64Var(&o.max_memory, "max-memory", o.max_memory, "The most memory, in MB, that decoding an image may use (0 for no limit).")
+	fs.StringVar(&o.Metadata, "metadata", o.Metadata, "The metadata to copy to new images: keep, copyright or strip.")
+
+	fs.StringVar(&o.Format, "format", o.Format, "The format to write new images in: jpeg, png, gif, tiff or bmp. The default is the format of the source image.")
+	fs.StringVar(&o.Output.Dir, "output-dir", o.Output.Dir, "Write new images to this directory rather than next to the images they came from.")
+	fs.StringVar(&o.Output.Template, "output-template", o.Output.Template, "The filename for new images. Variables are {dir}, {stem}, {op}, {w}, {h}, {ext} and {format}.")
+	fs.BoolVar(&o.Output.Mirror, "mirror", o.Output.Mirror, "Recreate the directories (or archives) images were found in below -output-dir.")
+	fs.StringVar(&o.Output.Overwrite, "overwrite", o.Output.Overwrite, "What to do when a new image already exists: replace, skip or error.")
+
+	fs.IntVar(&o.Batch.Workers, "workers", o.Batch.Workers, "The number of images to process at the same time.")
+	fs.BoolVar(&o.Batch.ContinueOnError, "continue-on-error", o.Batch.ContinueOnError, "Carry on when an image can't be processed, listing all the failures at the end.")
+	fs.BoolVar(&o.Batch.Recursive, "recursive", o.Batch.Recursive, "Search directories all the way down rather than only the images directly inside them.")
+	fs.StringVar(&o.Batch.Symlinks, "symlinks", o.Batch.Symlinks, "What to do with symbolic links in directories: files (follow links to files), follow (follow all links) or skip.")
+	fs.StringVar(&o.extensions, "extensions", o.extensions, "A comma separated list of the file extensions to look for. The default is every image extension.")
+
+	fs.Var((*RegexpFlag)(&o.Batch.Include), "include", "Only process images whose path matches this regular expression. May be passed more than once.")
+	fs.Var((*RegexpFlag)(&o.Batch.Exclude), "exclude", "Don't process images whose path matches this regular expression. May be passed more than once.")
+	fs.Var((*MultiFlag)(&o.Batch.IncludeGlob), "include-glob", "Only process images whose filename matches this glob pattern. May be passed more than once.")
+	fs.Var((*MultiFlag)(&o.Batch.ExcludeGlob), "exclude-glob", "Don't process images whose filename matches this glob pattern. May be passed more than once.")
+
+	fs.IntVar(&o.Encode.JPEGQuality, "jpeg-quality", o.Encode.JPEGQuality, "The quality of JPEG images, from 1 to 100.")
+	fs.StringVar(&o.Encode.PNGCompression, "png-compression", o.Encode.PNGCompression, "The compression of PNG images: default, none, fast or best.")
+	fs.IntVar(&o.Encode.GIFColours, "gif-colours", o.Encode.GIFColours, "The number of colours in GIF images, from 2 to 256.")
+	fs.StringVar(&o.Encode.GIFQuantizer, "gif-quantizer", o.Encode.GIFQuantizer, "How the colours in GIF images are chosen: plan9, websafe or median-cut.")
+	fs.StringVar(&o.Encode.GIFDitherer, "gif-ditherer", o.Encode.GIFDitherer, "How GIF images are dithered: floyd-steinberg or none.")
+}
+
+// RegisterFileFlag adds the -output flag, for tools that can write a single
+// image to a file or stdout
+
+func (o *CommandOptions) RegisterFileFlag(fs *flag.FlagSet) {
+
+	o.has_file = true
+	fs.StringVar(&o.File, "output", o.File, "Write a single image to this file, or to stdout if it is \"-\", rather than according to -output-dir and -output-template.")
+}
+
+// RegisterCacheFlags adds the -cache-dir and -cache-size flags
+
+func (o *CommandOptions) RegisterCacheFlags(fs *flag.FlagSet) {
+
+	fs.StringVar(&o.CacheDir, "cache-dir", o.CacheDir, "Cache new images in this directory, so that doing the same thing twice only does the work once.")
+	fs.Int64Var(&o.CacheSize, "cache-size", o.CacheSize, "The maximum size of the cache, in MB.")
+}
+
+// RegisterSkipOutputsFlag adds the -skip-outputs flag, for tools whose output
+// can be told apart from other images (see SkipOutputsFor)
+
+func (o *CommandOptions) RegisterSkipOutputsFlag(fs *flag.FlagSet) {
+
+	fs.BoolVar(&o.SkipOutputs, "skip-outputs", o.SkipOutputs, "Don't process images in directories whose filenames match -output-template, so that running the same thing twice doesn't process its own output.")
+}
+
+// Apply copies the parsed flags in to the options and validates them. args
+// are the paths passed on the command line. For tools with an -output flag
+// (see RegisterFileFlag) "-" means read a single image from stdin and write
+// it to stdout, unless there's a File. Otherwise the format of a File is its
+// extension, unless there's a Format.
+
+func (o *CommandOptions) Apply(args []string) error {
+
+	o.Decode.MaxMemory = o.max_memory * 1024 * 1024
+	o.Batch.Extensions = ParseExtensions(o.extensions)
+
+	err := o.Output.Validate()
+
+	if err != nil {
+		return err
+	}
+
+	err = o.Batch.Validate()
+
+	if err != nil {
+		return err
+	}
+
+	if o.Format != "" {
+
+		f, err := NormalizeFormat(o.Format)
+
+		if err != nil {
+			return err
+		}
+
+		o.Format = f
+	}
+
+	for _, path := range args {
+
+		if path == "-" && o.has_file {
+			o.Stdin = true
+		}
+	}
+
+	if o.Stdin && len(args) > 1 {
+		return errors.New("Reading from stdin can not be combined with other images")
+	}
+
+	if o.Stdin && o.File == "" {
+		o.File = "-"
+	}
+
+	if o.File != "" && o.File != "-" && o.Format == "" {
+
+		ext_format := ExtensionFormat(filepath.Ext(o.File))
+
+		if ext_format != "" {
+
+			f, err := NormalizeFormat(ext_format)
+
+			if err != nil {
+				return err
+			}
+
+			o.Format = f
+		}
+	}
+
+	return nil
+}
+
+// OutputFormat returns the format to write an image decoded as format in
+
+func (o *CommandOptions) OutputFormat(format string) string {
+
+	if o.Format != "" {
+		return o.Format
+	}
+
+	return OutputFormat(format)
+}
+
+// FileEncodeOptions returns Encode with the metadata from in, according to the
+// metadata policy. The orientation and colour profile are reset if the pixels
+// were rotated and converted to sRGB when in was decoded.
+
+func (o *CommandOptions) FileEncodeOptions(in *Input) (EncodeOptions, error) {
+
+	md, err := in.ReadMetadataWithPolicy(o.Metadata)
+
+	if err != nil {
+		return o.Encode, err
+	}
+
+	if o.Decode.AutoOrient {
+		md.ResetOrientation()
+	}
+
+	if o.Decode.ColourManage {
+		md.ResetColourProfile()
+	}
+
+	file_opts := o.Encode
+	file_opts.Metadata = md
+
+	return file_opts, nil
+}
+
+// SkipOutputsFor leaves out the images written for op, a regular expression,
+// when directories are searched if SkipOutputs is true. It does nothing if
+// Output's template doesn't say which images those are (see
+// OutputOptions.Pattern).
+
+func (o *CommandOptions) SkipOutputsFor(op string) {
+
+	if !o.SkipOutputs {
+		return
+	}
+
+	re_output := o.Output.Pattern(op)
+
+	if re_output == nil {
+		log.Println("Warning: -skip-outputs does nothing unless {op} is in the -output-template filename")
+		return
+	}
+
+	o.Batch.Outputs = append(o.Batch.Outputs, re_output)
+}
+
+// NewCache returns the cache in CacheDir, or nil if there isn't one
+
+func (o *CommandOptions) NewCache() (*Cache, error) {
+
+	if o.CacheDir == "" {
+		return nil, nil
+	}
+
+	return NewCache(o.CacheDir, o.CacheSize*1024*1024)
+}
+
+// RunBatch calls cb for every image in paths, according to Batch (see
+// RunBatch). Images in archives are copied to a temporary directory, named
+// after prefix, which is removed once we're done (or interrupted).
+
+func (o *CommandOptions) RunBatch(prefix string, paths []string, cb func(*SourceFile) error) error {
+
+	temp, err := NewTempStore(prefix)
+
+	if err != nil {
+		return err
+	}
+
+	defer temp.Close()
+
+	temp.CloseOnSignal()
+
+	return RunBatch(paths, temp, o.Batch, cb)
+}
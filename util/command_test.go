@@ -0,0 +1,164 @@
+package util
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCommandOptionsApply(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		args     []string
+		has_file bool
+		stdin    bool
+		file     string
+		format   string
+		ok       bool
+	}{
+		{"paths", []string{"a.jpg", "photos"}, true, false, "", "", true},
+		{"format", []string{"-format", "JPG", "a.png"}, true, false, "", "jpeg", true},
+		{"bad format", []string{"-format", "psd", "a.png"}, true, false, "", "", false},
+		{"stdin", []string{"-"}, true, true, "-", "", true},
+		{"stdin to file", []string{"-output", "b.png", "-"}, true, true, "b.png", "png", true},
+		{"stdin without -output", []string{"-"}, false, false, "", "", true},
+		{"stdin and paths", []string{"-", "a.jpg"}, true, false, "", "", false},
+		{"output extension", []string{"-output", "b.TIF", "a.jpg"}, true, false, "b.TIF", "tiff", true},
+		{"output and format", []string{"-output", "b.png", "-format", "gif", "a.jpg"}, true, false, "b.png", "gif", true},
+		{"output without extension", []string{"-output", "b", "a.jpg"}, true, false, "b", "", true},
+		{"bad template", []string{"-output-template", "{stem}-{nope}.{ext}", "a.jpg"}, true, false, "", "", false},
+		{"bad overwrite", []string{"-overwrite", "sometimes", "a.jpg"}, true, false, "", "", false},
+		{"bad symlinks", []string{"-symlinks", "sometimes", "a.jpg"}, true, false, "", "", false},
+	}
+
+	for _, test := range tests {
+
+		fs := flag.NewFlagSet(test.name, flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+
+		opts := NewDefaultCommandOptions()
+		opts.RegisterFlags(fs)
+
+		if test.has_file {
+			opts.RegisterFileFlag(fs)
+		}
+
+		err := fs.Parse(test.args)
+
+		if err != nil {
+			t.Errorf("%s: unexpected error parsing flags %v", test.name, err)
+			continue
+		}
+
+		err = opts.Apply(fs.Args())
+
+		if !test.ok {
+
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if opts.Stdin != test.stdin || opts.File != test.file || opts.Format != test.format {
+			t.Errorf("%s: expected %t, '%s', '%s', got %t, '%s', '%s'", test.name, test.stdin, test.file, test.format, opts.Stdin, opts.File, opts.Format)
+		}
+	}
+}
+
+func TestCommandOptionsFlags(t *testing.T) {
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+
+	opts := NewDefaultCommandOptions()
+	opts.RegisterFlags(fs)
+	opts.RegisterCacheFlags(fs)
+
+	args := []string{
+		"-max-memory", "16",
+		"-extensions", "jpg, png",
+		"-include", "2016",
+		"-include", "2017",
+		"-exclude-glob", "*-thumb.*",
+		"-workers", "4",
+		"-jpeg-quality", "75",
+		"-cache-dir", "/tmp/cache",
+		"-auto-orient=false",
+	}
+
+	err := fs.Parse(args)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = opts.Apply(fs.Args())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.Decode.MaxMemory != 16*1024*1024 {
+		t.Errorf("Expected -max-memory to be converted to bytes, got %d", opts.Decode.MaxMemory)
+	}
+
+	if len(opts.Batch.Extensions) != 2 || opts.Batch.Extensions[1] != "png" {
+		t.Errorf("Expected two extensions, got %v", opts.Batch.Extensions)
+	}
+
+	if len(opts.Batch.Include) != 2 || len(opts.Batch.ExcludeGlob) != 1 {
+		t.Errorf("Expected two includes and one exclude glob, got %v and %v", opts.Batch.Include, opts.Batch.ExcludeGlob)
+	}
+
+	if opts.Batch.Workers != 4 || opts.Encode.JPEGQuality != 75 || opts.CacheDir != "/tmp/cache" || opts.Decode.AutoOrient {
+		t.Errorf("Flags were not copied in to the options: %+v", opts)
+	}
+
+	// the defaults are whatever the options were before the flags were
+	// registered
+
+	defaults := NewDefaultCommandOptions()
+	defaults.Output.Template = "{stem}.{ext}"
+
+	fs = flag.NewFlagSet("defaults", flag.ContinueOnError)
+	defaults.RegisterFlags(fs)
+
+	if fs.Lookup("output-template").DefValue != "{stem}.{ext}" || fs.Lookup("max-memory").DefValue != "2048" {
+		t.Errorf("Expected the flag defaults to come from the options")
+	}
+}
+
+func TestCommandOptionsSkipOutputsFor(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		skip     bool
+		template string
+		outputs  int
+	}{
+		{"off", false, "{stem}-{op}.{ext}", 0},
+		{"on", true, "{stem}-{op}.{ext}", 1},
+		{"no op", true, "{stem}.{ext}", 0},
+	}
+
+	for _, test := range tests {
+
+		opts := NewDefaultCommandOptions()
+		opts.SkipOutputs = test.skip
+		opts.Output.Template = test.template
+
+		opts.SkipOutputsFor("crop")
+
+		if len(opts.Batch.Outputs) != test.outputs {
+			t.Errorf("%s: expected %d output patterns, got %d", test.name, test.outputs, len(opts.Batch.Outputs))
+		}
+	}
+}
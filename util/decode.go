@@ -2,19 +2,70 @@ package util
 
 import (
 	"bufio"
+	"bytes"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 	"io"
+	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 )
 
 // https://golang.org/src/image/decode_test.go
 
+// in addition to the standard library's GIF, JPEG and PNG decoders we also
+// register BMP, TIFF and WebP decoders (from golang.org/x/image) so anything
+// that uses DecodeImage gets them for free
+
+// ColourManage converts images with an embedded (matrix/TRC) ICC profile to
+// sRGB; see ColourManage for details
+
+// MaxDimension, MaxPixels and MaxMemory (in bytes, approximately) are checked
+// before an image is decoded, using image.DecodeConfig, so that huge images
+// (or decompression bombs) are rejected with a DecodeLimitError rather than
+// exhausting memory. A limit of 0 means no limit.
+
+// Images are always decoded according to their contents but when an image is
+// decoded from a file whose extension says otherwise (a PNG called "foo.jpg")
+// a warning is logged or, if StrictExtension is true, a MismatchError is
+// returned.
+
+type DecodeOptions struct {
+	AutoOrient      bool
+	ColourManage    bool
+	MaxDimension    int
+	MaxPixels       int64
+	MaxMemory       int64
+	StrictExtension bool
+}
+
+func NewDefaultDecodeOptions() DecodeOptions {
+
+	opts := DecodeOptions{
+		AutoOrient:   true,
+		ColourManage: true,
+		MaxDimension: 50000,
+		MaxPixels:    200 * 1000 * 1000,
+		MaxMemory:    2 * 1024 * 1024 * 1024,
+	}
+
+	return opts
+}
+
 func DecodeImage(path string) (image.Image, string, error) {
 
+	opts := DecodeOptions{}
+	return DecodeImageWithOptions(path, opts)
+}
+
+func DecodeImageWithOptions(path string, opts DecodeOptions) (image.Image, string, error) {
+
 	abs_path, err := filepath.Abs(path)
 
 	if err != nil {
@@ -29,10 +80,132 @@ func DecodeImage(path string) (image.Image, string, error) {
 
 	defer fh.Close()
 
-	return DecodeImageFromReader(fh)
+	br := bufio.NewReader(fh)
+
+	err = checkExtension(abs_path, br, opts)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return DecodeImageFromReaderWithOptions(br, opts)
+}
+
+// DecodeConfig returns the dimensions and format of the image at path without
+// decoding the whole thing
+
+func DecodeConfig(path string) (image.Config, string, error) {
+
+	fh, err := os.Open(path)
+
+	if err != nil {
+		return image.Config{}, "", err
+	}
+
+	defer fh.Close()
+
+	cfg, format, err := image.DecodeConfig(bufio.NewReader(fh))
+
+	if err != nil {
+		return image.Config{}, "", decodeError(err)
+	}
+
+	return cfg, format, nil
 }
 
 func DecodeImageFromReader(fh io.Reader) (image.Image, string, error) {
 
-	return image.Decode(bufio.NewReader(fh))
+	im, format, err := image.Decode(bufio.NewReader(fh))
+
+	if err != nil {
+		return nil, "", decodeError(err)
+	}
+
+	return im, format, nil
+}
+
+func DecodeImageFromReaderWithOptions(fh io.Reader, opts DecodeOptions) (image.Image, string, error) {
+
+	if !opts.AutoOrient && !opts.ColourManage && !hasDecodeLimits(opts) {
+		return DecodeImageFromReader(fh)
+	}
+
+	body, err := ioutil.ReadAll(fh)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if hasDecodeLimits(opts) {
+
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(body))
+
+		if err != nil {
+			return nil, "", decodeError(err)
+		}
+
+		err = CheckDecodeLimits(cfg, opts)
+
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	im, format, err := image.Decode(bytes.NewReader(body))
+
+	if err != nil {
+		return nil, "", decodeError(err)
+	}
+
+	// a broken ICC profile or EXIF block is not a reason to throw away
+	// an otherwise perfectly good image but one that is trying to inflate
+	// to something enormous is
+
+	if opts.ColourManage {
+
+		managed, ok, err := ColourManage(im, body)
+
+		if IsDecodeLimitError(err) {
+			return nil, "", err
+		}
+
+		if err == nil && ok {
+			im = managed
+		}
+	}
+
+	if opts.AutoOrient {
+
+		orientation, err := Orientation(body)
+
+		if err == nil {
+			im = Orient(im, orientation)
+		}
+	}
+
+	return im, format, nil
+}
+
+// checkExtension sniffs the first few bytes of br, without consuming them, and
+// compares them with the extension of path
+
+func checkExtension(path string, br *bufio.Reader, opts DecodeOptions) error {
+
+	// a short read just means a short (probably broken) file, which is for
+	// the decoder to complain about
+
+	head, _ := br.Peek(sniff_length)
+
+	err := CheckExtension(path, head)
+
+	if err == nil {
+		return nil
+	}
+
+	if opts.StrictExtension {
+		return err
+	}
+
+	log.Printf("Warning: %v\n", err)
+	return nil
 }
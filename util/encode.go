@@ -1,9 +1,13 @@
 package util
 
 import (
+	"bytes"
 	"errors"
-	"fmt"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
 	"image"
+	"image/color/palette"
+	"image/draw"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
@@ -12,9 +16,51 @@ import (
 	"os"
 )
 
+// note that there is no option for JPEG chroma subsampling because the
+// standard library encoder always uses 4:2:0 for colour images and there
+// is no way to change that short of writing our own encoder...
+
+// Metadata, if not nil, is added to JPEG and PNG images (see WriteMetadata)
+
+type EncodeOptions struct {
+	JPEGQuality    int
+	PNGCompression string
+	GIFColours     int
+	GIFQuantizer   string
+	GIFDitherer    string
+	Metadata       *Metadata
+}
+
+func NewDefaultEncodeOptions() EncodeOptions {
+
+	opts := EncodeOptions{
+		JPEGQuality:    90,
+		PNGCompression: "default",
+		GIFColours:     256,
+		GIFQuantizer:   "plan9",
+		GIFDitherer:    "floyd-steinberg",
+	}
+
+	return opts
+}
+
 func EncodeTempImage(im image.Image, format string) (string, error) {
 
-	fh, err := ioutil.TempFile("", "picturebook-")
+	opts := NewDefaultEncodeOptions()
+	return EncodeTempImageWithOptions(im, format, opts)
+}
+
+// EncodeTempImageWithOptions writes im to a new file in the system's temporary
+// directory and returns its path. The file is never removed, that's up to the
+// caller; use a TempStore if that's a problem.
+
+func EncodeTempImageWithOptions(im image.Image, format string, opts EncodeOptions) (string, error) {
+
+	// the file name needs to end with the format extension because without
+	// it fpdf.GetImageInfo gets confused and FREAKS out triggering fatal
+	// errors along the way... oh well (20171125/thisisaaronland)
+
+	fh, err := ioutil.TempFile("", "picturebook-*"+FormatExtension(format))
 
 	if err != nil {
 		return "", err
@@ -22,47 +68,147 @@ func EncodeTempImage(im image.Image, format string) (string, error) {
 
 	defer fh.Close()
 
-	err = EncodeImage(im, format, fh)
+	err = EncodeImageWithOptions(im, format, fh, opts)
 
 	if err != nil {
+		os.Remove(fh.Name())
 		return "", err
 	}
 
-	// see what's going on here - this (appending the format
-	// extension) is necessary because without it fpdf.GetImageInfo
-	// gets confused and FREAKS out triggering fatal errors
-	// along the way... oh well (20171125/thisisaaronland)
+	return fh.Name(), nil
+}
 
-	fname := fh.Name()
-	fh.Close()
+func EncodeImage(im image.Image, format string, wr io.Writer) error {
 
-	fq_fname := fmt.Sprintf("%s.%s", fname, format)
+	opts := NewDefaultEncodeOptions()
+	return EncodeImageWithOptions(im, format, wr, opts)
+}
 
-	err = os.Rename(fname, fq_fname)
+func EncodeImageWithOptions(im image.Image, format string, wr io.Writer, opts EncodeOptions) error {
 
-	if err != nil {
-		return "", err
-	}
+	if opts.Metadata != nil && (format == "jpeg" || format == "png") {
 
-	return fq_fname, nil
-}
+		md := opts.Metadata
+		opts.Metadata = nil
 
-func EncodeImage(im image.Image, format string, wr io.Writer) error {
+		var buf bytes.Buffer
+
+		err := EncodeImageWithOptions(im, format, &buf, opts)
+
+		if err != nil {
+			return err
+		}
+
+		body, err := WriteMetadata(buf.Bytes(), md)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = wr.Write(body)
+		return err
+	}
 
 	var err error
 
 	switch format {
 	case "jpeg":
-		opts := jpeg.Options{Quality: 100}
-		err = jpeg.Encode(wr, im, &opts)
+
+		if opts.JPEGQuality < 1 || opts.JPEGQuality > 100 {
+			return errors.New("Invalid JPEG quality")
+		}
+
+		jpeg_opts := jpeg.Options{Quality: opts.JPEGQuality}
+		err = jpeg.Encode(wr, im, &jpeg_opts)
+
 	case "png":
-		err = png.Encode(wr, im)
+
+		level, err := pngCompressionLevel(opts.PNGCompression)
+
+		if err != nil {
+			return err
+		}
+
+		enc := png.Encoder{CompressionLevel: level}
+		return enc.Encode(wr, im)
+
 	case "gif":
-		opts := gif.Options{}
-		err = gif.Encode(wr, im, &opts)
+
+		gif_opts, err := gifOptions(opts)
+
+		if err != nil {
+			return err
+		}
+
+		return gif.Encode(wr, im, gif_opts)
+
+	case "tiff":
+
+		// deflate is lossless and, unlike LZW, supported by the encoder
+
+		tiff_opts := tiff.Options{
+			Compression: tiff.Deflate,
+			Predictor:   true,
+		}
+
+		err = tiff.Encode(wr, im, &tiff_opts)
+
+	case "bmp":
+		err = bmp.Encode(wr, im)
+
 	default:
-		err = errors.New("Invalid or unsupported format")
+		err = &FormatError{Format: format}
 	}
 
 	return err
 }
+
+func pngCompressionLevel(compression string) (png.CompressionLevel, error) {
+
+	switch compression {
+	case "", "default":
+		return png.DefaultCompression, nil
+	case "none":
+		return png.NoCompression, nil
+	case "fast":
+		return png.BestSpeed, nil
+	case "best":
+		return png.BestCompression, nil
+	default:
+		return png.DefaultCompression, errors.New("Invalid PNG compression")
+	}
+}
+
+func gifOptions(opts EncodeOptions) (*gif.Options, error) {
+
+	if opts.GIFColours < 2 || opts.GIFColours > 256 {
+		return nil, errors.New("Invalid number of GIF colours")
+	}
+
+	gif_opts := gif.Options{
+		NumColors: opts.GIFColours,
+	}
+
+	switch opts.GIFQuantizer {
+	case "", "plan9":
+		// the default, when Quantizer is nil, is to use palette.Plan9
+		// truncated to NumColors
+	case "websafe":
+		gif_opts.Quantizer = PaletteQuantizer{Palette: palette.WebSafe}
+	case "median-cut":
+		gif_opts.Quantizer = MedianCutQuantizer{}
+	default:
+		return nil, errors.New("Invalid GIF quantizer")
+	}
+
+	switch opts.GIFDitherer {
+	case "", "floyd-steinberg":
+		gif_opts.Drawer = draw.FloydSteinberg
+	case "none":
+		gif_opts.Drawer = draw.Src
+	default:
+		return nil, errors.New("Invalid GIF ditherer")
+	}
+
+	return &gif_opts, nil
+}
@@ -0,0 +1,170 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/image/tiff"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// these are the kinds of things that go wrong reading and writing images. The
+// errors actually returned are usually one of the more specific types below,
+// which carry the details, so test for them with errors.Is:
+//
+//	if errors.Is(err, util.ErrTruncatedImage) { ... }
+//
+// Roughly speaking a truncated image might be worth retrying (it may still be
+// being copied), an unsupported, corrupt or oversized image should be skipped
+// and anything else is probably a reason to stop.
+
+var (
+	ErrUnsupportedFormat = errors.New("Invalid or unsupported format")
+	ErrTruncatedImage    = errors.New("Truncated image")
+	ErrCorruptImage      = errors.New("Invalid or corrupt image")
+	ErrDecodeLimit       = errors.New("Image exceeds decode limits")
+	ErrFormatMismatch    = errors.New("File extension does not match image format")
+	ErrOutputExists      = errors.New("Output file already exists")
+	ErrDuplicateOutput   = errors.New("Output file has already been written")
+)
+
+// FormatError is returned when a format (for example one passed to -format or
+// EncodeImage) is not one we know how to encode.
+
+type FormatError struct {
+	Format string
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("Invalid or unsupported format '%s'", e.Format)
+}
+
+func (e *FormatError) Unwrap() error {
+	return ErrUnsupportedFormat
+}
+
+// OutputExistsError is returned when an image would be written over an
+// existing file and the overwrite policy (see OutputOptions) is "error".
+
+type OutputExistsError struct {
+	Path string
+}
+
+func (e *OutputExistsError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrOutputExists, e.Path)
+}
+
+func (e *OutputExistsError) Unwrap() error {
+	return ErrOutputExists
+}
+
+// DuplicateOutputError is returned when an image would be written to the same
+// path as another image in the same run, for example "a/foo.jpg" and
+// "b/foo.jpg" with an output directory but without mirroring.
+
+type DuplicateOutputError struct {
+	Path string
+}
+
+func (e *DuplicateOutputError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrDuplicateOutput, e.Path)
+}
+
+func (e *DuplicateOutputError) Unwrap() error {
+	return ErrDuplicateOutput
+}
+
+// DecodeError wraps the error returned by the image decoders, which are all
+// different, with one of ErrUnsupportedFormat, ErrTruncatedImage or
+// ErrCorruptImage. The original error is Err. errors.Is and errors.As see
+// both, so errors.Is(err, io.ErrUnexpectedEOF) works too.
+
+type DecodeError struct {
+	Kind error
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+}
+
+func (e *DecodeError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// MismatchError is returned (or logged, see DecodeOptions) when the extension
+// of a file doesn't match what's actually in it, like a PNG file called
+// "foo.jpg". That's usually harmless since images are decoded according to
+// their contents but anything that trusts the extension will get it wrong.
+
+type MismatchError struct {
+	Path      string
+	Extension string
+	Format    string
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("%s has a '%s' extension but is a %s image", e.Path, e.Extension, e.Format)
+}
+
+func (e *MismatchError) Unwrap() error {
+	return ErrFormatMismatch
+}
+
+// the decoders don't agree on how to say that they ran out of data (and some
+// of them don't say so at all) so these are the messages that do
+
+var truncated_messages = []string{
+	"unexpected EOF",
+	"not enough pixel data",
+	"short Huffman data",
+}
+
+// decodeError classifies err, as returned by image.Decode (or DecodeConfig),
+// as a DecodeError. Errors that have already been classified are returned
+// unchanged.
+
+func decodeError(err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	switch err.(type) {
+	case *DecodeError, *DecodeLimitError:
+		return err
+	}
+
+	var kind error
+
+	switch e := err.(type) {
+	case jpeg.UnsupportedError, png.UnsupportedError, tiff.UnsupportedError:
+		kind = ErrUnsupportedFormat
+	default:
+
+		kind = ErrCorruptImage
+
+		if e == image.ErrFormat {
+			kind = ErrUnsupportedFormat
+			break
+		}
+
+		if e == io.EOF || errors.Is(e, io.ErrUnexpectedEOF) {
+			kind = ErrTruncatedImage
+			break
+		}
+
+		for _, msg := range truncated_messages {
+
+			if strings.Contains(e.Error(), msg) {
+				kind = ErrTruncatedImage
+				break
+			}
+		}
+	}
+
+	return &DecodeError{Kind: kind, Err: err}
+}
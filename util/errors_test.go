@@ -0,0 +1,107 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func TestDecodeError(t *testing.T) {
+
+	src := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+
+	src.Set(0, 0, color.White)
+
+	var png_buf bytes.Buffer
+	err := png.Encode(&png_buf, src)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var jpeg_buf bytes.Buffer
+	err = jpeg.Encode(&jpeg_buf, src, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	png_body := png_buf.Bytes()
+	jpeg_body := jpeg_buf.Bytes()
+
+	// a JPEG image whose start of frame marker has been changed to one for
+	// a kind of JPEG that Go doesn't support
+
+	unsupported := append([]byte{}, jpeg_body...)
+
+	for i := 2; i < len(unsupported)-1; i++ {
+
+		if unsupported[i] == 0xff && unsupported[i+1] == 0xc0 {
+			unsupported[i+1] = 0xcf
+			break
+		}
+	}
+
+	tests := []struct {
+		name       string
+		body       []byte
+		kind       error
+		underlying error
+	}{
+		{"truncated png", png_body[:20], ErrTruncatedImage, io.ErrUnexpectedEOF},
+		{"truncated png data", png_body[:len(png_body)/2], ErrTruncatedImage, nil},
+		{"unknown format", []byte("GIF00 not really"), ErrUnsupportedFormat, image.ErrFormat},
+		{"unsupported jpeg", unsupported, ErrUnsupportedFormat, nil},
+	}
+
+	for _, test := range tests {
+
+		_, _, err := DecodeImageFromReaderWithOptions(bytes.NewReader(test.body), NewDefaultDecodeOptions())
+
+		var decode_err *DecodeError
+
+		if !errors.As(err, &decode_err) {
+			t.Errorf("%s: expected a DecodeError, got %v", test.name, err)
+			continue
+		}
+
+		if !errors.Is(err, test.kind) {
+			t.Errorf("%s: expected errors.Is %v, got %v", test.name, test.kind, err)
+		}
+
+		if test.underlying != nil && !errors.Is(err, test.underlying) {
+			t.Errorf("%s: expected errors.Is %v for the underlying error, got %v", test.name, test.underlying, err)
+		}
+	}
+
+	// errors.As sees the decoder's own error types as well
+
+	err = decodeError(jpeg.FormatError("missing SOI marker"))
+
+	var format_err jpeg.FormatError
+
+	if !errors.As(err, &format_err) || !errors.Is(err, ErrCorruptImage) {
+		t.Errorf("Expected both ErrCorruptImage and a jpeg.FormatError, got %v", err)
+	}
+
+	err = decodeError(jpeg.UnsupportedError("progressive mode"))
+
+	var unsupported_err jpeg.UnsupportedError
+
+	if !errors.As(err, &unsupported_err) || !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected both ErrUnsupportedFormat and a jpeg.UnsupportedError, got %v", err)
+	}
+
+	if decodeError(err) != err {
+		t.Errorf("Expected a DecodeError to be returned unchanged")
+	}
+}
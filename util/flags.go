@@ -0,0 +1,48 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RegexpFlag is a flag that can be passed more than once, each value being a
+// regular expression
+
+type RegexpFlag []*regexp.Regexp
+
+func (i *RegexpFlag) String() string {
+
+	patterns := make([]string, 0)
+
+	for _, re := range *i {
+		patterns = append(patterns, fmt.Sprintf("%v", re))
+	}
+
+	return strings.Join(patterns, "\n")
+}
+
+func (i *RegexpFlag) Set(value string) error {
+
+	re, err := regexp.Compile(value)
+
+	if err != nil {
+		return err
+	}
+
+	*i = append(*i, re)
+	return nil
+}
+
+// MultiFlag is a flag that can be passed more than once
+
+type MultiFlag []string
+
+func (m *MultiFlag) String() string {
+	return strings.Join(*m, "\n")
+}
+
+func (m *MultiFlag) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// the extension used for new files written in a given format
+
+var format_extensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+	"tiff": ".tif",
+	"bmp":  ".bmp",
+}
+
+// all the extensions that are recognized as a given format
+
+var format_aliases = map[string][]string{
+	"jpeg": {".jpg", ".jpeg", ".jpe"},
+	"png":  {".png"},
+	"gif":  {".gif"},
+	"tiff": {".tif", ".tiff"},
+	"bmp":  {".bmp"},
+	"webp": {".webp"},
+}
+
+// NormalizeFormat returns the canonical name (the one returned by image.Decode)
+// for an output format, so that "JPG" and "jpg" are both "jpeg". It returns an
+// error if the format can not be encoded.
+
+func NormalizeFormat(format string) (string, error) {
+
+	format = strings.ToLower(strings.TrimSpace(format))
+	format = strings.TrimPrefix(format, ".")
+
+	switch format {
+	case "jpg":
+		format = "jpeg"
+	case "tif":
+		format = "tiff"
+	}
+
+	_, ok := format_extensions[format]
+
+	if !ok {
+		return "", &FormatError{Format: format}
+	}
+
+	return format, nil
+}
+
+// OutputFormat returns the format that an image decoded as format is written
+// as by default. That is the same format unless it can only be decoded (WebP)
+// in which case it's PNG.
+
+func OutputFormat(format string) string {
+
+	_, ok := format_extensions[format]
+
+	if !ok {
+		return "png"
+	}
+
+	return format
+}
+
+// FormatExtension returns the file extension, including the leading ".", for
+// new files in format.
+
+func FormatExtension(format string) string {
+
+	ext, ok := format_extensions[format]
+
+	if !ok {
+		return "." + format
+	}
+
+	return ext
+}
+
+// ReplaceExtension returns path with its extension changed to match format.
+// If path already has a valid extension for format (for example "foo.JPEG"
+// for "jpeg") it is returned unchanged.
+
+func ReplaceExtension(path string, format string) string {
+
+	ext := filepath.Ext(path)
+
+	for _, alias := range format_aliases[format] {
+
+		if strings.ToLower(ext) == alias {
+			return path
+		}
+	}
+
+	return strings.TrimSuffix(path, ext) + FormatExtension(format)
+}
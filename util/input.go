@@ -0,0 +1,138 @@
+package util
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Input is an image to be processed, either a file on disk (Path) or an image
+// that has already been read in to memory (Body), for example from stdin.
+
+type Input struct {
+	Path string
+	Body []byte
+}
+
+func NewInputFromPath(path string) *Input {
+
+	in := Input{
+		Path: path,
+	}
+
+	return &in
+}
+
+// NewInputFromReader reads all of fh, which may be stdin, in to memory since
+// decoding an image (and then its metadata) needs to read it more than once
+
+func NewInputFromReader(fh io.Reader) (*Input, error) {
+
+	body, err := ioutil.ReadAll(fh)
+
+	if err != nil {
+		return nil, err
+	}
+
+	in := Input{
+		Body: body,
+	}
+
+	return &in, nil
+}
+
+func (in *Input) DecodeImageWithOptions(opts DecodeOptions) (image.Image, string, error) {
+
+	if in.Body != nil {
+		return DecodeImageFromReaderWithOptions(bytes.NewReader(in.Body), opts)
+	}
+
+	return DecodeImageWithOptions(in.Path, opts)
+}
+
+func (in *Input) DecodeAnimationWithOptions(opts DecodeOptions) (*Animation, error) {
+
+	if in.Body != nil {
+		return DecodeAnimationFromReaderWithOptions(bytes.NewReader(in.Body), opts)
+	}
+
+	return DecodeAnimationWithOptions(in.Path, opts)
+}
+
+// DecodeWithOptions decodes in as an Animation, rather than an image, if
+// animate is true and in is a GIF. The image that is returned is then the
+// first frame of the animation, so that the file only needs to be decoded
+// once. The Animation is nil unless there is more than one frame.
+
+func (in *Input) DecodeWithOptions(opts DecodeOptions, animate bool) (image.Image, *Animation, string, error) {
+
+	if animate {
+
+		format, err := in.Format()
+
+		if err != nil {
+			return nil, nil, "", err
+		}
+
+		if format == "gif" {
+
+			anim, err := in.DecodeAnimationWithOptions(opts)
+
+			if err != nil {
+				return nil, nil, "", err
+			}
+
+			if len(anim.Frames) == 1 {
+				return anim.Frames[0], nil, format, nil
+			}
+
+			return anim.Frames[0], anim, format, nil
+		}
+	}
+
+	im, format, err := in.DecodeImageWithOptions(opts)
+
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return im, nil, format, nil
+}
+
+// Format returns the format of in according to its first few bytes, rather
+// than its extension, or "" if it isn't a format we know about
+
+func (in *Input) Format() (string, error) {
+
+	if in.Body != nil {
+		return SniffFormat(in.Body), nil
+	}
+
+	fh, err := os.Open(in.Path)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer fh.Close()
+
+	header := make([]byte, 12)
+	n, err := io.ReadFull(fh, header)
+
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	return SniffFormat(header[:n]), nil
+}
+
+func (in *Input) ReadMetadataWithPolicy(policy string) (*Metadata, error) {
+
+	if in.Body != nil {
+		return ReadMetadataWithPolicy(in.Body, policy)
+	}
+
+	return ReadMetadataFromPathWithPolicy(in.Path, policy)
+}
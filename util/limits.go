@@ -0,0 +1,105 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// DecodeLimitError is returned when an image is (or would be, once decoded)
+// larger than one of the limits in DecodeOptions. Callers can check for it
+// and skip the image rather than giving up altogether.
+
+type DecodeLimitError struct {
+	Limit string
+	Value int64
+	Max   int64
+}
+
+func (e *DecodeLimitError) Error() string {
+	return fmt.Sprintf("Image exceeds the maximum %s (%d > %d)", e.Limit, e.Value, e.Max)
+}
+
+func (e *DecodeLimitError) Unwrap() error {
+	return ErrDecodeLimit
+}
+
+// IsDecodeLimitError returns true if err is (or wraps) a DecodeLimitError
+
+func IsDecodeLimitError(err error) bool {
+	return errors.Is(err, ErrDecodeLimit)
+}
+
+// CheckDecodeLimits compares the dimensions and colour model of an image, as
+// reported by image.DecodeConfig, with the limits in opts. Limits that are 0
+// are ignored.
+
+func CheckDecodeLimits(cfg image.Config, opts DecodeOptions) error {
+
+	w := int64(cfg.Width)
+	h := int64(cfg.Height)
+
+	max_dim := w
+
+	if h > max_dim {
+		max_dim = h
+	}
+
+	if opts.MaxDimension > 0 && max_dim > int64(opts.MaxDimension) {
+		return &DecodeLimitError{Limit: "dimension", Value: max_dim, Max: int64(opts.MaxDimension)}
+	}
+
+	pixels := w * h
+
+	if opts.MaxPixels > 0 && pixels > opts.MaxPixels {
+		return &DecodeLimitError{Limit: "pixels", Value: pixels, Max: opts.MaxPixels}
+	}
+
+	if opts.MaxMemory > 0 {
+
+		memory := pixels * bytesPerPixel(cfg.ColorModel)
+
+		// auto-orienting and colour managing make an (8-bit) copy
+
+		if opts.AutoOrient || opts.ColourManage {
+			memory += pixels * 4
+		}
+
+		if memory > opts.MaxMemory {
+			return &DecodeLimitError{Limit: "memory", Value: memory, Max: opts.MaxMemory}
+		}
+	}
+
+	return nil
+}
+
+func hasDecodeLimits(opts DecodeOptions) bool {
+	return opts.MaxDimension > 0 || opts.MaxPixels > 0 || opts.MaxMemory > 0
+}
+
+// bytesPerPixel returns (roughly) how much memory each pixel of a decoded image
+// with colour model m will take
+
+func bytesPerPixel(m color.Model) int64 {
+
+	switch m {
+	case color.GrayModel, color.AlphaModel:
+		return 1
+	case color.Gray16Model, color.Alpha16Model:
+		return 2
+	case color.YCbCrModel:
+		// assumes no chroma subsampling, which is the worst case
+		return 3
+	case color.NYCbCrAModel:
+		return 4
+	case color.RGBA64Model, color.NRGBA64Model:
+		return 8
+	}
+
+	if _, ok := m.(color.Palette); ok {
+		return 1
+	}
+
+	return 4
+}
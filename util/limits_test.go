@@ -0,0 +1,62 @@
+package util
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestCheckDecodeLimits(t *testing.T) {
+
+	rgba := func(w int, h int) image.Config {
+		return image.Config{ColorModel: color.RGBAModel, Width: w, Height: h}
+	}
+
+	tests := []struct {
+		name  string
+		cfg   image.Config
+		opts  DecodeOptions
+		limit string
+	}{
+		{"no limits", rgba(100000, 100000), DecodeOptions{}, ""},
+		{"defaults", rgba(4000, 3000), NewDefaultDecodeOptions(), ""},
+		{"dimension", rgba(60000, 10), DecodeOptions{MaxDimension: 50000}, "dimension"},
+		{"dimension height", rgba(10, 60000), DecodeOptions{MaxDimension: 50000}, "dimension"},
+		{"dimension exact", rgba(50000, 10), DecodeOptions{MaxDimension: 50000}, ""},
+		{"pixels", rgba(2000, 2000), DecodeOptions{MaxPixels: 1000000}, "pixels"},
+		{"pixels exact", rgba(1000, 1000), DecodeOptions{MaxPixels: 1000000}, ""},
+		{"memory", rgba(1000, 1000), DecodeOptions{MaxMemory: 3000000}, "memory"},
+		{"memory fits", rgba(1000, 1000), DecodeOptions{MaxMemory: 4000000}, ""},
+		{"memory grey", image.Config{ColorModel: color.GrayModel, Width: 1000, Height: 1000}, DecodeOptions{MaxMemory: 3000000}, ""},
+		{"memory 16 bit", image.Config{ColorModel: color.RGBA64Model, Width: 1000, Height: 1000}, DecodeOptions{MaxMemory: 4000000}, "memory"},
+		{"memory paletted", image.Config{ColorModel: color.Palette{color.Black, color.White}, Width: 1000, Height: 1000}, DecodeOptions{MaxMemory: 1000000}, ""},
+		{"memory with copy", image.Config{ColorModel: color.GrayModel, Width: 1000, Height: 1000}, DecodeOptions{MaxMemory: 3000000, AutoOrient: true}, "memory"},
+		{"dimension first", rgba(60000, 60000), DecodeOptions{MaxDimension: 50000, MaxPixels: 1}, "dimension"},
+	}
+
+	for _, test := range tests {
+
+		err := CheckDecodeLimits(test.cfg, test.opts)
+
+		if test.limit == "" {
+
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+
+			continue
+		}
+
+		if !IsDecodeLimitError(err) {
+			t.Errorf("%s: expected a decode limit error, got %v", test.name, err)
+			continue
+		}
+
+		var limit_err *DecodeLimitError
+
+		if !errors.As(err, &limit_err) || limit_err.Limit != test.limit {
+			t.Errorf("%s: expected the %s limit, got %v", test.name, test.limit, err)
+		}
+	}
+}
@@ -0,0 +1,795 @@
+package util
+
+// https://www.adobe.com/devnet/xmp.html (part 3, "Storage in files")
+// http://www.color.org/specification/ICC1v43_2010-12.pdf (annex B, "Embedding ICC profiles")
+// https://www.w3.org/TR/PNG/#11addnlcolinfo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Metadata is the descriptive metadata in an image that we know how to carry
+// from one file to another: the EXIF block (TIFF-structured, without the JPEG
+// "Exif" prefix), the XMP packet, the ICC colour profile and any free-form
+// text (PNG text chunks and JPEG comments, which are stored as "Comment").
+
+type Metadata struct {
+	EXIF []byte
+	XMP  []byte
+	ICC  []byte
+	Text []MetadataText
+}
+
+type MetadataText struct {
+	Keyword string
+	Text    string
+}
+
+const exif_artist_tag = 0x013b
+const exif_copyright_tag = 0x8298
+
+const tiff_icc_tag = 34675
+
+const xmp_keyword = "XML:com.adobe.xmp"
+
+var jpeg_xmp_prefix = []byte("http://ns.adobe.com/xap/1.0/\x00")
+var jpeg_icc_prefix = []byte("ICC_PROFILE\x00")
+
+// the largest payload that will fit in a JPEG segment (65535 bytes, less the
+// two bytes for the length itself)
+
+const jpeg_max_segment = 65533
+
+var re_xmp_rights = regexp.MustCompile(`(?s)<dc:(?:rights|creator)\b.*?</dc:(?:rights|creator)>`)
+
+// ReadMetadata returns the metadata in the encoded image in body. JPEG (APP1,
+// APP2 and COM segments) and PNG (eXIf, iCCP, tEXt, zTXt and iTXt chunks)
+// images are supported, as is the ICC profile (InterColorProfile) in a TIFF
+// image; anything else returns empty metadata.
+
+func ReadMetadata(body []byte) (*Metadata, error) {
+
+	switch {
+	case bytes.HasPrefix(body, jpeg_signature):
+		return jpegMetadata(body)
+	case bytes.HasPrefix(body, png_signature):
+		return pngMetadata(body)
+	case bytes.HasPrefix(body, tiff_le_signature), bytes.HasPrefix(body, tiff_be_signature):
+		return tiffMetadata(body)
+	default:
+		return &Metadata{}, nil
+	}
+}
+
+func ReadMetadataFromPath(path string) (*Metadata, error) {
+
+	abs_path, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadFile(abs_path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ReadMetadata(body)
+}
+
+// ReadMetadataFromPathWithPolicy returns the metadata in the image at path
+// filtered according to policy (see Metadata.Filter). As with orientation,
+// broken metadata is not a reason to throw away an otherwise perfectly good
+// image so it is treated as no metadata at all.
+
+func ReadMetadataFromPathWithPolicy(path string, policy string) (*Metadata, error) {
+
+	abs_path, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadFile(abs_path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ReadMetadataWithPolicy(body, policy)
+}
+
+// ReadMetadataWithPolicy is like ReadMetadataFromPathWithPolicy for an image
+// that has already been read
+
+func ReadMetadataWithPolicy(body []byte, policy string) (*Metadata, error) {
+
+	md, err := ReadMetadata(body)
+
+	if IsDecodeLimitError(err) {
+		return nil, err
+	}
+
+	if err != nil {
+		md = &Metadata{}
+	}
+
+	return md.Filter(policy)
+}
+
+// Filter returns a copy of md according to policy, which is one of "keep"
+// (everything), "copyright" (only the artist and copyright EXIF tags, the
+// dc:creator and dc:rights XMP properties, the "Author" and "Copyright" text
+// and the ICC profile, since dropping that would change what the image looks
+// like) or "strip" (nothing at all).
+
+func (md *Metadata) Filter(policy string) (*Metadata, error) {
+
+	switch policy {
+	case "keep":
+
+		new_md := Metadata{
+			EXIF: md.EXIF,
+			XMP:  md.XMP,
+			ICC:  md.ICC,
+			Text: md.Text,
+		}
+
+		return &new_md, nil
+
+	case "copyright":
+
+		new_md := Metadata{
+			ICC:  md.ICC,
+			Text: make([]MetadataText, 0),
+		}
+
+		if md.EXIF != nil {
+
+			tags := make(map[uint16]string)
+
+			for _, tag := range []uint16{exif_artist_tag, exif_copyright_tag} {
+
+				v, ok, err := tiffASCII(md.EXIF, tag)
+
+				if err == nil && ok && v != "" {
+					tags[tag] = v
+				}
+			}
+
+			if len(tags) > 0 {
+				new_md.EXIF = buildEXIF(tags)
+			}
+		}
+
+		if md.XMP != nil {
+
+			props := re_xmp_rights.FindAll(md.XMP, -1)
+
+			if len(props) > 0 {
+				new_md.XMP = buildXMP(props)
+			}
+		}
+
+		for _, t := range md.Text {
+
+			switch strings.ToLower(t.Keyword) {
+			case "author", "copyright":
+				new_md.Text = append(new_md.Text, t)
+			}
+		}
+
+		return &new_md, nil
+
+	case "strip":
+		return &Metadata{}, nil
+
+	default:
+		return nil, errors.New("Invalid metadata policy")
+	}
+}
+
+// ResetOrientation sets the EXIF orientation to 1 (normal). This should be
+// done whenever the pixels have been rotated to match the original orientation
+// otherwise they'll be rotated all over again when they're displayed.
+
+func (md *Metadata) ResetOrientation() {
+
+	if md.EXIF == nil {
+		return
+	}
+
+	order, entries, err := tiffEntries(md.EXIF)
+
+	if err != nil {
+		return
+	}
+
+	entry, ok := entries[exif_orientation_tag]
+
+	if !ok || order.Uint16(md.EXIF[entry+2:entry+4]) != 3 {
+		return
+	}
+
+	exif := make([]byte, len(md.EXIF))
+	copy(exif, md.EXIF)
+
+	order.PutUint16(exif[entry+8:entry+10], 1)
+	md.EXIF = exif
+}
+
+// IsEmpty returns true if there's no metadata to write
+
+func (md *Metadata) IsEmpty() bool {
+	return md.EXIF == nil && md.XMP == nil && md.ICC == nil && len(md.Text) == 0
+}
+
+// WriteMetadata returns a copy of the encoded image in body with md added to
+// it. JPEG and PNG images are supported; anything else is returned as is.
+// ICC profiles whose colour space doesn't match the image (an RGB profile for
+// a greyscale image, for example) are left out since they would make the
+// image invalid. Text is only written to JPEG images if it is a "Comment".
+
+func WriteMetadata(body []byte, md *Metadata) ([]byte, error) {
+
+	if md == nil || md.IsEmpty() {
+		return body, nil
+	}
+
+	switch {
+	case bytes.HasPrefix(body, jpeg_signature):
+		return writeJPEGMetadata(body, md)
+	case bytes.HasPrefix(body, png_signature):
+		return writePNGMetadata(body, md)
+	default:
+		return body, nil
+	}
+}
+
+func jpegMetadata(body []byte) (*Metadata, error) {
+
+	segments, err := jpegSegments(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	md := Metadata{
+		Text: make([]MetadataText, 0),
+	}
+
+	icc_chunks := make(map[int][]byte)
+
+	for _, seg := range segments {
+
+		switch {
+		case seg.marker == 0xe1 && bytes.HasPrefix(seg.data, jpeg_exif_prefix):
+			md.EXIF = seg.data[len(jpeg_exif_prefix):]
+		case seg.marker == 0xe1 && bytes.HasPrefix(seg.data, jpeg_xmp_prefix):
+			md.XMP = seg.data[len(jpeg_xmp_prefix):]
+		case seg.marker == 0xe2 && bytes.HasPrefix(seg.data, jpeg_icc_prefix):
+
+			// sequence number (1-based) and count
+
+			if len(seg.data) < len(jpeg_icc_prefix)+2 {
+				return nil, errors.New("Truncated ICC profile")
+			}
+
+			seq := int(seg.data[len(jpeg_icc_prefix)])
+			icc_chunks[seq] = seg.data[len(jpeg_icc_prefix)+2:]
+
+		case seg.marker == 0xfe:
+			md.Text = append(md.Text, MetadataText{Keyword: "Comment", Text: string(seg.data)})
+		}
+	}
+
+	if len(icc_chunks) > 0 {
+
+		seqs := make([]int, 0)
+
+		for seq := range icc_chunks {
+			seqs = append(seqs, seq)
+		}
+
+		sort.Ints(seqs)
+
+		icc := make([]byte, 0)
+
+		for _, seq := range seqs {
+			icc = append(icc, icc_chunks[seq]...)
+		}
+
+		md.ICC = icc
+	}
+
+	return &md, nil
+}
+
+// tiffMetadata only reads the ICC profile since that's what colour management
+// needs; there is nowhere to write anything else back to since TIFF images are
+// always encoded without metadata
+
+func tiffMetadata(body []byte) (*Metadata, error) {
+
+	icc, ok, err := tiffBytes(body, tiff_icc_tag)
+
+	if err != nil {
+		return nil, err
+	}
+
+	md := Metadata{
+		Text: make([]MetadataText, 0),
+	}
+
+	if ok {
+		md.ICC = icc
+	}
+
+	return &md, nil
+}
+
+func pngMetadata(body []byte) (*Metadata, error) {
+
+	chunks, err := pngChunks(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	md := Metadata{
+		Text: make([]MetadataText, 0),
+	}
+
+	for _, c := range chunks {
+
+		switch c.name {
+		case "eXIf":
+			md.EXIF = c.data
+		case "iCCP":
+
+			// profile name, a null, the compression method and then the
+			// compressed profile
+
+			idx := bytes.IndexByte(c.data, 0)
+
+			if idx == -1 || idx+2 > len(c.data) {
+				return nil, errors.New("Invalid iCCP chunk")
+			}
+
+			icc, err := inflate(c.data[idx+2:])
+
+			if err != nil {
+				return nil, err
+			}
+
+			md.ICC = icc
+
+		case "tEXt":
+
+			idx := bytes.IndexByte(c.data, 0)
+
+			if idx == -1 {
+				return nil, errors.New("Invalid tEXt chunk")
+			}
+
+			t := MetadataText{
+				Keyword: latin1(c.data[:idx]),
+				Text:    latin1(c.data[idx+1:]),
+			}
+
+			md.Text = append(md.Text, t)
+
+		case "zTXt":
+
+			idx := bytes.IndexByte(c.data, 0)
+
+			if idx == -1 || idx+2 > len(c.data) {
+				return nil, errors.New("Invalid zTXt chunk")
+			}
+
+			text, err := inflate(c.data[idx+2:])
+
+			if err != nil {
+				return nil, err
+			}
+
+			t := MetadataText{
+				Keyword: latin1(c.data[:idx]),
+				Text:    latin1(text),
+			}
+
+			md.Text = append(md.Text, t)
+
+		case "iTXt":
+
+			keyword, text, err := parseITXt(c.data)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if keyword == xmp_keyword {
+				md.XMP = []byte(text)
+				continue
+			}
+
+			md.Text = append(md.Text, MetadataText{Keyword: keyword, Text: text})
+		}
+	}
+
+	return &md, nil
+}
+
+// parseITXt returns the keyword and text of an iTXt chunk: the keyword, a
+// null, the compression flag and method, the language tag and translated
+// keyword (each null-terminated) and then the (possibly compressed) text
+
+func parseITXt(data []byte) (string, string, error) {
+
+	idx := bytes.IndexByte(data, 0)
+
+	if idx == -1 || idx+3 > len(data) {
+		return "", "", errors.New("Invalid iTXt chunk")
+	}
+
+	keyword := string(data[:idx])
+	compressed := data[idx+1] == 1
+
+	rest := data[idx+3:]
+
+	for i := 0; i < 2; i++ {
+
+		idx = bytes.IndexByte(rest, 0)
+
+		if idx == -1 {
+			return "", "", errors.New("Invalid iTXt chunk")
+		}
+
+		rest = rest[idx+1:]
+	}
+
+	if compressed {
+
+		text, err := inflate(rest)
+
+		if err != nil {
+			return "", "", err
+		}
+
+		rest = text
+	}
+
+	return keyword, string(rest), nil
+}
+
+func writeJPEGMetadata(body []byte, md *Metadata) ([]byte, error) {
+
+	segments := make([][]byte, 0)
+
+	if md.EXIF != nil && len(jpeg_exif_prefix)+len(md.EXIF) <= jpeg_max_segment {
+		segments = append(segments, jpegSegmentBytes(0xe1, jpeg_exif_prefix, md.EXIF))
+	}
+
+	// extended XMP, for packets that don't fit in a single segment, is not
+	// supported (yet)
+
+	if md.XMP != nil && len(jpeg_xmp_prefix)+len(md.XMP) <= jpeg_max_segment {
+		segments = append(segments, jpegSegmentBytes(0xe1, jpeg_xmp_prefix, md.XMP))
+	}
+
+	if md.ICC != nil && iccMatches(md.ICC, jpegComponents(body)) {
+
+		max_chunk := jpeg_max_segment - (len(jpeg_icc_prefix) + 2)
+		count := (len(md.ICC) + max_chunk - 1) / max_chunk
+
+		if count <= 255 {
+
+			for i := 0; i < count; i++ {
+
+				start := i * max_chunk
+				end := start + max_chunk
+
+				if end > len(md.ICC) {
+					end = len(md.ICC)
+				}
+
+				prefix := append(append([]byte{}, jpeg_icc_prefix...), byte(i+1), byte(count))
+				segments = append(segments, jpegSegmentBytes(0xe2, prefix, md.ICC[start:end]))
+			}
+		}
+	}
+
+	for _, t := range md.Text {
+
+		if t.Keyword != "Comment" || len(t.Text) > jpeg_max_segment {
+			continue
+		}
+
+		segments = append(segments, jpegSegmentBytes(0xfe, nil, []byte(t.Text)))
+	}
+
+	// everything goes immediately after the start of image marker
+
+	var buf bytes.Buffer
+	buf.Write(body[:2])
+
+	for _, seg := range segments {
+		buf.Write(seg)
+	}
+
+	buf.Write(body[2:])
+
+	return buf.Bytes(), nil
+}
+
+func jpegSegmentBytes(marker byte, prefix []byte, data []byte) []byte {
+
+	length := 2 + len(prefix) + len(data)
+
+	seg := make([]byte, 4, 2+length)
+	seg[0] = 0xff
+	seg[1] = marker
+
+	binary.BigEndian.PutUint16(seg[2:4], uint16(length))
+
+	seg = append(seg, prefix...)
+	seg = append(seg, data...)
+
+	return seg
+}
+
+// jpegComponents returns the number of colour components (1 for greyscale,
+// 3 for colour) in the frame header of a JPEG image
+
+func jpegComponents(body []byte) int {
+
+	segments, err := jpegSegments(body)
+
+	if err != nil {
+		return 0
+	}
+
+	for _, seg := range segments {
+
+		if seg.marker >= 0xc0 && seg.marker <= 0xc2 && len(seg.data) >= 6 {
+			return int(seg.data[5])
+		}
+	}
+
+	return 0
+}
+
+func writePNGMetadata(body []byte, md *Metadata) ([]byte, error) {
+
+	chunks, err := pngChunks(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chunks) == 0 || chunks[0].name != "IHDR" || len(chunks[0].data) < 10 {
+		return nil, errors.New("Invalid PNG image")
+	}
+
+	components := 3
+
+	switch chunks[0].data[9] {
+	case 0, 4:
+		components = 1
+	}
+
+	extra := make([][]byte, 0)
+
+	if md.ICC != nil && iccMatches(md.ICC, components) {
+
+		var compressed bytes.Buffer
+
+		wr := zlib.NewWriter(&compressed)
+		wr.Write(md.ICC)
+		wr.Close()
+
+		data := append([]byte("ICC Profile\x00\x00"), compressed.Bytes()...)
+		extra = append(extra, pngChunkBytes("iCCP", data))
+	}
+
+	if md.EXIF != nil {
+		extra = append(extra, pngChunkBytes("eXIf", md.EXIF))
+	}
+
+	if md.XMP != nil {
+		extra = append(extra, pngChunkBytes("iTXt", iTXtBytes(xmp_keyword, string(md.XMP))))
+	}
+
+	for _, t := range md.Text {
+
+		if t.Keyword == "" || len(t.Keyword) > 79 {
+			continue
+		}
+
+		extra = append(extra, pngChunkBytes("iTXt", iTXtBytes(t.Keyword, t.Text)))
+	}
+
+	// everything goes immediately after the IHDR chunk, which is the only
+	// place it's guaranteed to be before PLTE and IDAT as the iCCP chunk
+	// must be
+
+	ihdr_end := len(png_signature) + 12 + len(chunks[0].data)
+
+	var buf bytes.Buffer
+	buf.Write(body[:ihdr_end])
+
+	for _, c := range extra {
+		buf.Write(c)
+	}
+
+	buf.Write(body[ihdr_end:])
+
+	return buf.Bytes(), nil
+}
+
+func pngChunkBytes(name string, data []byte) []byte {
+
+	c := make([]byte, 8, 12+len(data))
+
+	binary.BigEndian.PutUint32(c[0:4], uint32(len(data)))
+	copy(c[4:8], name)
+
+	c = append(c, data...)
+
+	crc := crc32.ChecksumIEEE(c[4:])
+
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc)
+
+	return append(c, sum...)
+}
+
+// iTXtBytes returns an uncompressed iTXt chunk with no language tag
+
+func iTXtBytes(keyword string, text string) []byte {
+
+	data := make([]byte, 0)
+	data = append(data, keyword...)
+	data = append(data, 0, 0, 0, 0, 0)
+	data = append(data, text...)
+
+	return data
+}
+
+// iccMatches returns true if the colour space of the ICC profile icc can be
+// used with an image that has components colour components
+
+func iccMatches(icc []byte, components int) bool {
+
+	if len(icc) < 20 {
+		return false
+	}
+
+	switch string(icc[16:20]) {
+	case "GRAY":
+		return components == 1
+	case "RGB ":
+		return components == 3
+	default:
+		return false
+	}
+}
+
+// buildEXIF returns a little-endian TIFF-structured EXIF block with a single
+// IFD containing the ASCII tags in tags
+
+func buildEXIF(tags map[uint16]string) []byte {
+
+	keys := make([]int, 0)
+
+	for tag := range tags {
+		keys = append(keys, int(tag))
+	}
+
+	// entries must be sorted by tag
+
+	sort.Ints(keys)
+
+	order := binary.LittleEndian
+
+	ifd_len := 2 + (12 * len(keys)) + 4
+	values_offset := 8 + ifd_len
+
+	exif := make([]byte, values_offset)
+	copy(exif[0:4], tiff_le_signature)
+	order.PutUint32(exif[4:8], 8)
+	order.PutUint16(exif[8:10], uint16(len(keys)))
+
+	for i, k := range keys {
+
+		v := append([]byte(tags[uint16(k)]), 0)
+		entry := 10 + (i * 12)
+
+		order.PutUint16(exif[entry:entry+2], uint16(k))
+		order.PutUint16(exif[entry+2:entry+4], 2)
+		order.PutUint32(exif[entry+4:entry+8], uint32(len(v)))
+
+		if len(v) <= 4 {
+			copy(exif[entry+8:entry+12], v)
+			continue
+		}
+
+		order.PutUint32(exif[entry+8:entry+12], uint32(len(exif)))
+		exif = append(exif, v...)
+	}
+
+	return exif
+}
+
+// buildXMP returns a minimal XMP packet containing the (Dublin Core) props
+
+func buildXMP(props [][]byte) []byte {
+
+	header := "<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n"
+
+	packet := header + `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">
+%s
+</rdf:Description>
+</rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+	return []byte(fmt.Sprintf(packet, bytes.Join(props, []byte("\n"))))
+}
+
+// max_inflated_chunk is the most a compressed (iCCP, zTXt or iTXt) chunk is
+// allowed to inflate to. Real ICC profiles and text are nowhere near this; a
+// chunk that is bigger is almost certainly a zlib bomb.
+
+const max_inflated_chunk = 4 * 1024 * 1024
+
+func inflate(data []byte) ([]byte, error) {
+
+	rd, err := zlib.NewReader(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rd.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(rd, max_inflated_chunk+1))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) > max_inflated_chunk {
+		return nil, &DecodeLimitError{Limit: "metadata chunk size", Value: int64(len(body)), Max: max_inflated_chunk}
+	}
+
+	return body, nil
+}
+
+// latin1 converts ISO 8859-1 text, which is what tEXt and zTXt chunks contain,
+// to UTF-8
+
+func latin1(b []byte) string {
+
+	runes := make([]rune, len(b))
+
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+
+	return string(runes)
+}
@@ -0,0 +1,398 @@
+package util
+
+// https://www.daveperrett.com/articles/2012/07/28/exif-orientation-handling-is-a-ghetto/
+// https://www.media.mit.edu/pia/Research/deepview/exif.html
+// http://ftp-osl.osuosl.org/pub/libpng/documents/pngext-1.5.0.html#C.eXIf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"strings"
+)
+
+const exif_orientation_tag = 0x0112
+
+var jpeg_signature = []byte{0xff, 0xd8}
+var png_signature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+var tiff_le_signature = []byte{'I', 'I', 0x2a, 0x00}
+var tiff_be_signature = []byte{'M', 'M', 0x00, 0x2a}
+
+var jpeg_exif_prefix = []byte("Exif\x00\x00")
+
+// Orientation returns the EXIF orientation (1 - 8) of the encoded image in
+// body. JPEG (APP1), PNG (eXIf) and TIFF images are supported. Images with no
+// orientation, or in other formats, are reported as 1 (normal).
+
+func Orientation(body []byte) (int, error) {
+
+	tiff, err := ExtractEXIF(body)
+
+	if err != nil {
+		return 1, err
+	}
+
+	if tiff == nil {
+		return 1, nil
+	}
+
+	v, ok, err := tiffTag(tiff, exif_orientation_tag)
+
+	if err != nil {
+		return 1, err
+	}
+
+	if !ok || v < 1 || v > 8 {
+		return 1, nil
+	}
+
+	return int(v), nil
+}
+
+// ExtractEXIF returns the raw TIFF-structured EXIF block in body, or nil if
+// there isn't one. For TIFF images that is the file itself.
+
+func ExtractEXIF(body []byte) ([]byte, error) {
+
+	switch {
+	case bytes.HasPrefix(body, jpeg_signature):
+		return jpegEXIF(body)
+	case bytes.HasPrefix(body, png_signature):
+		return pngEXIF(body)
+	case bytes.HasPrefix(body, tiff_le_signature), bytes.HasPrefix(body, tiff_be_signature):
+		return body, nil
+	default:
+		return nil, nil
+	}
+}
+
+func jpegEXIF(body []byte) ([]byte, error) {
+
+	segments, err := jpegSegments(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, seg := range segments {
+
+		if seg.marker == 0xe1 && bytes.HasPrefix(seg.data, jpeg_exif_prefix) {
+			return seg.data[len(jpeg_exif_prefix):], nil
+		}
+	}
+
+	return nil, nil
+}
+
+func pngEXIF(body []byte) ([]byte, error) {
+
+	chunks, err := pngChunks(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range chunks {
+
+		if c.name == "eXIf" {
+			return c.data, nil
+		}
+	}
+
+	return nil, nil
+}
+
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// jpegSegments returns all the segments in body up to the start of the image
+// data, which is where the metadata lives
+
+func jpegSegments(body []byte) ([]jpegSegment, error) {
+
+	segments := make([]jpegSegment, 0)
+
+	offset := 2
+
+	for offset+4 <= len(body) {
+
+		if body[offset] != 0xff {
+			return nil, errors.New("Invalid JPEG marker")
+		}
+
+		marker := body[offset+1]
+
+		// padding
+		if marker == 0xff {
+			offset += 1
+			continue
+		}
+
+		// start of scan; there's no more metadata after this
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(body[offset+2 : offset+4]))
+
+		if length < 2 || offset+2+length > len(body) {
+			return nil, errors.New("Truncated JPEG segment")
+		}
+
+		seg := jpegSegment{
+			marker: marker,
+			data:   body[offset+4 : offset+2+length],
+		}
+
+		segments = append(segments, seg)
+
+		offset += 2 + length
+	}
+
+	return segments, nil
+}
+
+type pngChunk struct {
+	name string
+	data []byte
+}
+
+func pngChunks(body []byte) ([]pngChunk, error) {
+
+	chunks := make([]pngChunk, 0)
+
+	offset := len(png_signature)
+
+	for offset+8 <= len(body) {
+
+		length := int(binary.BigEndian.Uint32(body[offset : offset+4]))
+		name := string(body[offset+4 : offset+8])
+
+		if length < 0 || offset+12+length > len(body) {
+			return nil, errors.New("Truncated PNG chunk")
+		}
+
+		c := pngChunk{
+			name: name,
+			data: body[offset+8 : offset+8+length],
+		}
+
+		chunks = append(chunks, c)
+
+		if name == "IEND" {
+			break
+		}
+
+		offset += 12 + length
+	}
+
+	return chunks, nil
+}
+
+// tiffEntries returns the byte order of tiff and the offset of each entry in
+// its first IFD, keyed by tag
+
+func tiffEntries(tiff []byte) (binary.ByteOrder, map[uint16]int, error) {
+
+	if len(tiff) < 8 {
+		return nil, nil, errors.New("Truncated TIFF header")
+	}
+
+	var order binary.ByteOrder
+
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, nil, errors.New("Invalid TIFF byte order")
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+
+	if ifd < 0 || ifd+2 > len(tiff) {
+		return nil, nil, errors.New("Truncated TIFF IFD")
+	}
+
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+
+	entries := make(map[uint16]int)
+
+	for i := 0; i < count; i++ {
+
+		entry := ifd + 2 + (i * 12)
+
+		if entry+12 > len(tiff) {
+			return nil, nil, errors.New("Truncated TIFF IFD")
+		}
+
+		entries[order.Uint16(tiff[entry:entry+2])] = entry
+	}
+
+	return order, entries, nil
+}
+
+// tiffTag returns the value of a SHORT or LONG tag in the first IFD of tiff
+
+func tiffTag(tiff []byte, tag uint16) (uint32, bool, error) {
+
+	order, entries, err := tiffEntries(tiff)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	entry, ok := entries[tag]
+
+	if !ok {
+		return 0, false, nil
+	}
+
+	switch order.Uint16(tiff[entry+2 : entry+4]) {
+	case 3:
+		return uint32(order.Uint16(tiff[entry+8 : entry+10])), true, nil
+	case 4:
+		return order.Uint32(tiff[entry+8 : entry+12]), true, nil
+	default:
+		return 0, false, errors.New("Unsupported TIFF tag type")
+	}
+}
+
+// tiffASCII returns the value of an ASCII tag in the first IFD of tiff
+
+func tiffASCII(tiff []byte, tag uint16) (string, bool, error) {
+
+	order, entries, err := tiffEntries(tiff)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	entry, ok := entries[tag]
+
+	if !ok {
+		return "", false, nil
+	}
+
+	if order.Uint16(tiff[entry+2:entry+4]) != 2 {
+		return "", false, errors.New("Unsupported TIFF tag type")
+	}
+
+	count := int(order.Uint32(tiff[entry+4 : entry+8]))
+	offset := entry + 8
+
+	if count > 4 {
+		offset = int(order.Uint32(tiff[entry+8 : entry+12]))
+	}
+
+	if count < 0 || offset < 0 || offset+count > len(tiff) {
+		return "", false, errors.New("Truncated TIFF tag")
+	}
+
+	v := strings.TrimRight(string(tiff[offset:offset+count]), "\x00")
+	return v, true, nil
+}
+
+// tiffBytes returns the value of a BYTE or UNDEFINED tag in the first IFD of
+// tiff
+
+func tiffBytes(tiff []byte, tag uint16) ([]byte, bool, error) {
+
+	order, entries, err := tiffEntries(tiff)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	entry, ok := entries[tag]
+
+	if !ok {
+		return nil, false, nil
+	}
+
+	switch order.Uint16(tiff[entry+2 : entry+4]) {
+	case 1, 7:
+		// pass
+	default:
+		return nil, false, errors.New("Unsupported TIFF tag type")
+	}
+
+	count := int(order.Uint32(tiff[entry+4 : entry+8]))
+	offset := entry + 8
+
+	if count > 4 {
+		offset = int(order.Uint32(tiff[entry+8 : entry+12]))
+	}
+
+	if count < 0 || offset < 0 || offset+count > len(tiff) {
+		return nil, false, errors.New("Truncated TIFF tag")
+	}
+
+	return tiff[offset : offset+count], true, nil
+}
+
+// Orient returns a copy of im transformed so that it displays the right way
+// up, given its EXIF orientation. All eight orientations, including the
+// mirrored ones, are supported.
+
+func Orient(im image.Image, orientation int) image.Image {
+
+	if orientation < 2 || orientation > 8 {
+		return im
+	}
+
+	dims := im.Bounds()
+
+	w := dims.Dx()
+	h := dims.Dy()
+
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), im, dims.Min, draw.Src)
+
+	out_w := w
+	out_h := h
+
+	if orientation >= 5 {
+		out_w = h
+		out_h = w
+	}
+
+	dest := image.NewNRGBA(image.Rect(0, 0, out_w, out_h))
+
+	for y := 0; y < out_h; y++ {
+
+		for x := 0; x < out_w; x++ {
+
+			var sx, sy int
+
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+
+			s := src.PixOffset(sx, sy)
+			d := dest.PixOffset(x, y)
+
+			copy(dest.Pix[d:d+4], src.Pix[s:s+4])
+		}
+	}
+
+	return dest
+}
@@ -0,0 +1,83 @@
+package util
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestOrient(t *testing.T) {
+
+	red := color.NRGBA{255, 0, 0, 255}
+	green := color.NRGBA{0, 255, 0, 255}
+
+	// a 3 x 2 image with a red pixel in the top left corner and a green one
+	// below it, so that every rotation and mirror ends up somewhere different
+
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	src.Set(0, 0, red)
+	src.Set(0, 1, green)
+
+	tests := []struct {
+		orientation int
+		w           int
+		h           int
+		red         image.Point
+		green       image.Point
+	}{
+		{0, 3, 2, image.Pt(0, 0), image.Pt(0, 1)},
+		{1, 3, 2, image.Pt(0, 0), image.Pt(0, 1)},
+		{2, 3, 2, image.Pt(2, 0), image.Pt(2, 1)},
+		{3, 3, 2, image.Pt(2, 1), image.Pt(2, 0)},
+		{4, 3, 2, image.Pt(0, 1), image.Pt(0, 0)},
+		{5, 2, 3, image.Pt(0, 0), image.Pt(1, 0)},
+		{6, 2, 3, image.Pt(1, 0), image.Pt(0, 0)},
+		{7, 2, 3, image.Pt(1, 2), image.Pt(0, 2)},
+		{8, 2, 3, image.Pt(0, 2), image.Pt(1, 2)},
+		{9, 3, 2, image.Pt(0, 0), image.Pt(0, 1)},
+	}
+
+	for _, test := range tests {
+
+		im := Orient(src, test.orientation)
+		dims := im.Bounds()
+
+		if dims.Dx() != test.w || dims.Dy() != test.h {
+			t.Errorf("Orientation %d: expected %d x %d, got %d x %d", test.orientation, test.w, test.h, dims.Dx(), dims.Dy())
+			continue
+		}
+
+		for _, p := range []struct {
+			pt image.Point
+			c  color.NRGBA
+		}{{test.red, red}, {test.green, green}} {
+
+			got := color.NRGBAModel.Convert(im.At(dims.Min.X+p.pt.X, dims.Min.Y+p.pt.Y)).(color.NRGBA)
+
+			if got != p.c {
+				t.Errorf("Orientation %d: expected %v at %v, got %v", test.orientation, p.c, p.pt, got)
+			}
+		}
+	}
+}
+
+func TestOrientOffset(t *testing.T) {
+
+	// images whose bounds don't start at 0,0 (like a SubImage) come out
+	// starting at 0,0
+
+	src := image.NewNRGBA(image.Rect(10, 20, 13, 22))
+	src.Set(10, 20, color.NRGBA{255, 0, 0, 255})
+
+	im := Orient(src, 6)
+
+	if im.Bounds() != image.Rect(0, 0, 2, 3) {
+		t.Fatalf("Expected bounds (0,0)-(2,3), got %v", im.Bounds())
+	}
+
+	r, _, _, _ := im.At(1, 0).RGBA()
+
+	if r != 0xffff {
+		t.Errorf("Expected the red pixel at 1,0")
+	}
+}
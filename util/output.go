@@ -0,0 +1,462 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OutputOptions control where the images derived from a source image are
+// written and what they are called. Template is a filename with any of the
+// following variables:
+//
+//	{dir}    the directory the image is written to (see below)
+//	{stem}   the filename of the source image without its extension
+//	{op}     the operation, for example "crop" or "atkinson"
+//	{w}      the width of the new image
+//	{h}      the height of the new image
+//	{ext}    the extension for the new image's format, without the "."
+//	{format} the new image's format, for example "jpeg"
+//
+// so the default, "{stem}-{op}.{ext}", turns "foo.jpg" in to "foo-crop.jpg".
+// Relative paths are relative to {dir}, which is the directory of the source
+// image or, if there is one, Dir. If Mirror is true images are written to the
+// same subdirectory of Dir that they were found in, below the directory (or
+// archive) named on the command line.
+//
+// Overwrite is what happens when a file already exists: "replace" it, "skip"
+// writing it or return an "error". Whatever the policy, writing the same file
+// twice in one run is always an error.
+
+type OutputOptions struct {
+	Dir       string
+	Mirror    bool
+	Template  string
+	Overwrite string
+	written   *outputPaths
+}
+
+// outputPaths is the set of paths that have been written (or are being
+// written) so far, shared by every copy of the OutputOptions it belongs to
+
+type outputPaths struct {
+	mutex *sync.Mutex
+	paths map[string]bool
+}
+
+var re_template = regexp.MustCompile(`\{([a-z]+)\}`)
+
+var template_vars = []string{"dir", "stem", "op", "w", "h", "ext", "format"}
+
+var overwrite_policies = []string{"replace", "skip", "error"}
+
+func NewDefaultOutputOptions() OutputOptions {
+
+	written := outputPaths{
+		mutex: new(sync.Mutex),
+		paths: make(map[string]bool),
+	}
+
+	opts := OutputOptions{
+		Template:  "{stem}-{op}.{ext}",
+		Overwrite: "replace",
+		written:   &written,
+	}
+
+	return opts
+}
+
+// Validate returns an error if the template contains a variable we don't know
+// about or the overwrite policy is not one of the ones above
+
+func (o OutputOptions) Validate() error {
+
+	if o.Template == "" {
+		return errors.New("Missing output template")
+	}
+
+	for _, m := range re_template.FindAllStringSubmatch(o.Template, -1) {
+
+		if !stringsContain(template_vars, m[1]) {
+			return fmt.Errorf("Invalid output template variable '%s'", m[0])
+		}
+	}
+
+	if !stringsContain(overwrite_policies, o.Overwrite) {
+		return fmt.Errorf("Invalid overwrite policy '%s'", o.Overwrite)
+	}
+
+	return nil
+}
+
+// Path returns the path for the image, w by h pixels in format, derived from
+// the image at src_path by op. rel_path is the (slash separated) path of the
+// source image below whatever was named on the command line, for Mirror; if
+// it's empty the image is written directly to Dir.
+
+func (o OutputOptions) Path(src_path string, rel_path string, op string, format string, w int, h int) (string, error) {
+
+	dir := filepath.Dir(src_path)
+
+	if o.Dir != "" {
+
+		abs_dir, err := filepath.Abs(o.Dir)
+
+		if err != nil {
+			return "", err
+		}
+
+		dir = abs_dir
+
+		if o.Mirror && rel_path != "" {
+			dir = filepath.Join(dir, filepath.FromSlash(path.Dir(rel_path)))
+		}
+	}
+
+	fname := filepath.Base(src_path)
+	stem := strings.TrimSuffix(fname, filepath.Ext(fname))
+
+	// keep the source extension if it's already right for format, the same
+	// as ReplaceExtension
+
+	ext := filepath.Ext(ReplaceExtension(fname, format))
+
+	vars := map[string]string{
+		"dir":    dir,
+		"stem":   stem,
+		"op":     op,
+		"w":      strconv.Itoa(w),
+		"h":      strconv.Itoa(h),
+		"ext":    strings.TrimPrefix(ext, "."),
+		"format": format,
+	}
+
+	var missing string
+
+	new_path := re_template.ReplaceAllStringFunc(o.Template, func(v string) string {
+
+		value, ok := vars[v[1:len(v)-1]]
+
+		if !ok {
+			missing = v
+		}
+
+		return value
+	})
+
+	if missing != "" {
+		return "", fmt.Errorf("Invalid output template variable '%s'", missing)
+	}
+
+	new_path = filepath.FromSlash(new_path)
+
+	if !filepath.IsAbs(new_path) {
+		new_path = filepath.Join(dir, new_path)
+	}
+
+	return new_path, nil
+}
+
+// Pattern returns a regular expression matching the filenames that Template
+// produces for op, which is itself a regular expression, so that the images
+// written by one run can be left out of the next (see WalkOptions). It returns
+// nil if the template doesn't include {op}, or puts it in a directory name,
+// since there is then no telling new images from any others.
+
+func (o OutputOptions) Pattern(op string) *regexp.Regexp {
+
+	fname := path.Base(filepath.ToSlash(o.Template))
+
+	if !strings.Contains(fname, "{op}") || strings.Contains(fname, "{dir}") {
+		return nil
+	}
+
+	vars := map[string]string{
+		"stem":   ".+",
+		"op":     "(?:" + op + ")",
+		"w":      "[0-9]+",
+		"h":      "[0-9]+",
+		"ext":    "[a-zA-Z0-9]+",
+		"format": "[a-z]+",
+	}
+
+	pattern := ""
+	last := 0
+
+	for _, m := range re_template.FindAllStringSubmatchIndex(fname, -1) {
+
+		pattern += regexp.QuoteMeta(fname[last:m[0]]) + vars[fname[m[2]:m[3]]]
+		last = m[1]
+	}
+
+	pattern += regexp.QuoteMeta(fname[last:])
+
+	return regexp.MustCompile("^" + pattern + "$")
+}
+
+// Check applies the overwrite policy to path, returning false if it should
+// not be written, and makes sure that its directory exists. Stdout ("-") is
+// always written. It returns a DuplicateOutputError if path has already been
+// checked during this run (by these OutputOptions, or a copy of them).
+
+func (o OutputOptions) Check(path string) (bool, error) {
+
+	if path == "-" {
+		return true, nil
+	}
+
+	if o.written != nil {
+
+		o.written.mutex.Lock()
+
+		seen := o.written.paths[path]
+		o.written.paths[path] = true
+
+		o.written.mutex.Unlock()
+
+		if seen {
+			return false, &DuplicateOutputError{Path: path}
+		}
+	}
+
+	_, err := os.Stat(path)
+
+	if err == nil {
+
+		switch o.Overwrite {
+		case "skip":
+			return false, nil
+		case "error":
+			return false, &OutputExistsError{Path: path}
+		}
+
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// CreateOutput creates (or truncates) the file at path for writing or, if
+// path is "-", returns stdout. Closing stdout does nothing so that more than
+// one thing can be written to it.
+
+func CreateOutput(path string) (io.WriteCloser, error) {
+
+	if path == "-" {
+		return stdout{}, nil
+	}
+
+	return os.Create(path)
+}
+
+// WriteOutput calls cb to write an image to path (see CreateOutput). If cache
+// isn't nil the image is written to the cache, as key with extension ext,
+// first and then copied to path.
+
+func WriteOutput(path string, ext string, cache *Cache, key string, cb func(io.Writer) error) error {
+
+	if cache != nil {
+
+		cached_path, err := cache.Write(key, ext, cb)
+
+		if err != nil {
+			return err
+		}
+
+		return CopyFile(cached_path, path)
+	}
+
+	wr, err := CreateOutput(path)
+
+	if err != nil {
+		return err
+	}
+
+	err = cb(wr)
+
+	close_err := wr.Close()
+
+	if err == nil {
+		err = close_err
+	}
+
+	return err
+}
+
+func stringsContain(list []string, str string) bool {
+
+	for _, s := range list {
+
+		if s == str {
+			return true
+		}
+	}
+
+	return false
+}
+
+type stdout struct{}
+
+func (s stdout) Write(b []byte) (int, error) {
+	return os.Stdout.Write(b)
+}
+
+func (s stdout) Close() error {
+	return nil
+}
+
+// OutputWriter writes the images derived from source images according to
+// Options or, if File isn't empty, to File (which may be "-" for stdout). Op
+// is the main operation, the one that File is for; images for any other
+// operation (like a debugging image) are named after File, using Options. If
+// Cache isn't nil images are written to the cache and then copied.
+
+type OutputWriter struct {
+	Options OutputOptions
+	File    string
+	Op      string
+	Cache   *Cache
+	mutex   *sync.Mutex
+	written int
+}
+
+func NewOutputWriter(opts OutputOptions, file string, op string, cache *Cache) *OutputWriter {
+
+	w := OutputWriter{
+		Options: opts,
+		File:    file,
+		Op:      op,
+		Cache:   cache,
+		mutex:   new(sync.Mutex),
+	}
+
+	return &w
+}
+
+// Path returns the path for the image, w by h pixels in format, derived from f
+// (which is nil for an image read from stdin) by op. It returns an error if op
+// is the main operation and something has already been written to File, which
+// is only for a single image.
+
+func (wr *OutputWriter) Path(f *SourceFile, op string, format string, w int, h int) (string, error) {
+
+	if wr.File == "" {
+		return wr.Options.Path(f.OutputPath, f.RelPath, op, format, w, h)
+	}
+
+	if op != wr.Op {
+		return wr.Options.Path(wr.File, "", op, format, w, h)
+	}
+
+	wr.mutex.Lock()
+	defer wr.mutex.Unlock()
+
+	if wr.written > 0 {
+		return "", errors.New("-output can only be used with a single image")
+	}
+
+	wr.written += 1
+	return wr.File, nil
+}
+
+// Write applies the overwrite policy to path and then calls cb to write the
+// image, in format, there. If key isn't empty (and there is a Cache) the image
+// is cached as key.
+
+func (wr *OutputWriter) Write(path string, format string, key string, cb func(io.Writer) error) error {
+
+	ok, err := wr.Options.Check(path)
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		log.Printf("%s already exists, skipping\n", path)
+		return nil
+	}
+
+	var cache *Cache
+
+	if key != "" {
+		cache = wr.Cache
+	}
+
+	return WriteOutput(path, FormatExtension(format), cache, key, cb)
+}
+
+// WriteImage writes im to path (see Write)
+
+func (wr *OutputWriter) WriteImage(path string, im image.Image, format string, key string, opts EncodeOptions) error {
+
+	return wr.Write(path, format, key, func(w io.Writer) error {
+		return EncodeImageWithOptions(im, format, w, opts)
+	})
+}
+
+// WriteAnimation writes anim to path (see Write)
+
+func (wr *OutputWriter) WriteAnimation(path string, anim *Animation, key string, opts EncodeOptions) error {
+
+	return wr.Write(path, "gif", key, func(w io.Writer) error {
+		return EncodeAnimationWithOptions(anim, w, opts)
+	})
+}
+
+// WriteCached writes the image cached as key, derived from f by op, if there
+// is one and returns true. Otherwise it returns false and the image should be
+// made and written as usual.
+
+func (wr *OutputWriter) WriteCached(f *SourceFile, op string, key string) (bool, error) {
+
+	if wr.Cache == nil {
+		return false, nil
+	}
+
+	cached_path, ok := wr.Cache.Lookup(key)
+
+	if !ok {
+		return false, nil
+	}
+
+	cfg, format, err := DecodeConfig(cached_path)
+
+	if err != nil {
+		return false, err
+	}
+
+	path, err := wr.Path(f, op, format, cfg.Width, cfg.Height)
+
+	if err != nil {
+		return false, err
+	}
+
+	ok, err = wr.Options.Check(path)
+
+	if err != nil {
+		return false, err
+	}
+
+	if !ok {
+		log.Printf("%s already exists, skipping\n", path)
+		return true, nil
+	}
+
+	return true, CopyFile(cached_path, path)
+}
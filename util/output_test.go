@@ -0,0 +1,234 @@
+package util
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputOptionsPath(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		template string
+		dir      string
+		mirror   bool
+		src      string
+		rel      string
+		format   string
+		expected string
+	}{
+		{"default", "", "", false, "/photos/a.jpg", "photos/a.jpg", "jpeg", "/photos/a-crop.jpg"},
+		{"new format", "", "", false, "/photos/a.jpg", "photos/a.jpg", "png", "/photos/a-crop.png"},
+		{"keep extension", "", "", false, "/photos/a.JPEG", "photos/a.JPEG", "jpeg", "/photos/a-crop.JPEG"},
+		{"webp", "", "", false, "/photos/a.webp", "photos/a.webp", "png", "/photos/a-crop.png"},
+		{"dir", "", "/out", false, "/photos/2016/a.jpg", "photos/2016/a.jpg", "jpeg", "/out/a-crop.jpg"},
+		{"mirror", "", "/out", true, "/photos/2016/a.jpg", "photos/2016/a.jpg", "jpeg", "/out/photos/2016/a-crop.jpg"},
+		{"mirror single file", "", "/out", true, "/photos/2016/a.jpg", "", "jpeg", "/out/a-crop.jpg"},
+		{"mirror without dir", "", "", true, "/photos/2016/a.jpg", "photos/2016/a.jpg", "jpeg", "/photos/2016/a-crop.jpg"},
+		{"size", "{w}x{h}/{stem}.{ext}", "", false, "/photos/a.jpg", "a.jpg", "jpeg", "/photos/200x100/a.jpg"},
+		{"dir variable", "{dir}/{op}/{stem}.{format}", "", false, "/photos/a.jpg", "a.jpg", "jpeg", "/photos/crop/a.jpeg"},
+		{"absolute", "/tmp/{stem}-{op}.{ext}", "/out", false, "/photos/a.jpg", "a.jpg", "jpeg", "/tmp/a-crop.jpg"},
+		{"no op", "{stem}.{ext}", "", false, "/photos/a.jpg", "a.jpg", "png", "/photos/a.png"},
+		{"dots in stem", "", "", false, "/photos/a.b.c.jpg", "a.b.c.jpg", "jpeg", "/photos/a.b.c-crop.jpg"},
+	}
+
+	for _, test := range tests {
+
+		opts := NewDefaultOutputOptions()
+		opts.Dir = test.dir
+		opts.Mirror = test.mirror
+
+		if test.template != "" {
+			opts.Template = test.template
+		}
+
+		path, err := opts.Path(test.src, test.rel, "crop", test.format, 200, 100)
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if path != filepath.FromSlash(test.expected) {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, path)
+		}
+	}
+}
+
+func TestOutputOptionsPathInvalid(t *testing.T) {
+
+	opts := NewDefaultOutputOptions()
+	opts.Template = "{stem}-{colour}.{ext}"
+
+	_, err := opts.Path("/photos/a.jpg", "a.jpg", "crop", "jpeg", 200, 100)
+
+	if err == nil {
+		t.Fatal("Expected an error for an unknown template variable")
+	}
+
+	err = opts.Validate()
+
+	if err == nil {
+		t.Fatal("Expected Validate to reject an unknown template variable")
+	}
+}
+
+func TestOutputOptionsPattern(t *testing.T) {
+
+	tests := []struct {
+		template string
+		op       string
+		name     string
+		match    bool
+	}{
+		{"{stem}-{op}.{ext}", "crop", "a-crop.jpg", true},
+		{"{stem}-{op}.{ext}", "crop", "a.b-crop.png", true},
+		{"{stem}-{op}.{ext}", "crop", "a.jpg", false},
+		{"{stem}-{op}.{ext}", "crop", "a-cropped.jpg", false},
+		{"{stem}-{op}.{ext}", "crop", "crop.jpg", false},
+		{"{stem}-{op}.{ext}", "crop(-[a-z0-9_\\-]+)?", "a-crop-debug.jpg", true},
+		{"{stem}-{op}.{ext}", "crop(-[a-z0-9_\\-]+)?", "a-crop-face-1.jpg", true},
+		{"{stem}-{op}.{ext}", "atkinson", "a-crop.jpg", false},
+		{"{w}x{h}/{stem}_{op}.{format}", "crop", "a_crop.jpeg", true},
+		{"{w}x{h}/{stem}_{op}.{format}", "crop", "a-crop.jpeg", false},
+		{"{stem}-{op}-{w}x{h}.{ext}", "crop", "a-crop-200x100.jpg", true},
+		{"{stem}-{op}-{w}x{h}.{ext}", "crop", "a-crop-200xbig.jpg", false},
+		{"{dir}/{stem}-{op}.{ext}", "crop", "a-crop.jpg", true},
+	}
+
+	for _, test := range tests {
+
+		opts := NewDefaultOutputOptions()
+		opts.Template = test.template
+
+		re := opts.Pattern(test.op)
+
+		if re == nil {
+			t.Errorf("%s: expected a pattern", test.template)
+			continue
+		}
+
+		if re.MatchString(test.name) != test.match {
+			t.Errorf("%s (%s): expected %s to match %t", test.template, re, test.name, test.match)
+		}
+	}
+
+	// templates without {op} in the filename can't tell new images from
+	// old ones
+
+	for _, template := range []string{"{stem}.{ext}", "{op}/{stem}.{ext}", "{stem}.{op}/{stem}.{ext}"} {
+
+		opts := NewDefaultOutputOptions()
+		opts.Template = template
+
+		if opts.Pattern("crop") != nil {
+			t.Errorf("%s: expected no pattern", template)
+		}
+	}
+}
+
+func TestOutputOptionsCheck(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "output-test-")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	existing := filepath.Join(dir, "existing.jpg")
+
+	err = ioutil.WriteFile(existing, []byte("hello"), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		overwrite string
+		path      string
+		ok        bool
+		err       error
+	}{
+		{"replace", existing, true, nil},
+		{"skip", existing, false, nil},
+		{"error", existing, false, ErrOutputExists},
+		{"error", filepath.Join(dir, "new.jpg"), true, nil},
+		{"skip", filepath.Join(dir, "sub", "new.jpg"), true, nil},
+		{"error", "-", true, nil},
+	}
+
+	for _, test := range tests {
+
+		opts := NewDefaultOutputOptions()
+		opts.Overwrite = test.overwrite
+
+		ok, err := opts.Check(test.path)
+
+		if ok != test.ok || !errors.Is(err, test.err) {
+			t.Errorf("%s %s: expected %t, %v, got %t, %v", test.overwrite, test.path, test.ok, test.err, ok, err)
+		}
+	}
+
+	// Check makes the directory for new files
+
+	_, err = os.Stat(filepath.Join(dir, "sub"))
+
+	if err != nil {
+		t.Errorf("Expected Check to create the output directory: %v", err)
+	}
+}
+
+func TestOutputOptionsCheckDuplicate(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "output-test-")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	opts := NewDefaultOutputOptions()
+	path := filepath.Join(dir, "a-crop.jpg")
+
+	ok, err := opts.Check(path)
+
+	if !ok || err != nil {
+		t.Fatalf("Expected the first check to succeed, got %t, %v", ok, err)
+	}
+
+	// copies of the options, like the ones passed to functions by value,
+	// share the paths that have been written
+
+	cp := opts
+
+	ok, err = cp.Check(path)
+
+	if ok || !errors.Is(err, ErrDuplicateOutput) {
+		t.Fatalf("Expected a duplicate output error, got %t, %v", ok, err)
+	}
+
+	// but stdout can be written to any number of times
+
+	for i := 0; i < 2; i++ {
+
+		ok, err = opts.Check("-")
+
+		if !ok || err != nil {
+			t.Fatalf("Expected stdout to be writable, got %t, %v", ok, err)
+		}
+	}
+
+	// and separate options don't know about each other
+
+	ok, err = NewDefaultOutputOptions().Check(path)
+
+	if !ok || err != nil {
+		t.Fatalf("Expected new options to be able to write %s, got %t, %v", path, ok, err)
+	}
+}
@@ -0,0 +1,194 @@
+package util
+
+// https://en.wikipedia.org/wiki/Median_cut
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// PaletteQuantizer returns (up to the number of colours requested) a fixed
+// palette regardless of the image being encoded.
+
+type PaletteQuantizer struct {
+	Palette color.Palette
+}
+
+func (q PaletteQuantizer) Quantize(p color.Palette, im image.Image) color.Palette {
+
+	for _, c := range q.Palette {
+
+		if len(p) == cap(p) {
+			break
+		}
+
+		p = append(p, c)
+	}
+
+	return p
+}
+
+// MedianCutQuantizer returns a palette derived from the colours in the image
+// being encoded by repeatedly splitting the box of colours with the widest
+// range in half, at its median, until there are as many boxes as colours
+// requested. Large images are sampled rather than read pixel by pixel.
+
+type MedianCutQuantizer struct{}
+
+// the maximum number of pixels sampled when building a palette
+
+const quantize_max_samples = 65536
+
+type colourBox []color.NRGBA
+
+func (q MedianCutQuantizer) Quantize(p color.Palette, im image.Image) color.Palette {
+
+	count := cap(p) - len(p)
+
+	if count <= 0 {
+		return p
+	}
+
+	dims := im.Bounds()
+
+	step := 1
+
+	for (dims.Dx()/step)*(dims.Dy()/step) > quantize_max_samples {
+		step += 1
+	}
+
+	pixels := make(colourBox, 0)
+
+	for y := dims.Min.Y; y < dims.Max.Y; y += step {
+
+		for x := dims.Min.X; x < dims.Max.X; x += step {
+			c := color.NRGBAModel.Convert(im.At(x, y)).(color.NRGBA)
+			pixels = append(pixels, c)
+		}
+	}
+
+	if len(pixels) == 0 {
+		return p
+	}
+
+	boxes := []colourBox{pixels}
+
+	for len(boxes) < count {
+
+		idx := -1
+		widest := 0
+
+		for i, b := range boxes {
+
+			if len(b) < 2 {
+				continue
+			}
+
+			_, r := b.widestChannel()
+
+			if r > widest {
+				idx = i
+				widest = r
+			}
+		}
+
+		// every remaining box is a single colour
+		if idx == -1 {
+			break
+		}
+
+		b := boxes[idx]
+		ch, _ := b.widestChannel()
+
+		sort.Slice(b, func(i, j int) bool {
+			return channel(b[i], ch) < channel(b[j], ch)
+		})
+
+		mid := len(b) / 2
+
+		boxes[idx] = b[:mid]
+		boxes = append(boxes, b[mid:])
+	}
+
+	for _, b := range boxes {
+		p = append(p, b.average())
+	}
+
+	return p
+}
+
+// widestChannel returns the channel (0 - 3, for R, G, B and A) with the
+// largest range of values in b, and that range
+
+func (b colourBox) widestChannel() (int, int) {
+
+	lo := [4]int{255, 255, 255, 255}
+	hi := [4]int{0, 0, 0, 0}
+
+	for _, c := range b {
+
+		for ch := 0; ch < 4; ch++ {
+
+			v := channel(c, ch)
+
+			if v < lo[ch] {
+				lo[ch] = v
+			}
+
+			if v > hi[ch] {
+				hi[ch] = v
+			}
+		}
+	}
+
+	widest := 0
+	r := -1
+
+	for ch := 0; ch < 4; ch++ {
+
+		if hi[ch]-lo[ch] > r {
+			widest = ch
+			r = hi[ch] - lo[ch]
+		}
+	}
+
+	return widest, r
+}
+
+func (b colourBox) average() color.Color {
+
+	var r, g, bl, a int
+
+	for _, c := range b {
+		r += int(c.R)
+		g += int(c.G)
+		bl += int(c.B)
+		a += int(c.A)
+	}
+
+	n := len(b)
+
+	c := color.NRGBA{
+		R: uint8(r / n),
+		G: uint8(g / n),
+		B: uint8(bl / n),
+		A: uint8(a / n),
+	}
+
+	return c
+}
+
+func channel(c color.NRGBA, ch int) int {
+
+	switch ch {
+	case 0:
+		return int(c.R)
+	case 1:
+		return int(c.G)
+	case 2:
+		return int(c.B)
+	default:
+		return int(c.A)
+	}
+}
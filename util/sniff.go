@@ -0,0 +1,85 @@
+package util
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+)
+
+// https://en.wikipedia.org/wiki/List_of_file_signatures
+
+// sniff_length is the number of bytes SniffFormat needs to see
+
+const sniff_length = 16
+
+// SniffFormat returns the format of the image in body, going by its first few
+// bytes, using the same names as image.Decode. It returns "" if it doesn't
+// recognize the format.
+
+func SniffFormat(body []byte) string {
+
+	switch {
+	case bytes.HasPrefix(body, []byte("\xff\xd8\xff")):
+		return "jpeg"
+	case bytes.HasPrefix(body, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(body, []byte("GIF87a")), bytes.HasPrefix(body, []byte("GIF89a")):
+		return "gif"
+	case bytes.HasPrefix(body, []byte("II*\x00")), bytes.HasPrefix(body, []byte("MM\x00*")):
+		return "tiff"
+	case bytes.HasPrefix(body, []byte("BM")):
+		return "bmp"
+	case len(body) >= 12 && string(body[0:4]) == "RIFF" && string(body[8:12]) == "WEBP":
+		return "webp"
+	}
+
+	return ""
+}
+
+// ExtensionFormat returns the format that a file with extension ext (with or
+// without the leading ".") is expected to be, or "" if it's not an image
+// extension we know about.
+
+func ExtensionFormat(ext string) string {
+
+	ext = strings.ToLower(ext)
+
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+
+	for format, aliases := range format_aliases {
+
+		for _, alias := range aliases {
+
+			if ext == alias {
+				return format
+			}
+		}
+	}
+
+	return ""
+}
+
+// CheckExtension returns a MismatchError if the extension of path says one
+// thing and the first bytes of the file (body) say another. Files with no, or
+// an unfamiliar, extension and images whose format can't be sniffed are not
+// considered mismatches.
+
+func CheckExtension(path string, body []byte) error {
+
+	ext := filepath.Ext(path)
+	expected := ExtensionFormat(ext)
+
+	if expected == "" {
+		return nil
+	}
+
+	actual := SniffFormat(body)
+
+	if actual == "" || actual == expected {
+		return nil
+	}
+
+	return &MismatchError{Path: path, Extension: ext, Format: actual}
+}
@@ -0,0 +1,113 @@
+package util
+
+import (
+	"errors"
+	"testing"
+)
+
+var sniff_tests = []struct {
+	name   string
+	body   []byte
+	format string
+}{
+	{"jpeg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF"), "jpeg"},
+	{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "png"},
+	{"gif87a", []byte("GIF87a\x01\x00\x01\x00"), "gif"},
+	{"gif89a", []byte("GIF89a\x01\x00\x01\x00"), "gif"},
+	{"tiff little endian", []byte("II*\x00\x08\x00\x00\x00"), "tiff"},
+	{"tiff big endian", []byte("MM\x00*\x00\x00\x00\x08"), "tiff"},
+	{"bmp", []byte("BM\x3a\x00\x00\x00"), "bmp"},
+	{"webp", []byte("RIFF\x24\x00\x00\x00WEBPVP8 "), "webp"},
+	{"riff but not webp", []byte("RIFF\x24\x00\x00\x00WAVEfmt "), ""},
+	{"short riff", []byte("RIFF\x24\x00"), ""},
+	{"text", []byte("hello world"), ""},
+	{"empty", []byte{}, ""},
+	{"nil", nil, ""},
+}
+
+func TestSniffFormat(t *testing.T) {
+
+	for _, test := range sniff_tests {
+
+		format := SniffFormat(test.body)
+
+		if format != test.format {
+			t.Errorf("%s: expected '%s', got '%s'", test.name, test.format, format)
+		}
+	}
+}
+
+func TestExtensionFormat(t *testing.T) {
+
+	tests := []struct {
+		ext    string
+		format string
+	}{
+		{".jpg", "jpeg"},
+		{".JPG", "jpeg"},
+		{"jpeg", "jpeg"},
+		{".jpe", "jpeg"},
+		{".png", "png"},
+		{".tif", "tiff"},
+		{".tiff", "tiff"},
+		{".webp", "webp"},
+		{".txt", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+
+		format := ExtensionFormat(test.ext)
+
+		if format != test.format {
+			t.Errorf("%s: expected '%s', got '%s'", test.ext, test.format, format)
+		}
+	}
+}
+
+func TestCheckExtension(t *testing.T) {
+
+	jpeg := []byte("\xff\xd8\xff\xe0\x00\x10JFIF")
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	tests := []struct {
+		path     string
+		body     []byte
+		mismatch bool
+	}{
+		{"foo.jpg", jpeg, false},
+		{"foo.JPEG", jpeg, false},
+		{"foo.png", png, false},
+		{"foo.jpg", png, true},
+		{"foo.png", jpeg, true},
+		{"foo.gif", jpeg, true},
+		{"foo", png, false},
+		{"foo.txt", png, false},
+		{"foo.jpg", []byte("not an image"), false},
+	}
+
+	for _, test := range tests {
+
+		err := CheckExtension(test.path, test.body)
+
+		if !test.mismatch {
+
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.path, err)
+			}
+
+			continue
+		}
+
+		if !errors.Is(err, ErrFormatMismatch) {
+			t.Errorf("%s: expected a mismatch, got %v", test.path, err)
+			continue
+		}
+
+		var mismatch *MismatchError
+
+		if !errors.As(err, &mismatch) || mismatch.Format != SniffFormat(test.body) {
+			t.Errorf("%s: expected a MismatchError for '%s', got %v", test.path, SniffFormat(test.body), err)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package util
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Source is somewhere to read images from: a directory (or a single file) on
+// disk, a zip file or a tar (or tar.gz) file. Either way the images are read
+// from FS, starting at Root, so that anything else that needs to read files
+// from the same place, like sidecar files for captions, can do so too.
+
+type Source struct {
+	FS     fs.FS
+	Root   string
+	Name   string
+	dir    string
+	closer io.Closer
+}
+
+// archive_extensions are the (lower case) extensions of the archive formats
+// OpenSource knows how to read
+
+var archive_extensions = []string{".zip", ".tar", ".tar.gz", ".tgz"}
+
+// OpenSource returns a Source for the directory, file or archive at src_path
+
+func OpenSource(src_path string) (*Source, error) {
+
+	abs_path, err := filepath.Abs(src_path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(abs_path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() && IsArchive(abs_path) {
+		return openArchive(abs_path)
+	}
+
+	// the FS starts at the parent directory, rather than the directory
+	// itself, so that the parent directory of an image is always part of
+	// its path; see for example functions.FilenameAndParentCaptionFunc
+
+	target := abs_path
+
+	if !info.IsDir() {
+		target = filepath.Dir(abs_path)
+	}
+
+	parent := filepath.Dir(target)
+	root := filepath.Base(target)
+
+	if parent == target {
+		root = "."
+	}
+
+	if !info.IsDir() {
+		root = path.Join(root, filepath.Base(abs_path))
+	}
+
+	s := Source{
+		FS:   os.DirFS(parent),
+		Root: filepath.ToSlash(root),
+		Name: abs_path,
+		dir:  parent,
+	}
+
+	return &s, nil
+}
+
+// NewSource returns a Source for the files in fsys, starting at root. name is
+// used to describe where the files came from, in paths returned by Path.
+
+func NewSource(fsys fs.FS, root string, name string) *Source {
+
+	s := Source{
+		FS:   fsys,
+		Root: root,
+		Name: name,
+	}
+
+	return &s
+}
+
+// IsArchive returns true if path is a zip or tar file, going by its extension
+
+func IsArchive(path string) bool {
+	return archiveExtension(path) != ""
+}
+
+func (s *Source) Close() error {
+
+	if s.closer == nil {
+		return nil
+	}
+
+	return s.closer.Close()
+}
+
+// Walk calls cb with the (FS) path of every file in the source. Files in tar
+// archives are listed in the order they appear in the archive, since that's
+// the order they can be read in quickly, and everything else in lexical order.
+
+func (s *Source) Walk(cb func(name string) error) error {
+
+	opts := NewDefaultWalkOptions()
+	return s.walk(opts, cb)
+}
+
+// WalkWithOptions is like Walk but only calls cb for the files that opts
+// (see WalkOptions) say should be included
+
+func (s *Source) WalkWithOptions(opts WalkOptions, cb func(name string) error) error {
+
+	return s.walk(opts, func(name string) error {
+
+		if !opts.Match(s, name) {
+			return nil
+		}
+
+		return cb(name)
+	})
+}
+
+func (s *Source) walk(opts WalkOptions, cb func(name string) error) error {
+
+	t, ok := s.FS.(*TarFS)
+
+	if ok {
+
+		for _, name := range t.Files() {
+
+			if s.Root != "." && name != s.Root && !strings.HasPrefix(name, s.Root+"/") {
+				continue
+			}
+
+			if !opts.Recursive && name != s.Root && path.Dir(name) != s.Root {
+				continue
+			}
+
+			err := cb(name)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	info, err := fs.Stat(s.FS, s.Root)
+
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return cb(s.Root)
+	}
+
+	visited := make(map[string]bool)
+	return s.walkFiles(s.Root, opts, visited, cb)
+}
+
+// Path returns a path for name which is suitable for showing to people. For
+// files on disk it's the absolute path of the file and for files in archives
+// it's the path of the archive followed by the path in the archive.
+
+func (s *Source) Path(name string) string {
+
+	if s.dir != "" {
+		return filepath.Join(s.dir, filepath.FromSlash(name))
+	}
+
+	return filepath.Join(s.Name, filepath.FromSlash(name))
+}
+
+// IsLocal returns true if the files in the source are files on disk
+
+func (s *Source) IsLocal() bool {
+	return s.dir != ""
+}
+
+// LocalPath returns the path of a file on disk with the contents of name.
+// That's the file itself if it's already on disk; otherwise it's copied to
+// temp, keeping its extension, and should be released once it's not needed.
+
+func (s *Source) LocalPath(name string, temp *TempStore) (string, error) {
+
+	if s.dir != "" {
+		return s.Path(name), nil
+	}
+
+	src, err := s.FS.Open(name)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer src.Close()
+
+	fh, err := temp.CreateWithExtension(path.Ext(name))
+
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(fh, src)
+
+	close_err := fh.Close()
+
+	if err == nil {
+		err = close_err
+	}
+
+	if err != nil {
+		temp.Release(fh.Name())
+		return "", err
+	}
+
+	return fh.Name(), nil
+}
+
+// OutputPath returns where a file derived from name should be written. For
+// files on disk that's next to the file itself. For files in archives, which
+// can't be written to, it's a directory next to the archive named after it
+// (so "photos/a.jpg" in "export.zip" becomes "export/photos/a.jpg").
+
+func (s *Source) OutputPath(name string) string {
+
+	if s.dir != "" {
+		return s.Path(name)
+	}
+
+	ext := archiveExtension(s.Name)
+	root := s.Name[0 : len(s.Name)-len(ext)]
+
+	return filepath.Join(root, filepath.FromSlash(name))
+}
+
+// RelPath returns the path of name below the directory, or archive, that was
+// opened, including the name of the directory (or archive) itself, so that
+// "photos/2016/a.jpg" in "photos" is "photos/2016/a.jpg" and "a.jpg" in
+// "export.zip" is "export/a.jpg". A single file is just its filename.
+
+func (s *Source) RelPath(name string) string {
+
+	if name == s.Root {
+		return path.Base(name)
+	}
+
+	if s.dir == "" {
+		ext := archiveExtension(s.Name)
+		stem := filepath.Base(s.Name[0 : len(s.Name)-len(ext)])
+		return path.Join(stem, name)
+	}
+
+	parent := path.Dir(s.Root)
+
+	if parent == "." {
+		return name
+	}
+
+	return strings.TrimPrefix(name, parent+"/")
+}
+
+// SourceFile is a file found by WalkSources. OutputPath is where the images
+// derived from it go by default and RelPath is its path below whatever was
+// named on the command line (see Source.RelPath and OutputOptions).
+
+type SourceFile struct {
+	Source     *Source
+	Name       string
+	Path       string
+	OutputPath string
+	RelPath    string
+}
+
+// WalkSources calls cb for every image in paths, each of which may be a file,
+// a directory or an archive (see OpenSource). Files inside directories and
+// archives are only included if they have an image extension; files named
+// explicitly are always included. Path is always a file on disk, copied to
+// temp if necessary and removed again after cb returns.
+
+func WalkSources(paths []string, temp *TempStore, cb func(*SourceFile) error) error {
+
+	opts := NewDefaultWalkOptions()
+	return WalkSourcesWithOptions(paths, temp, opts, cb)
+}
+
+// WalkSourcesWithOptions is like WalkSources, finding images according to
+// opts (see WalkOptions)
+
+func WalkSourcesWithOptions(paths []string, temp *TempStore, opts WalkOptions, cb func(*SourceFile) error) error {
+
+	for _, p := range paths {
+
+		src, err := OpenSource(p)
+
+		if err != nil {
+			return err
+		}
+
+		err = src.walkImages(opts, temp, cb)
+
+		src.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkImages calls cb for every image in s, as WalkSources does
+
+func (s *Source) walkImages(opts WalkOptions, temp *TempStore, cb func(*SourceFile) error) error {
+
+	walk := func(name string) error {
+
+		local_path, err := s.LocalPath(name, temp)
+
+		if err != nil {
+			return err
+		}
+
+		defer temp.Release(local_path)
+
+		f := SourceFile{
+			Source:     s,
+			Name:       name,
+			Path:       local_path,
+			OutputPath: s.OutputPath(name),
+			RelPath:    s.RelPath(name),
+		}
+
+		return cb(&f)
+	}
+
+	return s.WalkWithOptions(opts, walk)
+}
+
+func openArchive(abs_path string) (*Source, error) {
+
+	var fsys fs.FS
+	var closer io.Closer
+
+	switch archiveExtension(abs_path) {
+	case ".zip":
+
+		z, err := zip.OpenReader(abs_path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		fsys = z
+		closer = z
+
+	default:
+
+		t, err := OpenTarFS(abs_path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		fsys = t
+		closer = t
+	}
+
+	s := Source{
+		FS:     fsys,
+		Root:   ".",
+		Name:   abs_path,
+		closer: closer,
+	}
+
+	return &s, nil
+}
+
+func archiveExtension(path string) string {
+
+	lower := strings.ToLower(path)
+
+	for _, ext := range archive_extensions {
+
+		if strings.HasSuffix(lower, ext) {
+			return path[len(path)-len(ext):]
+		}
+	}
+
+	return ""
+}
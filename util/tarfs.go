@@ -0,0 +1,455 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tar_small_file is the size of the biggest file that a compressed tar file
+// keeps in memory, once it's been read, rather than reading the archive all
+// over again to find it. This is mostly for sidecar (JSON) files which are
+// read out of order.
+
+const tar_small_file = 256 * 1024
+
+// TarFS is a (read-only) fs.FS for a tar, or a gzipped tar, file. Files in an
+// uncompressed tar file are read directly from the archive. Compressed tar
+// files can only be read from start to finish so they are best read in the
+// order returned by Files; small files are kept in memory.
+
+type TarFS struct {
+	path    string
+	gzip    bool
+	fh      *os.File
+	files   []string
+	count   int
+	index   map[string]int
+	headers map[string]*tar.Header
+	offsets map[string]int64
+	dirs    map[string][]fs.DirEntry
+	small   map[string][]byte
+	mutex   *sync.Mutex
+	stream  *tarStream
+}
+
+// tarStream is a compressed tar file being read from start to finish
+
+type tarStream struct {
+	fh     *os.File
+	gz     *gzip.Reader
+	reader *tar.Reader
+	next   int
+}
+
+func OpenTarFS(tar_path string) (*TarFS, error) {
+
+	fh, err := os.Open(tar_path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	lower := strings.ToLower(tar_path)
+
+	t := TarFS{
+		path:    tar_path,
+		gzip:    strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz"),
+		fh:      fh,
+		files:   make([]string, 0),
+		index:   make(map[string]int),
+		headers: make(map[string]*tar.Header),
+		offsets: make(map[string]int64),
+		dirs:    make(map[string][]fs.DirEntry),
+		small:   make(map[string][]byte),
+		mutex:   new(sync.Mutex),
+	}
+
+	err = t.scan()
+
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// Files returns the path of every file in the archive, in the order they
+// appear in it
+
+func (t *TarFS) Files() []string {
+	return t.files
+}
+
+func (t *TarFS) Close() error {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.closeStream()
+	return t.fh.Close()
+}
+
+func (t *TarFS) Open(name string) (fs.File, error) {
+
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entries, ok := t.dirs[name]
+
+	if ok {
+
+		d := tarDir{
+			info:    tarDirInfo{name: path.Base(name)},
+			entries: entries,
+		}
+
+		return &d, nil
+	}
+
+	hdr, ok := t.headers[name]
+
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if !t.gzip {
+
+		r := io.NewSectionReader(t.fh, t.offsets[name], hdr.Size)
+		return &tarFile{info: hdr.FileInfo(), reader: r}, nil
+	}
+
+	body, err := t.read(name)
+
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &tarFile{info: hdr.FileInfo(), reader: bytes.NewReader(body)}, nil
+}
+
+// scan reads the whole archive once, recording where everything is
+
+func (t *TarFS) scan() error {
+
+	var r io.Reader = t.fh
+
+	if t.gzip {
+
+		gz, err := gzip.NewReader(t.fh)
+
+		if err != nil {
+			return err
+		}
+
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+
+	t.dirs["."] = make([]fs.DirEntry, 0)
+
+	for {
+
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		name := tarName(hdr.Name)
+
+		if name == "" {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			t.addDir(name)
+			continue
+		case tar.TypeReg:
+			// pass
+		default:
+			continue
+		}
+
+		// every file is numbered, in the order they appear, so that they
+		// can be found again when reading compressed archives
+
+		ordinal := t.count
+		t.count += 1
+
+		_, exists := t.headers[name]
+
+		if exists {
+			continue
+		}
+
+		// tar.Reader reads exactly up to the start of each file's data so
+		// for uncompressed archives that's where the file is
+
+		if !t.gzip {
+
+			offset, err := t.fh.Seek(0, io.SeekCurrent)
+
+			if err != nil {
+				return err
+			}
+
+			t.offsets[name] = offset
+
+		} else if hdr.Size <= tar_small_file {
+
+			body, err := io.ReadAll(tr)
+
+			if err != nil {
+				return err
+			}
+
+			t.small[name] = body
+		}
+
+		t.index[name] = ordinal
+		t.files = append(t.files, name)
+		t.headers[name] = hdr
+
+		parent := path.Dir(name)
+		t.addDir(parent)
+		t.dirs[parent] = append(t.dirs[parent], fs.FileInfoToDirEntry(hdr.FileInfo()))
+	}
+
+	for _, entries := range t.dirs {
+
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name() < entries[j].Name()
+		})
+	}
+
+	return nil
+}
+
+// addDir makes sure that dir, and all its parents, exist
+
+func (t *TarFS) addDir(dir string) {
+
+	if dir == "." {
+		return
+	}
+
+	_, ok := t.dirs[dir]
+
+	if ok {
+		return
+	}
+
+	t.dirs[dir] = make([]fs.DirEntry, 0)
+
+	parent := path.Dir(dir)
+	t.addDir(parent)
+
+	info := tarDirInfo{name: path.Base(dir)}
+	t.dirs[parent] = append(t.dirs[parent], fs.FileInfoToDirEntry(info))
+}
+
+// read returns the contents of name, from a compressed tar file, reading
+// forwards from wherever we are (or starting all over again if we've already
+// passed it)
+
+func (t *TarFS) read(name string) ([]byte, error) {
+
+	body, ok := t.small[name]
+
+	if ok {
+		return body, nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	target := t.index[name]
+
+	if t.stream != nil && t.stream.next > target {
+		t.closeStream()
+	}
+
+	if t.stream == nil {
+
+		fh, err := os.Open(t.path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		gz, err := gzip.NewReader(fh)
+
+		if err != nil {
+			fh.Close()
+			return nil, err
+		}
+
+		t.stream = &tarStream{
+			fh:     fh,
+			gz:     gz,
+			reader: tar.NewReader(gz),
+		}
+	}
+
+	for {
+
+		hdr, err := t.stream.reader.Next()
+
+		if err != nil {
+			t.closeStream()
+			return nil, err
+		}
+
+		if hdr.Typeflag != tar.TypeReg || tarName(hdr.Name) == "" {
+			continue
+		}
+
+		ordinal := t.stream.next
+		t.stream.next += 1
+
+		if ordinal == target {
+			return io.ReadAll(t.stream.reader)
+		}
+	}
+}
+
+func (t *TarFS) closeStream() {
+
+	if t.stream == nil {
+		return
+	}
+
+	t.stream.gz.Close()
+	t.stream.fh.Close()
+	t.stream = nil
+}
+
+// tarName cleans up the path of a file in a tar archive, which may start with
+// "./" or "/", so that it's a valid fs.FS path
+
+func tarName(name string) string {
+
+	name = path.Clean("/" + name)
+	name = strings.TrimPrefix(name, "/")
+
+	if name == "" || !fs.ValidPath(name) {
+		return ""
+	}
+
+	return name
+}
+
+type tarFile struct {
+	info   fs.FileInfo
+	reader interface {
+		io.Reader
+		io.ReaderAt
+		io.Seeker
+	}
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *tarFile) Read(b []byte) (int, error) {
+	return f.reader.Read(b)
+}
+
+func (f *tarFile) ReadAt(b []byte, off int64) (int, error) {
+	return f.reader.ReadAt(b, off)
+}
+
+func (f *tarFile) Seek(offset int64, whence int) (int64, error) {
+	return f.reader.Seek(offset, whence)
+}
+
+func (f *tarFile) Close() error {
+	return nil
+}
+
+type tarDir struct {
+	info    tarDirInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *tarDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *tarDir) Read(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *tarDir) Close() error {
+	return nil
+}
+
+func (d *tarDir) ReadDir(count int) ([]fs.DirEntry, error) {
+
+	remaining := d.entries[d.offset:]
+
+	if count <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+
+	d.offset += count
+	return remaining[:count], nil
+}
+
+type tarDirInfo struct {
+	name string
+}
+
+func (i tarDirInfo) Name() string {
+	return i.name
+}
+
+func (i tarDirInfo) Size() int64 {
+	return 0
+}
+
+func (i tarDirInfo) Mode() fs.FileMode {
+	return fs.ModeDir | 0555
+}
+
+func (i tarDirInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (i tarDirInfo) IsDir() bool {
+	return true
+}
+
+func (i tarDirInfo) Sys() interface{} {
+	return nil
+}
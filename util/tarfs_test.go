@@ -0,0 +1,34 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestTarName(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"a.jpg", "a.jpg"},
+		{"photos/a.jpg", "photos/a.jpg"},
+		{"./photos/a.jpg", "photos/a.jpg"},
+		{"/photos/a.jpg", "photos/a.jpg"},
+		{"photos/", "photos"},
+		{"photos//2016/a.jpg", "photos/2016/a.jpg"},
+		{"photos/../a.jpg", "a.jpg"},
+		{"../../etc/passwd", "etc/passwd"},
+		{"", ""},
+		{".", ""},
+		{"/", ""},
+	}
+
+	for _, test := range tests {
+
+		name := tarName(test.name)
+
+		if name != test.expected {
+			t.Errorf("'%s': expected '%s', got '%s'", test.name, test.expected, name)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package util
+
+import (
+	"errors"
+	"image"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// TempStore is a directory of temporary files, created for (and removed at the
+// end of) a single run. Files are handed out with an extension that matches
+// their format, which gofpdf needs, and are reference counted so that a file
+// can be removed as soon as nothing needs it any more. Whatever is left over is
+// removed by Close.
+
+type TempStore struct {
+	Root   string
+	mutex  *sync.Mutex
+	refs   map[string]int
+	closed bool
+}
+
+func NewTempStore(prefix string) (*TempStore, error) {
+
+	root, err := ioutil.TempDir("", prefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := TempStore{
+		Root:  root,
+		mutex: new(sync.Mutex),
+		refs:  make(map[string]int),
+	}
+
+	return &s, nil
+}
+
+// Create returns a new, empty, file in the store whose name ends with the
+// extension for format. It has a reference count of 1.
+
+func (s *TempStore) Create(format string) (*os.File, error) {
+	return s.CreateWithExtension(FormatExtension(format))
+}
+
+// CreateWithExtension is like Create for a file whose name ends with ext,
+// which should include the leading "."
+
+func (s *TempStore) CreateWithExtension(ext string) (*os.File, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, errors.New("Temp store has been closed")
+	}
+
+	fh, err := ioutil.TempFile(s.Root, "*"+ext)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.refs[fh.Name()] = 1
+	return fh, nil
+}
+
+func (s *TempStore) EncodeImage(im image.Image, format string) (string, error) {
+
+	opts := NewDefaultEncodeOptions()
+	return s.EncodeImageWithOptions(im, format, opts)
+}
+
+// EncodeImageWithOptions writes im to a new file in the store and returns its
+// path. The file is removed again if anything goes wrong.
+
+func (s *TempStore) EncodeImageWithOptions(im image.Image, format string, opts EncodeOptions) (string, error) {
+
+	fh, err := s.Create(format)
+
+	if err != nil {
+		return "", err
+	}
+
+	path := fh.Name()
+
+	err = EncodeImageWithOptions(im, format, fh, opts)
+
+	close_err := fh.Close()
+
+	if err == nil {
+		err = close_err
+	}
+
+	if err != nil {
+		s.Release(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// Owns returns true if path is a (not yet removed) file in the store
+
+func (s *TempStore) Owns(path string) bool {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, ok := s.refs[path]
+	return ok
+}
+
+// Retain adds a reference to path. Paths that aren't in the store are
+// ignored.
+
+func (s *TempStore) Retain(path string) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, ok := s.refs[path]
+
+	if ok {
+		s.refs[path] += 1
+	}
+}
+
+// Release removes a reference to path and removes the file once there are
+// none left. Paths that aren't in the store are ignored so it's safe to call
+// Release on any path.
+
+func (s *TempStore) Release(path string) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count, ok := s.refs[path]
+
+	if !ok {
+		return nil
+	}
+
+	if count > 1 {
+		s.refs[path] = count - 1
+		return nil
+	}
+
+	delete(s.refs, path)
+
+	err := os.Remove(path)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Close removes the store's directory, and everything in it, regardless of
+// any outstanding references. It's safe to call more than once.
+
+func (s *TempStore) Close() error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+	s.refs = make(map[string]int)
+
+	return os.RemoveAll(s.Root)
+}
+
+// CloseOnSignal arranges for the store to be closed, and the program to exit,
+// when it receives one of sigs (by default SIGINT or SIGTERM) since deferred
+// calls to Close don't run when a program is interrupted.
+
+func (s *TempStore) CloseOnSignal(sigs ...os.Signal) {
+
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	go func() {
+
+		<-ch
+
+		signal.Stop(ch)
+		s.Close()
+
+		os.Exit(1)
+	}()
+}
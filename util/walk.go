@@ -0,0 +1,271 @@
+package util
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// WalkOptions control which files are found in a Source (see WalkSources).
+//
+// Directories are searched all the way down unless Recursive is false, in
+// which case only the files directly inside them are found. Symlinks is what
+// happens to symbolic links in directories on disk: "files" follows links to
+// files but not to directories, "follow" follows both (visiting each actual
+// directory once, so loops are harmless) and "skip" ignores them all.
+//
+// Only files with an image extension are found or, if there are any,
+// Extensions (for example "jpg" or ".png"; "jpg" matches ".jpeg" files too).
+// Files named explicitly are always included. All of the Include regular
+// expressions, matched against the path of the file (see Source.Path), and
+// none of the Exclude ones must match. Likewise the IncludeGlob and ExcludeGlob
+// patterns, matched against the filename or, for patterns with a "/" in them,
+// the path of the file below the directory or archive (see Source.RelPath).
+//
+// Files whose names match any of Outputs, which is the images a command writes
+// (see OutputOptions.Pattern), are left out (and logged) unless they are named
+// explicitly, so that running the same thing twice doesn't process its own
+// output. There are none by default since real images can look like that too.
+
+type WalkOptions struct {
+	Recursive   bool
+	Symlinks    string
+	Extensions  []string
+	Include     []*regexp.Regexp
+	Exclude     []*regexp.Regexp
+	IncludeGlob []string
+	ExcludeGlob []string
+	Outputs     []*regexp.Regexp
+}
+
+var symlink_policies = []string{"files", "follow", "skip"}
+
+func NewDefaultWalkOptions() WalkOptions {
+
+	opts := WalkOptions{
+		Recursive: true,
+		Symlinks:  "files",
+	}
+
+	return opts
+}
+
+// ParseExtensions splits a comma separated list of extensions, for Extensions
+
+func ParseExtensions(list string) []string {
+
+	extensions := make([]string, 0)
+
+	for _, ext := range strings.Split(list, ",") {
+
+		ext = strings.TrimSpace(ext)
+
+		if ext != "" {
+			extensions = append(extensions, ext)
+		}
+	}
+
+	return extensions
+}
+
+// Validate returns an error if the symlink policy is not one of the ones above
+// or any of the glob patterns are malformed
+
+func (o WalkOptions) Validate() error {
+
+	if !stringsContain(symlink_policies, o.Symlinks) {
+		return fmt.Errorf("Invalid symlink policy '%s'", o.Symlinks)
+	}
+
+	for _, patterns := range [][]string{o.IncludeGlob, o.ExcludeGlob} {
+
+		for _, pattern := range patterns {
+
+			_, err := path.Match(pattern, "")
+
+			if err != nil {
+				return fmt.Errorf("Invalid glob pattern '%s'", pattern)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Match returns true if name, in s, should be included
+
+func (o WalkOptions) Match(s *Source, name string) bool {
+
+	if name != s.Root {
+
+		if !o.matchExtension(path.Ext(name)) {
+			return false
+		}
+
+		for _, re := range o.Outputs {
+
+			if re.MatchString(path.Base(name)) {
+				log.Printf("%s looks like an earlier output, skipping\n", s.Path(name))
+				return false
+			}
+		}
+	}
+
+	abs_path := s.Path(name)
+
+	for _, re := range o.Include {
+
+		if !re.MatchString(abs_path) {
+			return false
+		}
+	}
+
+	for _, re := range o.Exclude {
+
+		if re.MatchString(abs_path) {
+			return false
+		}
+	}
+
+	rel_path := s.RelPath(name)
+
+	for _, pattern := range o.IncludeGlob {
+
+		if !globMatch(pattern, rel_path) {
+			return false
+		}
+	}
+
+	for _, pattern := range o.ExcludeGlob {
+
+		if globMatch(pattern, rel_path) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (o WalkOptions) matchExtension(ext string) bool {
+
+	if len(o.Extensions) == 0 {
+		return ExtensionFormat(ext) != ""
+	}
+
+	ext = strings.ToLower(ext)
+	format := ExtensionFormat(ext)
+
+	for _, allowed := range o.Extensions {
+
+		allowed = strings.ToLower(allowed)
+
+		if !strings.HasPrefix(allowed, ".") {
+			allowed = "." + allowed
+		}
+
+		if allowed == ext {
+			return true
+		}
+
+		if format != "" && ExtensionFormat(allowed) == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+func globMatch(pattern string, rel_path string) bool {
+
+	target := path.Base(rel_path)
+
+	if strings.Contains(pattern, "/") {
+		target = rel_path
+	}
+
+	ok, _ := path.Match(pattern, target)
+	return ok
+}
+
+// walkFiles calls cb with the (FS) path of every file below dir, according to
+// the Recursive and Symlinks options. visited is the set of actual directories
+// (on disk) that have already been seen, when following symlinks.
+
+func (s *Source) walkFiles(dir string, opts WalkOptions, visited map[string]bool, cb func(name string) error) error {
+
+	if opts.Symlinks == "follow" && s.dir != "" {
+
+		real_path, err := filepath.EvalSymlinks(s.Path(dir))
+
+		if err != nil {
+			return err
+		}
+
+		if visited[real_path] {
+			return nil
+		}
+
+		visited[real_path] = true
+	}
+
+	entries, err := fs.ReadDir(s.FS, dir)
+
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+
+		name := path.Join(dir, e.Name())
+		is_dir := e.IsDir()
+
+		if e.Type()&fs.ModeSymlink != 0 {
+
+			if opts.Symlinks == "skip" {
+				continue
+			}
+
+			// broken links are ignored, the same as anything else that
+			// isn't an image
+
+			info, err := fs.Stat(s.FS, name)
+
+			if err != nil {
+				continue
+			}
+
+			is_dir = info.IsDir()
+
+			if is_dir && opts.Symlinks != "follow" {
+				continue
+			}
+		}
+
+		if is_dir {
+
+			if !opts.Recursive {
+				continue
+			}
+
+			err := s.walkFiles(name, opts, visited, cb)
+
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		err := cb(name)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package util
+
+import (
+	"regexp"
+	"testing"
+	"testing/fstest"
+)
+
+func TestWalkOptionsMatch(t *testing.T) {
+
+	// an archive-like source, so that paths are the same everywhere
+
+	s := NewSource(fstest.MapFS{}, "photos", "/export.zip")
+
+	re := regexp.MustCompile
+
+	tests := []struct {
+		name  string
+		file  string
+		opts  func(*WalkOptions)
+		match bool
+	}{
+		{"image", "photos/a.jpg", nil, true},
+		{"upper case extension", "photos/a.JPG", nil, true},
+		{"not an image", "photos/a.txt", nil, false},
+		{"no extension", "photos/README", nil, false},
+		{"root is always included", "photos", nil, true},
+		{"extensions", "photos/a.png", func(o *WalkOptions) { o.Extensions = []string{"jpg"} }, false},
+		{"extensions alias", "photos/a.jpeg", func(o *WalkOptions) { o.Extensions = []string{"jpg"} }, true},
+		{"extensions with dot", "photos/a.png", func(o *WalkOptions) { o.Extensions = []string{".PNG"} }, true},
+		{"extensions unknown", "photos/a.raw", func(o *WalkOptions) { o.Extensions = []string{"raw"} }, true},
+		{"include", "photos/2016/a.jpg", func(o *WalkOptions) { o.Include = []*regexp.Regexp{re("2016")} }, true},
+		{"include no match", "photos/2017/a.jpg", func(o *WalkOptions) { o.Include = []*regexp.Regexp{re("2016")} }, false},
+		{"include all", "photos/2016/a.jpg", func(o *WalkOptions) { o.Include = []*regexp.Regexp{re("2016"), re("b\\.jpg$")} }, false},
+		{"include full path", "photos/a.jpg", func(o *WalkOptions) { o.Include = []*regexp.Regexp{re("^/export\\.zip/")} }, true},
+		{"exclude", "photos/a-thumb.jpg", func(o *WalkOptions) { o.Exclude = []*regexp.Regexp{re("-thumb")} }, false},
+		{"include glob", "photos/a.jpg", func(o *WalkOptions) { o.IncludeGlob = []string{"*.jpg"} }, true},
+		{"include glob no match", "photos/a.png", func(o *WalkOptions) { o.IncludeGlob = []string{"*.jpg"} }, false},
+		{"exclude glob", "photos/2016/a.jpg", func(o *WalkOptions) { o.ExcludeGlob = []string{"a.*"} }, false},
+		{"glob with slash", "photos/2016/a.jpg", func(o *WalkOptions) { o.IncludeGlob = []string{"export/photos/2016/*"} }, true},
+		{"glob with slash no match", "photos/2017/a.jpg", func(o *WalkOptions) { o.IncludeGlob = []string{"export/photos/2016/*"} }, false},
+		{"outputs", "photos/a-crop.jpg", func(o *WalkOptions) { o.Outputs = []*regexp.Regexp{re("^.+-crop\\.[a-z]+$")} }, false},
+		{"outputs no match", "photos/a.jpg", func(o *WalkOptions) { o.Outputs = []*regexp.Regexp{re("^.+-crop\\.[a-z]+$")} }, true},
+		{"outputs filename only", "crop-photos/a.jpg", func(o *WalkOptions) { o.Outputs = []*regexp.Regexp{re("crop")} }, true},
+	}
+
+	for _, test := range tests {
+
+		opts := NewDefaultWalkOptions()
+
+		if test.opts != nil {
+			test.opts(&opts)
+		}
+
+		if opts.Match(s, test.file) != test.match {
+			t.Errorf("%s: expected %s to match %t", test.name, test.file, test.match)
+		}
+	}
+}
+
+func TestWalkOptionsOutputsRoot(t *testing.T) {
+
+	// an image named on the command line is always included, even if it
+	// looks like an image written by an earlier run
+
+	s := NewSource(fstest.MapFS{}, "a-crop.jpg", "/photos")
+
+	opts := NewDefaultWalkOptions()
+	opts.Outputs = []*regexp.Regexp{regexp.MustCompile("^.+-crop\\.[a-z]+$")}
+
+	if !opts.Match(s, "a-crop.jpg") {
+		t.Error("Expected an explicitly named image to match")
+	}
+}
+
+func TestWalkOptionsValidate(t *testing.T) {
+
+	tests := []struct {
+		symlinks string
+		glob     string
+		ok       bool
+	}{
+		{"files", "*.jpg", true},
+		{"follow", "", true},
+		{"skip", "photos/[0-9]*", true},
+		{"sometimes", "", false},
+		{"files", "[", false},
+	}
+
+	for _, test := range tests {
+
+		opts := NewDefaultWalkOptions()
+		opts.Symlinks = test.symlinks
+
+		if test.glob != "" {
+			opts.IncludeGlob = []string{test.glob}
+		}
+
+		err := opts.Validate()
+
+		if (err == nil) != test.ok {
+			t.Errorf("%s %s: expected ok to be %t, got %v", test.symlinks, test.glob, test.ok, err)
+		}
+	}
+}